@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The exported API is recorded in api.txt, so that it never changes by
+// accident (see Version). TestAPI fails when the API differs from the
+// file; if the change is intended, accept it with
+//
+//	go test -run TestAPI -update
+
+var update = flag.Bool("update", false, "write the current API to api.txt")
+
+func TestAPI(t *testing.T) {
+	api, err := exportedAPI(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := strings.Join(api, "\n") + "\n"
+	if *update {
+		if err := os.WriteFile("api.txt", []byte(current), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile("api.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, added := diff(strings.Split(strings.TrimSpace(string(want)), "\n"), api)
+	if len(removed)+len(added) == 0 {
+		return
+	}
+	var b strings.Builder
+	for _, l := range removed {
+		b.WriteString("\n- " + l)
+	}
+	for _, l := range added {
+		b.WriteString("\n+ " + l)
+	}
+	t.Errorf("exported API differs from api.txt; if this is intended, rerun with -update:%s", b.String())
+}
+
+// exportedAPI returns one line per exported identifier of the package in
+// dir, sorted. Struct fields and interface methods get lines of their own.
+func exportedAPI(dir string) ([]string, error) {
+	fset := token.NewFileSet()
+	notTest := func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }
+	pkgs, err := parser.ParseDir(fset, dir, notTest, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var api []string
+	str := func(n ast.Node) string {
+		var b bytes.Buffer
+		printer.Fprint(&b, fset, n)
+		return strings.Join(strings.Fields(b.String()), " ")
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.FuncDecl:
+					if !d.Name.IsExported() {
+						continue
+					}
+					sig := strings.TrimPrefix(str(d.Type), "func")
+					if d.Recv == nil {
+						api = append(api, "func "+d.Name.Name+sig)
+						continue
+					}
+					recv := d.Recv.List[0].Type
+					base := recv
+					if star, ok := base.(*ast.StarExpr); ok {
+						base = star.X
+					}
+					if id, ok := base.(*ast.Ident); ok && id.IsExported() {
+						api = append(api, "method ("+str(recv)+") "+d.Name.Name+sig)
+					}
+				case *ast.GenDecl:
+					api = append(api, genDecl(d, str)...)
+				}
+			}
+		}
+	}
+	sort.Strings(api)
+	return api, nil
+}
+
+// genDecl lists the exported constants, variables, and types of d.
+// Struct fields and interface methods are listed individually.
+func genDecl(d *ast.GenDecl, str func(ast.Node) string) []string {
+	var api []string
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.ValueSpec:
+			for _, name := range s.Names {
+				if !name.IsExported() {
+					continue
+				}
+				line := d.Tok.String() + " " + name.Name
+				if s.Type != nil {
+					line += " " + str(s.Type)
+				}
+				api = append(api, line)
+			}
+		case *ast.TypeSpec:
+			if !s.Name.IsExported() {
+				continue
+			}
+			switch t := s.Type.(type) {
+			case *ast.StructType:
+				api = append(api, "type "+s.Name.Name+" struct")
+				for _, field := range t.Fields.List {
+					for _, name := range field.Names {
+						if name.IsExported() {
+							api = append(api, "field "+s.Name.Name+"."+name.Name+" "+str(field.Type))
+						}
+					}
+					if len(field.Names) == 0 {
+						api = append(api, "embedded "+s.Name.Name+" "+str(field.Type))
+					}
+				}
+			case *ast.InterfaceType:
+				api = append(api, "type "+s.Name.Name+" interface")
+				for _, m := range t.Methods.List {
+					for _, name := range m.Names {
+						api = append(api, "method "+s.Name.Name+"."+name.Name+strings.TrimPrefix(str(m.Type), "func"))
+					}
+					if len(m.Names) == 0 {
+						api = append(api, "embedded "+s.Name.Name+" "+str(m.Type))
+					}
+				}
+			default:
+				assign := " "
+				if s.Assign.IsValid() {
+					assign = " = "
+				}
+				api = append(api, "type "+s.Name.Name+assign+str(s.Type))
+			}
+		}
+	}
+	return api
+}
+
+// diff returns the lines only in want and the lines only in got.
+func diff(want, got []string) (removed, added []string) {
+	in := func(lines []string) map[string]bool {
+		m := make(map[string]bool, len(lines))
+		for _, l := range lines {
+			m[l] = true
+		}
+		return m
+	}
+	w, g := in(want), in(got)
+	for _, l := range want {
+		if !g[l] {
+			removed = append(removed, l)
+		}
+	}
+	for _, l := range got {
+		if !w[l] {
+			added = append(added, l)
+		}
+	}
+	return removed, added
+}
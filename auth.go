@@ -0,0 +1,86 @@
+package pubsub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"sync/atomic"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// Anyone who can connect to a subscriber's publisher, or sit between the
+// two, can slip in messages of their own. With a secret that the
+// publisher and its subscribers share (see Options.AuthKey), the
+// publisher signs each frame with an HMAC-SHA256, which goes into the
+// frame header, and the subscriber skips frames whose MAC is missing or
+// wrong. It counts them (see AuthFailures) and reports them to the
+// function set with OnAuthFailure.
+//
+// The MAC covers the whole frame: the topic, the header with the sequence
+// number, and the payload as sent, that is, compressed and encrypted if it
+// is. Only the flags that mark repeats and unsequenced frames (see seq.go)
+// are left out, as the last-value cache and forwarders set them without
+// knowing the key. A replayed copy of a message keeps its old number;
+// an attacker who marks it as a repeat can at most get it skipped, which
+// dropping it would achieve as well. An unmarked older copy cannot be told
+// from a restart of the publisher, though.
+//
+// The text framing has no header to carry the MAC, so a signing publisher
+// refuses to use it, and a verifying subscriber skips its frames.
+
+// errAuth is what parseMessage returns for a frame that fails
+// verification. Receive never returns it, as such frames are skipped.
+var errAuth = errors.New("message authentication failed")
+
+// OnAuthFailure sets a function that is called for each message that the
+// subscriber skips because it fails verification. The message is as it
+// arrived, with its payload still compressed or encrypted, and must not
+// be trusted. Like the function set with OnGap, it is called from a
+// receiving goroutine and must return quickly.
+func (s *Subscriber) OnAuthFailure(fn func(msg Message)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onAuthFailure = fn
+}
+
+// AuthFailures returns the number of messages that the subscriber skipped
+// because they failed verification.
+func (s *Subscriber) AuthFailures() uint64 {
+	return atomic.LoadUint64(&s.authFailures)
+}
+
+// authFailed counts msg as failed and reports it.
+func (s *Subscriber) authFailed(msg Message) {
+	atomic.AddUint64(&s.authFailures, 1)
+	s.mu.Lock()
+	fn := s.onAuthFailure
+	s.mu.Unlock()
+	if fn != nil {
+		fn(msg)
+	}
+}
+
+// sign returns the MAC of f, which has no MAC yet. The flags that
+// forwarders and last-value caches set on the way are not signed.
+func sign(key []byte, f wire.Frame) ([]byte, error) {
+	f.Header.Repeat, f.Header.Unsequenced = false, false
+	raw, err := wire.Encode(f)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(raw)
+	return h.Sum(nil), nil
+}
+
+// verify tells whether f carries the right MAC.
+func verify(key []byte, f wire.Frame) bool {
+	if f.Format != wire.Binary || f.Header.MAC == "" {
+		return false
+	}
+	mac := []byte(f.Header.MAC)
+	f.Header.MAC = ""
+	want, err := sign(key, f)
+	return err == nil && hmac.Equal(mac, want)
+}
@@ -0,0 +1,85 @@
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// A relay between publisher and subscriber tampers with some of the
+// frames; the subscriber skips and counts those.
+func TestAuthSkipsForgedFrames(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	opts := Options{AuthKey: []byte("shared secret")}
+	p := newTestPublisher(t, up, opts)
+	startRelay(t, up, down, func(f wire.Frame) []wire.Frame {
+		f.Payload = append([]byte(nil), f.Payload...)
+		switch string(f.Payload) {
+		case "payload":
+			f.Payload[0] ^= 1
+		case "header":
+			f.Header.Codec = "forged"
+		case "sequence":
+			f.Header.Seq += 100
+		case "mac":
+			mac := []byte(f.Header.MAC)
+			mac[len(mac)-1] ^= 1
+			f.Header.MAC = string(mac)
+		case "unsigned":
+			f.Header.MAC = ""
+		case "repeat":
+			// Not signed, as the last-value cache sets it.
+			f.Header.Repeat = true
+		}
+		return []wire.Frame{f}
+	})
+	s := newTestSubscriber(t, down, opts, "a", "ping")
+	var failed []string
+	s.OnAuthFailure(func(msg Message) {
+		failed = append(failed, string(msg.Payload))
+	})
+	waitFlow(t, p, s)
+
+	sent := []string{"first", "payload", "header", "sequence", "mac", "unsigned", "repeat", "last"}
+	for _, payload := range sent {
+		if err := p.Publish("a", payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, msg := range receiveTopics(t, s, 3) {
+		got = append(got, string(msg.Payload))
+	}
+	if want := []string{"first", "repeat", "last"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	// The tampered payload arrives as it is.
+	if want := []string{"qayload", "header", "sequence", "mac", "unsigned"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("OnAuthFailure got %v, want %v", failed, want)
+	}
+	if s.AuthFailures() != 5 {
+		t.Errorf("AuthFailures() = %d, want 5", s.AuthFailures())
+	}
+}
+
+func TestAuthRejectsOtherKeysAndText(t *testing.T) {
+	key := []byte("shared secret")
+	f := wire.Frame{Topic: "a", Header: wire.Header{Seq: 1}, Payload: []byte("x")}
+	mac, err := sign(key, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Header.MAC = string(mac)
+	if !verify(key, f) {
+		t.Fatal("valid frame does not verify")
+	}
+	if verify([]byte("other secret"), f) {
+		t.Error("frame verifies with another key")
+	}
+	text := f
+	text.Format, text.Header = wire.Text, wire.Header{}
+	if verify(key, text) {
+		t.Error("text frame verifies")
+	}
+}
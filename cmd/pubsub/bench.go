@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// `pubsub bench [-url URL] [-subscribers n] [-size bytes] [-rate n] [-duration d]` measures how
+// many messages per second one publisher gets to n subscribers on this machine, and how long they
+// take. Publisher and subscribers run in this process. With the default URL, inproc://bench, the
+// messages never leave the process; with a tcp:// URL, such as tcp://127.0.0.1:0, they go through
+// the network stack, and the difference is the cost of the transport.
+//
+// The publisher sends messages of -size bytes, -rate per second (as fast as it can if -rate is 0),
+// for -duration. Each payload starts with a benchmark envelope: a sequence number and the time of
+// sending. The subscribers count what they receive, tell the lost messages by the gaps in the
+// sequence numbers, and record the latencies in a histogram. At the end, bench prints a table with
+// one row per subscriber.
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	url := flags.String("url", "inproc://bench", "URL to publish on, for example tcp://127.0.0.1:0")
+	subscribers := flags.Int("subscribers", 1, "number of subscribers")
+	size := flags.Int("size", 100, fmt.Sprintf("payload size in `bytes` (at least %d)", envelopeSize))
+	rate := flags.Int("rate", 0, "messages per second, or 0 for as many as possible")
+	duration := flags.Duration("duration", 5*time.Second, "how long to publish")
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	if err := logFlags.setup(); err != nil {
+		return err
+	}
+	switch {
+	case *subscribers < 1:
+		return errors.New("bench: -subscribers must be at least 1")
+	case *size < envelopeSize:
+		return fmt.Errorf("bench: -size must be at least %d", envelopeSize)
+	case *rate < 0:
+		return errors.New("bench: -rate must not be negative")
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	publisher, err := pubsub.NewPublisher(*url)
+	if err != nil {
+		return err
+	}
+	defer publisher.Close()
+	addr := publisher.Addr()
+
+	// The subscribers must be connected before the clock starts, or they
+	// miss the first messages, which would count as lost.
+	results := make([]*benchResult, *subscribers)
+	subs := make([]*pubsub.Subscriber, *subscribers)
+	for i := range subs {
+		subs[i], err = pubsub.NewSubscriber(addr, benchTopic)
+		if err != nil {
+			return err
+		}
+		defer subs[i].Close()
+		wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = subs[i].WaitConnected(wctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("bench: subscriber %d did not connect: %w", i+1, err)
+		}
+		results[i] = &benchResult{}
+	}
+
+	// Once the publisher is done, a subscriber waits for what is still
+	// under way until it hears nothing for benchDrain.
+	published := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, s := range subs {
+		wg.Add(1)
+		go func(s *pubsub.Subscriber, r *benchResult) {
+			defer wg.Done()
+			r.receive(s, published)
+		}(s, results[i])
+	}
+
+	slog.Info("Benchmarking", "url", addr, "subscribers", *subscribers, "size", *size, "rate", *rate, "duration", *duration)
+	sent, elapsed, err := benchPublish(ctx, publisher, *size, *rate, *duration)
+	close(published)
+	wg.Wait()
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	fmt.Printf("Published %d messages of %d bytes in %v: %.0f msg/s, %.2f MB/s\n",
+		sent, *size, elapsed.Round(time.Millisecond),
+		float64(sent)/elapsed.Seconds(), float64(sent)*float64(*size)/elapsed.Seconds()/1e6)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "SUBSCRIBER\tRECEIVED\tLOST\tMSG/S\tMB/S\tP50\tP95\tP99\tMAX\t")
+	for i, r := range results {
+		r.finish(sent)
+		window := r.last.Sub(r.first).Seconds()
+		var perSec, mbPerSec float64
+		if window > 0 {
+			perSec = float64(r.received) / window
+			mbPerSec = float64(r.bytes) / window / 1e6
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.0f\t%.2f\t%v\t%v\t%v\t%v\t\n", i+1, r.received, r.lost, perSec, mbPerSec,
+			r.latency.quantile(0.50), r.latency.quantile(0.95), r.latency.quantile(0.99), r.latency.max)
+	}
+	return tw.Flush()
+}
+
+// benchTopic is the topic of the benchmark messages.
+const benchTopic = "bench"
+
+// benchDrain is how long subscribers wait for stragglers after the
+// publisher is done.
+const benchDrain = 500 * time.Millisecond
+
+// The benchmark envelope is the first envelopeSize bytes of a payload: the
+// sequence number, counting from 0, and the time of sending in nanoseconds
+// since 1970, both as big-endian uint64. The rest of the payload is
+// padding.
+const envelopeSize = 16
+
+func stamp(payload []byte, seq uint64, sent time.Time) {
+	binary.BigEndian.PutUint64(payload, seq)
+	binary.BigEndian.PutUint64(payload[8:], uint64(sent.UnixNano()))
+}
+
+func readStamp(payload []byte) (seq uint64, sent time.Time, ok bool) {
+	if len(payload) < envelopeSize {
+		return 0, time.Time{}, false
+	}
+	seq = binary.BigEndian.Uint64(payload)
+	sent = time.Unix(0, int64(binary.BigEndian.Uint64(payload[8:])))
+	return seq, sent, true
+}
+
+// benchPublish publishes messages of size bytes at rate for d, or until
+// ctx is done, and returns how many it sent and how long that took.
+func benchPublish(ctx context.Context, publisher *pubsub.Publisher, size, rate int, d time.Duration) (sent uint64, elapsed time.Duration, err error) {
+	payload := make([]byte, size)
+	limit := newLimiter(rate)
+	start := time.Now()
+	for time.Since(start) < d {
+		if err = limit.wait(ctx); err != nil {
+			break
+		}
+		stamp(payload, sent, time.Now())
+		// The publisher copies the payload into the frame, so it can be
+		// reused.
+		if err = publisher.PublishMessage(pubsub.Message{Topic: benchTopic, Payload: payload}); err != nil {
+			break
+		}
+		sent++
+	}
+	return sent, time.Since(start), err
+}
+
+// A limiter paces a loop to a number of rounds per second. It keeps to a
+// fixed timetable rather than waiting a fixed time after each round, so a
+// round that took long is made up by the next ones. (Schedule waits a
+// fixed time and uses a timer for each pause, which is too coarse for
+// thousands of messages per second; limiter only shares sleep with it.)
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// newLimiter returns a limiter for rate rounds per second. A rate of 0
+// means no limit.
+func newLimiter(rate int) *limiter {
+	l := &limiter{next: time.Now()}
+	if rate > 0 {
+		l.interval = time.Second / time.Duration(rate)
+	}
+	return l
+}
+
+// wait waits until the next round is due, or until ctx is done.
+func (l *limiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return ctx.Err()
+	}
+	err := sleep(ctx, time.Until(l.next))
+	l.next = l.next.Add(l.interval)
+	return err
+}
+
+// benchResult is what one subscriber measured.
+type benchResult struct {
+	received, bytes uint64
+	lost            uint64
+	next            uint64 // the sequence number expected next
+	first, last     time.Time
+	latency         histogram
+}
+
+// receive receives benchmark messages until published is closed and no
+// message has arrived for benchDrain, or until s is closed.
+func (r *benchResult) receive(s *pubsub.Subscriber, published <-chan struct{}) {
+	s.SetRecvDeadline(benchDrain)
+	for {
+		msg, err := s.ReceiveMessage()
+		if errors.Is(err, pubsub.ErrTimeout) {
+			select {
+			case <-published:
+				return
+			default:
+				continue
+			}
+		}
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		seq, sent, ok := readStamp(msg.Payload)
+		if !ok || seq < r.next {
+			continue
+		}
+		if r.received == 0 {
+			r.first = now
+		}
+		r.last = now
+		r.received++
+		r.bytes += uint64(len(msg.Payload))
+		r.lost += seq - r.next
+		r.next = seq + 1
+		r.latency.record(now.Sub(sent))
+	}
+}
+
+// finish counts the messages after the last one received as lost, too.
+func (r *benchResult) finish(sent uint64) {
+	if sent > r.next {
+		r.lost += sent - r.next
+		r.next = sent
+	}
+}
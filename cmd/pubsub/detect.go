@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// When `pubsub sub -pretty` looks at a stream it knows nothing about, it
+// renders each payload according to the codec named in the message. If
+// there is none, it guesses the format from the first bytes. A guess never
+// fails: anything unrecognized is shown as a hex dump.
+
+// maxInflated limits how much of a gzip payload gets decompressed for
+// display.
+const maxInflated = 1 << 20
+
+// renderMessage renders msg according to its codec, or by sniffing.
+func renderMessage(msg pubsub.Message) (label, text string) {
+	switch msg.Codec {
+	case "":
+		return render(msg.Payload)
+	case pubsub.JSONCodec.Name():
+		var b bytes.Buffer
+		if json.Indent(&b, msg.Payload, "", "  ") == nil {
+			return msg.Codec, b.String()
+		}
+	}
+	return msg.Codec, hex.Dump(msg.Payload)
+}
+
+// render returns a label for the detected format and the payload rendered
+// for a human reader.
+func render(payload []byte) (label, text string) {
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			break
+		}
+		inflated, err := ioutil.ReadAll(io.LimitReader(r, maxInflated))
+		if err != nil {
+			break
+		}
+		label, text = render(inflated)
+		return "gzip+" + label, text
+	case isJSON(payload):
+		var b bytes.Buffer
+		if json.Indent(&b, bytes.TrimSpace(payload), "", "  ") == nil {
+			return "json", b.String()
+		}
+	case len(payload) > 0 && payload[0]&0xf0 == 0x80:
+		// A msgpack fixmap. There is no msgpack decoder here, so show
+		// the bytes, but say what they probably are.
+		return "msgpack", hex.Dump(payload)
+	case isText(payload):
+		return "text", string(payload)
+	}
+	return "binary", hex.Dump(payload)
+}
+
+// isJSON reports whether payload is a JSON object or array.
+func isJSON(payload []byte) bool {
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return false
+	}
+	return json.Valid(trimmed)
+}
+
+// isText reports whether payload is valid UTF-8 without control
+// characters other than whitespace.
+func isText(payload []byte) bool {
+	if !utf8.Valid(payload) {
+		return false
+	}
+	for _, r := range string(payload) {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
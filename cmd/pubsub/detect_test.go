@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"testing"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// gzipped returns s compressed with gzip.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestRender(t *testing.T) {
+	fixmap := []byte{0x81, 0xa1, 'a', 0x01} // {"a": 1}
+	control := []byte("ok\x00\x07bell")
+	tests := []struct {
+		name    string
+		payload []byte
+		label   string
+		text    string
+	}{
+		{"json object", []byte(` {"a":[1,2]} `), "json", "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+		{"json array", []byte(`[true]`), "json", "[\n  true\n]"},
+		{"invalid json", []byte(`{"a":`), "text", `{"a":`},
+		{"json scalar", []byte(`42`), "text", "42"},
+		{"gzip json", gzipped(t, `{"a":1}`), "gzip+json", "{\n  \"a\": 1\n}"},
+		{"gzip text", gzipped(t, "hello"), "gzip+text", "hello"},
+		{"corrupt gzip", []byte{0x1f, 0x8b, 0xff}, "binary", hex.Dump([]byte{0x1f, 0x8b, 0xff})},
+		{"msgpack fixmap", fixmap, "msgpack", hex.Dump(fixmap)},
+		{"text", []byte("Sunny, 22°C\n\tlight wind"), "text", "Sunny, 22°C\n\tlight wind"},
+		{"empty", nil, "text", ""},
+		{"control characters", control, "binary", hex.Dump(control)},
+		{"invalid utf-8", []byte{'a', 0xff}, "binary", hex.Dump([]byte{'a', 0xff})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			label, text := render(tt.payload)
+			if label != tt.label || text != tt.text {
+				t.Errorf("render(%q) = %s, %q; want %s, %q", tt.payload, label, text, tt.label, tt.text)
+			}
+		})
+	}
+}
+
+// A codec in the message wins over sniffing.
+func TestRenderMessage(t *testing.T) {
+	tests := []struct {
+		msg   pubsub.Message
+		label string
+		text  string
+	}{
+		{pubsub.Message{Payload: []byte(`{"a":1}`)}, "json", "{\n  \"a\": 1\n}"},
+		{pubsub.Message{Payload: []byte(`{"a":1}`), Codec: "json"}, "json", "{\n  \"a\": 1\n}"},
+		{pubsub.Message{Payload: []byte(`{"a":`), Codec: "json"}, "json", hex.Dump([]byte(`{"a":`))},
+		{pubsub.Message{Payload: []byte("hello"), Codec: "gob"}, "gob", hex.Dump([]byte("hello"))},
+	}
+	for _, tt := range tests {
+		label, text := renderMessage(tt.msg)
+		if label != tt.label || text != tt.text {
+			t.Errorf("renderMessage(%q, codec %q) = %s, %q; want %s, %q", tt.msg.Payload, tt.msg.Codec, label, text, tt.label, tt.text)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"strings"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// `pubsub forward [-from URL[,URL...]] [-to URL[,URL...]] [-rules file] [-cert file -key file -ca file]`
+// relays all messages from the publishers at -from to the subscribers that dial into -to, until the
+// process is interrupted. The TLS flags apply to the TLS URLs on either side. With -rules, the
+// forwarder rewrites, drops, or samples messages as the rules file says (see rules.go), and reloads
+// the file on SIGHUP.
+func runForward(args []string) error {
+	flags := flag.NewFlagSet("forward", flag.ExitOnError)
+	from := flags.String("from", defaultURL, "URL of the upstream publisher, or a comma-separated list of URLs")
+	to := flags.String("to", "tcp://localhost:56567", "URL to listen on for subscribers, or a comma-separated list of URLs")
+	rules := flags.String("rules", "", "JSON file with forwarding rules")
+	tlsFlags := addTLSFlags(flags)
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	if err := logFlags.setup(); err != nil {
+		return err
+	}
+
+	upstream, downstream := strings.Split(*from, ","), strings.Split(*to, ",")
+	var upOpts, downOpts pubsub.Options
+	var err error
+	if anyTLS(upstream) {
+		upOpts.TLSConfig, err = tlsFlags.config(false)
+		if err != nil {
+			return err
+		}
+	}
+	if anyTLS(downstream) {
+		downOpts.TLSConfig, err = tlsFlags.config(true)
+		if err != nil {
+			return err
+		}
+	}
+	forwarder, err := pubsub.NewForwarder(upstream, upOpts, downstream, downOpts)
+	if err != nil {
+		return err
+	}
+	defer forwarder.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+	if *rules != "" {
+		err = loadRules(forwarder, *rules)
+		if err != nil {
+			return err
+		}
+		reloadRules(ctx, forwarder, *rules)
+	}
+	slog.Info("Forwarding", "from", *from, "to", strings.Join(forwarder.Addrs(), ","))
+	err = forwarder.Run(ctx)
+	slog.Info("Forwarder stops", "forwarded", forwarder.Forwarded(), "dropped", forwarder.Dropped(), "filtered", forwarder.Filtered())
+	return err
+}
+
+// anyTLS tells whether any of the URLs uses TLS.
+func anyTLS(urls []string) bool {
+	for _, url := range urls {
+		if strings.HasPrefix(url, "tls+tcp://") || strings.HasPrefix(url, "wss://") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// A histogram counts durations in buckets that get wider as the durations
+// get longer, like an HDR histogram: below 2*subBuckets nanoseconds, each
+// nanosecond has a bucket; above, each power of two is split into
+// subBuckets buckets. A quantile is thus off by at most 1/subBuckets, or
+// about 3%, no matter whether the latencies are microseconds or seconds.
+const (
+	subBucketBits = 5
+	subBuckets    = 1 << subBucketBits
+)
+
+type histogram struct {
+	counts []uint64
+	total  uint64
+	max    time.Duration
+}
+
+// bucket returns the index of the bucket for v nanoseconds.
+func bucket(v uint64) int {
+	if v < 2*subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits - 1
+	return shift*subBuckets + int(v>>uint(shift))
+}
+
+// bucketLimit returns the largest value in bucket i.
+func bucketLimit(i int) uint64 {
+	if i < 2*subBuckets {
+		return uint64(i)
+	}
+	shift := i/subBuckets - 1
+	top := uint64(i - shift*subBuckets)
+	return (top+1)<<uint(shift) - 1
+}
+
+// record adds d. Negative durations, which clocks that are set back can
+// cause, count as zero.
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := bucket(uint64(d))
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// quantile returns the duration that the fraction q of the recorded
+// durations do not exceed, or 0 if there are none.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			if d := time.Duration(bucketLimit(i)); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// exactQuantile returns the duration that the fraction q of ds do not
+// exceed, by sorting.
+func exactQuantile(ds []time.Duration, q float64) time.Duration {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	if rank == 0 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func TestQuantile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	repeat := func(n int, d time.Duration) []time.Duration {
+		ds := make([]time.Duration, n)
+		for i := range ds {
+			ds[i] = d
+		}
+		return ds
+	}
+	uniform := func(n int, max time.Duration) []time.Duration {
+		ds := make([]time.Duration, n)
+		for i := range ds {
+			ds[i] = time.Duration(rng.Int63n(int64(max)))
+		}
+		return ds
+	}
+	exponential := func(n int, mean time.Duration) []time.Duration {
+		ds := make([]time.Duration, n)
+		for i := range ds {
+			ds[i] = time.Duration(rng.ExpFloat64() * float64(mean))
+		}
+		return ds
+	}
+
+	tests := []struct {
+		name string
+		ds   []time.Duration
+	}{
+		{"single", []time.Duration{42 * time.Millisecond}},
+		{"constant", repeat(1000, 250*time.Microsecond)},
+		{"nanoseconds", uniform(1000, 2*subBuckets)},
+		{"uniform", uniform(10000, 10*time.Millisecond)},
+		{"exponential", exponential(10000, time.Millisecond)},
+		{"bimodal", append(repeat(900, time.Millisecond), repeat(100, time.Second)...)},
+		{"negative", append(repeat(10, -time.Millisecond), repeat(90, time.Microsecond)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h histogram
+			for _, d := range tt.ds {
+				h.record(d)
+			}
+			for _, q := range []float64{0, 0.5, 0.95, 0.99, 1} {
+				want := exactQuantile(tt.ds, q)
+				if want < 0 {
+					want = 0
+				}
+				got := h.quantile(q)
+				// A quantile is the upper limit of its bucket, but not
+				// beyond the maximum.
+				if got < want || float64(got) > float64(want)*(1+1.0/subBuckets) {
+					t.Errorf("quantile(%v) = %v, want %v within %.1f%%", q, got, want, 100.0/subBuckets)
+				}
+			}
+		})
+	}
+}
+
+func TestQuantileEmpty(t *testing.T) {
+	var h histogram
+	if got := h.quantile(0.5); got != 0 {
+		t.Errorf("quantile(0.5) of nothing = %v, want 0", got)
+	}
+}
+
+// Each value falls into the bucket whose limit is the first one not below
+// it.
+func TestBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 63, 64, 65, 127, 128, 1000, 1 << 20, 1<<20 + 1, 1<<40 - 1, math.MaxInt64} {
+		i := bucket(v)
+		if bucketLimit(i) < v || i > 0 && bucketLimit(i-1) >= v {
+			t.Errorf("bucket(%d) = %d with limits %d..%d", v, i, bucketLimit(i-1)+1, bucketLimit(i))
+		}
+	}
+}
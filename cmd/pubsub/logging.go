@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Progress and errors are logged through log/slog, so that they can be
+// filtered by level and, with -log-format json, parsed by other tools.
+// The records go to stderr. The messages that `pubsub sub` prints are
+// its output, not log records, and go to stdout.
+
+// logFlags holds the logging flags of a flag set.
+type logFlags struct {
+	level, format *string
+}
+
+func addLogFlags(flags *flag.FlagSet) logFlags {
+	return logFlags{
+		level:  flags.String("log-level", "info", "minimum `level` to log: debug, info, warn, or error"),
+		format: flags.String("log-format", "text", "log `format`: text or json"),
+	}
+}
+
+// setup makes the logger that the flags describe the default logger,
+// which the pubsub package uses, too.
+func (f logFlags) setup() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*f.level)); err != nil {
+		return fmt.Errorf("invalid -log-level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch *f.format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -log-format %q: want text or json", *f.format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs err and exits. It is only for failures while setting up.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,500 @@
+/*
+<!--
+Copyright (c) 2016 Christoph Berger. Some rights reserved.
+Use of this text is governed by a Creative Commons Attribution Non-Commercial
+Share-Alike License that can be found in the LICENSE.txt file.
+
+The source code contained in this file may import third-party source code
+whose licenses are provided in the respective license files.
+-->
+
+This is the demo from the article "Message Queues Part 2: The PubSub Protocol".
+The publisher and subscriber code lives in the pubsub package at the root of
+this repository; this file only puts it to use.
+*/
+
+// Command pubsub runs the demo: without arguments, it starts three clients
+// as child processes and then publishes messages for them; with arguments,
+// it runs as one of these clients. With -inprocess, the server and the
+// clients run as goroutines of a single process and talk over
+// "inproc://demo". "pubsub version" prints the version, "pubsub sub"
+// prints the messages of a running publisher, "pubsub forward" relays
+// them to further subscribers, "pubsub pub" publishes the lines of stdin,
+// "pubsub replay" publishes a journal again, and "pubsub bench" measures
+// throughput and latency.
+//
+// The flags -url, -cert, -key, and -ca, which must come before any other
+// arguments, select the URL and the TLS certificates (see tls.go), for
+// example:
+//
+//	pubsub -url tls+tcp://localhost:56565 -cert pub.pem -key pub.key -ca ca.pem
+//
+// The publisher also listens on the URL given with -local, which the third
+// client uses. Set it to "" to use -url only; TLS does that, too. With
+// -metrics-addr, the server serves the publisher's metrics over HTTP (see
+// metrics.go). With -journal, it records every message in a journal
+// directory, which "pubsub replay" can publish again (see replay.go). With
+// -record, the clients write the messages they receive to files (see
+// record.go).
+package main
+
+// ### Imports
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// Now it is time to set up the server. Besides the publisher we also pass a
+// schedule that tells the server which topics to send messages for, how often,
+// and at which pace. (See schedule.go.) If ready is not nil, the server first
+// waits for the clients to get ready. (See ready.go.)
+func runServer(ctx context.Context, publisher *pubsub.Publisher, ready *readiness, schedule Schedule) error {
+	for _, addr := range publisher.Addrs() {
+		slog.Info("Listening", "url", addr)
+	}
+	if ready != nil {
+		n := ready.wait(ctx)
+		if n < ready.expected {
+			slog.Warn("Not all clients are ready, publishing anyway", "ready", n, "expected", ready.expected)
+		}
+	}
+	return schedule.Run(ctx, func(topic, payload string) error {
+		slog.Info("Publishing", "topic", topic)
+		err := publisher.Publish(topic, payload)
+		if err != nil {
+			return fmt.Errorf("cannot publish message for topic %s: %w", topic, err)
+		}
+		return nil
+	})
+}
+
+// Client setup is also easy. Errors are returned rather than fatal, so that
+// a failing client does not take the other clients down with it when they
+// all run in the same process. The client stops early when ctx is done.
+// If record is not empty, the client records the messages it receives
+// (see record.go). Unless ready is empty, the client reports to the
+// readiness socket at ready once it is connected (see ready.go).
+func runClient(ctx context.Context, name, url, ready string, opts pubsub.Options, record string, topics []string) (err error) {
+	// We create a subscriber that dials into the publisher and subscribes to
+	// the topics that were passed in as a parameter. A `*` stands for all topics.
+	subscriber, err := pubsub.NewSubscriberWithOptions(url, opts)
+	if err != nil {
+		return fmt.Errorf("client %s: %w", name, err)
+	}
+	defer subscriber.Close()
+	for _, topic := range topics {
+		if topic == "*" {
+			err = subscriber.SubscribeAll()
+		} else {
+			err = subscriber.Subscribe(topic)
+		}
+		if err != nil {
+			return fmt.Errorf("client %s: %w", name, err)
+		}
+	}
+	if record != "" {
+		stopRecording, rerr := startRecording(subscriber, clientRecordFile(record, name))
+		if rerr != nil {
+			return fmt.Errorf("client %s: %w", name, rerr)
+		}
+		defer func() {
+			if rerr := stopRecording(); rerr != nil && err == nil {
+				err = fmt.Errorf("client %s: cannot record messages: %w", name, rerr)
+			}
+		}()
+	}
+	// Once connected, we tell the server that we are ready to receive.
+	// Without this, we might miss the first messages. (See ready.go.)
+	err = subscriber.WaitConnected(ctx)
+	if err != nil {
+		return nil
+	}
+	if ready != "" {
+		if err := signalReady(ready, name); err != nil {
+			slog.Warn("Cannot report readiness", "client", name, "error", err)
+		}
+	}
+	// From now on, we watch whether the topics keep their pace.
+	watchPaces(subscriber, name, topics)
+	// When we are done, and on SIGUSR1 in between, we print what we
+	// received per topic. (See stats.go.)
+	statsCtx, stopStats := context.WithCancel(ctx)
+	defer stopStats()
+	go reportStats(statsCtx, name, subscriber)
+	defer func() {
+		writeStats(os.Stdout, name, subscriber.Stats())
+	}()
+	// Finally, we listen for new message and print out any that matches
+	// one of the topics we subscribed to. A quiet period is no reason to
+	// give up, so we just keep waiting after a timeout. A malformed message
+	// is not worth giving up either, and once the subscriber is closed or
+	// the context is done, there is nothing left to receive.
+	for received := 0; received < 5*len(topics); {
+		topic, message, err := subscriber.ReceiveContext(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, pubsub.ErrTimeout):
+			continue
+		case errors.Is(err, pubsub.ErrBadEnvelope), errors.Is(err, pubsub.ErrDecrypt):
+			slog.Warn("Skipping message", "client", name, "error", err)
+			continue
+		case errors.Is(err, pubsub.ErrClosed):
+			return nil
+		case err != nil:
+			return fmt.Errorf("client %s: error receiving message: %w", name, err)
+		}
+		slog.Info("Received", "client", name, "topic", topic, "message", message)
+		received++
+	}
+	return nil
+}
+
+// The demo has three clients with different subscriptions. One of them is a
+// local client that connects through a local endpoint, while the others use
+// the main URL, like remote clients would. The publisher listens on both.
+var demoClients = []struct {
+	name   string
+	topics []string
+	local  bool
+}{
+	{"C1", []string{"Technology"}, false},
+	{"C2", []string{"Technology", "Weather"}, false},
+	{"C3", []string{"Finance"}, true},
+}
+
+// ...and a server that sends a message for each topic, once per second, a
+// couple of times.
+var demoSchedule = Schedule{
+	Rounds:   5,
+	Interval: 1 * time.Second,
+	Topics:   []string{"Technology", "Weather", "Finance"},
+}
+
+// Each topic comes every three seconds. Technology is critical: if it
+// falls silent, the client fails its health check. Weather is relaxed:
+// it is only logged as stale after twice its interval.
+var demoPaces = []struct {
+	topic        string
+	every, slack time.Duration
+	critical     bool
+}{
+	{"Technology", 3 * time.Second, 2 * time.Second, true},
+	{"Weather", 3 * time.Second, 3 * time.Second, false},
+}
+
+// watchPaces watches the topics of demoPaces among topics and reports
+// when the health check of the subscriber fails.
+func watchPaces(subscriber *pubsub.Subscriber, name string, topics []string) {
+	subscriber.OnStale(func(topic string, stale bool, last time.Time) {
+		if err := subscriber.Health(); stale && err != nil {
+			slog.Error("Health check failed", "client", name, "error", err)
+		}
+	})
+	for _, pace := range demoPaces {
+		for _, topic := range topics {
+			if topic == pace.topic {
+				subscriber.SetCritical(topic, pace.critical)
+				subscriber.ExpectEvery(topic, pace.every, pace.slack)
+			}
+		}
+	}
+}
+
+// clientArgs returns the command line for the i-th demo client. A local
+// client gets the local URL instead of the main URL, unless it is empty.
+// ready holds the addresses of the readiness socket, one per URL.
+func clientArgs(flags []string, local string, ready []string, i int) []string {
+	args := append([]string{}, flags...)
+	// The last -url and -ready win.
+	if demoClients[i].local && local != "" {
+		args = append(args, "-url", local, "-ready", ready[1])
+	} else {
+		args = append(args, "-ready", ready[0])
+	}
+	args = append(args, demoClients[i].name)
+	return append(args, demoClients[i].topics...)
+}
+
+// runInProcess runs the server and the clients as goroutines instead of
+// processes. They talk through the inproc transport, so the demo needs
+// neither the executable in the working directory nor a free port.
+func runInProcess(ctx context.Context, url, metricsAddr, journal, record string) error {
+	slog.Info("Starting the server")
+	publisher, err := pubsub.NewPublisherWithOptions(url, pubsub.Options{Journal: journal})
+	if err != nil {
+		return err
+	}
+	defer publisher.Close()
+	defer serveMetrics(metricsAddr, publisher)()
+	// With a URL like tcp://127.0.0.1:0, the clients need the actual port.
+	url = publisher.Addr()
+	ready, err := listenReady([]string{url}, len(demoClients))
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	errs := make(chan error, len(demoClients))
+	for _, c := range demoClients {
+		slog.Info("Starting client", "client", c.name)
+		go func(name string, topics []string) {
+			errs <- runClient(ctx, name, url, ready.addrs[0], pubsub.Options{}, record, topics)
+		}(c.name, c.topics)
+	}
+
+	err = runServer(ctx, publisher, ready, demoSchedule)
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	slog.Info("Waiting for the clients to exit")
+	for range demoClients {
+		if cerr := <-errs; cerr != nil {
+			slog.Error("Client failed", "error", cerr)
+			if err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// runProcesses runs the server in this process and the clients as child
+// processes. If the server or a client fails, all clients are stopped.
+// Unless local is empty, the server listens on it, too, and the clients
+// marked as local use it.
+func runProcesses(ctx context.Context, url, local, metricsAddr, journal string, flags []string, certs tlsFlags) error {
+	// First, start the server, so that the clients can learn where it
+	// waits for them to get ready.
+	slog.Info("Starting the server")
+	urls := []string{url}
+	if local != "" {
+		urls = append(urls, local)
+	}
+	publisher, ready, err := startServer(urls, journal, certs)
+	if err != nil {
+		return err
+	}
+	// Closing the readiness socket right after the last acknowledgement
+	// could cut it off, so it stays open until the end.
+	defer ready.Close()
+
+	// Then, spawn the clients.
+	// We use the `Cmd` type from the `os.exec` package to spawn the clients
+	// as subprocesses in a convenient way. (See supervise.go.)
+	clients, err := startClients(flags, local, ready.addrs)
+	if err != nil {
+		publisher.Close()
+		return err
+	}
+	// A failing client stops the server, too.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	clientsDone := make(chan error, 1)
+	go func() {
+		err := clients.wait()
+		if err != nil {
+			cancel()
+		}
+		clientsDone <- err
+	}()
+
+	// Start publishing: Loop through the topics and send a message for each
+	// one, once per second. Repeat a couple of times.
+	err = serve(ctx, publisher, ready, metricsAddr)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		clients.kill()
+		<-clientsDone
+		return err
+	}
+	if ctx.Err() != nil {
+		// The clients would otherwise wait for messages that never come.
+		clients.signal(os.Interrupt)
+	}
+
+	// Wait for all clients to finish.
+	slog.Info("Waiting for the clients to exit")
+	return <-clientsDone
+}
+
+// startServer creates the publisher for the given URLs and the readiness
+// socket next to each of its addresses. Unless journal is empty, the
+// messages are recorded there.
+func startServer(urls []string, journal string, certs tlsFlags) (*pubsub.Publisher, *readiness, error) {
+	config, err := certs.config(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	publisher, err := pubsub.NewPublisherURLs(urls, pubsub.Options{TLSConfig: config, Journal: journal})
+	if err != nil {
+		return nil, nil, err
+	}
+	ready, err := listenReady(publisher.Addrs(), len(demoClients))
+	if err != nil {
+		publisher.Close()
+		return nil, nil, err
+	}
+	return publisher, ready, nil
+}
+
+// serve publishes the demo messages, and the metrics at metricsAddr,
+// unless it is empty. It closes the publisher when it is done.
+func serve(ctx context.Context, publisher *pubsub.Publisher, ready *readiness, metricsAddr string) error {
+	defer serveMetrics(metricsAddr, publisher)()
+	err := runServer(ctx, publisher, ready, demoSchedule)
+
+	// Closing the publisher sends out what is still queued, but Mangos
+	// may cut off a message that is just being written. So we give the
+	// clients a moment to consume the messages first, unless we were
+	// told to stop.
+	sleep(ctx, 1*time.Second)
+	publisher.Close()
+	return err
+}
+
+// The socket URL, and the local one.
+const (
+	defaultURL      = "tcp://localhost:56565"
+	defaultLocalURL = "ipc:///tmp/pubsub-demo.ipc"
+)
+
+// Putting it all together...
+func main() {
+
+	// `pubsub version` reports the version of the pubsub package.
+	if len(os.Args) == 2 && os.Args[1] == "version" {
+		fmt.Println("pubsub", pubsub.Version)
+		return
+	}
+
+	// `pubsub sub` is a generic subscriber for inspecting a stream. (See sub.go.)
+	if len(os.Args) >= 2 && os.Args[1] == "sub" {
+		if err := runSub(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// `pubsub forward` relays messages to further subscribers. (See forward.go.)
+	if len(os.Args) >= 2 && os.Args[1] == "forward" {
+		if err := runForward(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// `pubsub pub` publishes the lines of stdin. (See pub.go.)
+	if len(os.Args) >= 2 && os.Args[1] == "pub" {
+		if err := runPub(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// `pubsub replay` publishes a journal again. (See replay.go.)
+	if len(os.Args) >= 2 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// `pubsub bench` measures throughput and latency. (See bench.go.)
+	if len(os.Args) >= 2 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	url := flag.String("url", defaultURL, "socket `URL`")
+	local := flag.String("local", defaultLocalURL, "additional local `URL` for the local client (none if empty or with TLS)")
+	inprocess := flag.Bool("inprocess", false, "run the server and the clients in this process (URL defaults to inproc://demo)")
+	metricsAddr := flag.String("metrics-addr", "", "serve the publisher's metrics at http://`ADDR`/metrics (clients ignore it)")
+	journal := flag.String("journal", "", "record the published messages in the journal `directory` (clients ignore it)")
+	record := flag.String("record", "", "record the received messages in `file`, with the client name added (the server ignores it)")
+	ready := flag.String("ready", "", "report to the readiness socket at `URL` once connected (the server sets it for its clients)")
+	tlsFlags := addTLSFlags(flag.CommandLine)
+	logFlags := addLogFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logFlags.setup(); err != nil {
+		fatal(err)
+	}
+	// The clients get the same flags as the server.
+	flags := os.Args[1 : len(os.Args)-flag.NArg()]
+
+	// SIGINT and SIGTERM end the demo gracefully: the server and the clients
+	// stop and close their sockets.
+	ctx, stop := signalContext()
+	defer stop()
+
+	// With -inprocess, everything runs in this process.
+	if *inprocess {
+		if *url == defaultURL {
+			*url = "inproc://demo"
+		}
+		err := runInProcess(ctx, *url, *metricsAddr, *journal, *record)
+		stop()
+		if err != nil {
+			fatal(err)
+		}
+		slog.Info("Server ends")
+		return
+	}
+
+	// Without parameters, the process starts as the server.
+	if flag.NArg() == 0 {
+		if tlsFlags.set() {
+			// The local client would get the TLS flags, too.
+			*local = ""
+		}
+		err := runProcesses(ctx, *url, *local, *metricsAddr, *journal, flags, tlsFlags)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		slog.Info("Server ends")
+	} else {
+
+		// One or more parameters means this process is a client.
+		name := flag.Arg(0)
+		config, err := tlsFlags.config(false)
+		if err != nil {
+			fatal(err)
+		}
+		slog.Info("Client starting", "client", name)
+		err = runClient(ctx, name, *url, *ready, pubsub.Options{TLSConfig: config}, *record, flag.Args()[1:])
+		if err != nil {
+			fatal(err)
+		}
+		slog.Info("Client ends", "client", name)
+	}
+}
+
+/*
+Get this code from github:
+
+	git clone https://github.com/appliedgo/pubsub
+	cd pubsub/cmd/pubsub
+	go build
+	./pubsub
+
+(go build builds the executable locally so that it would not end up between your other executables, especially if $GOPATH/bin is part of your $PATH. The demo expects the executable in the current directory, as it starts copies of itself as clients.)
+
+To use the publisher and subscriber in your own code, import the library:
+
+	go get github.com/appliedgo/pubsub
+
+As you have seen in the code for main(), the program spawns three child processes that take over the role of the clients. If everything works fine, you should then see the publisher send 15 messages to the clients, and the clients should then grab only the messages that they have subscribed to.
+
+For additional fun, try tweaking some parameters. For example, comment out the `sleep()` before `publisher.Close()` in serve(). Or have the clients expect more messages than the server sends, and see what happens!
+
+Have fun!
+*/
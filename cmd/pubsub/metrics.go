@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// With -metrics-addr, the server exposes the counters of its publisher
+// at /metrics for Prometheus to scrape, for example at
+// http://localhost:9100/metrics with "-metrics-addr localhost:9100".
+
+// serveMetrics starts serving the metrics of publisher at addr and returns
+// a function that stops it. An empty addr serves nothing.
+func serveMetrics(addr string, publisher *pubsub.Publisher) (stop func()) {
+	if addr == "" {
+		return func() {}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		publisher.Metrics().WritePrometheus(w)
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			slog.Error("Cannot serve metrics", "addr", addr, "error", err)
+		}
+	}()
+	return func() { server.Close() }
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// `pubsub pub [-wait duration] [-cert file -key file -ca file] [URL[,URL...]]` publishes the lines that it
+// reads from stdin, each as soon as it arrives, which makes it the end of a pipeline:
+//
+//	tail -f app.log | pubsub pub tcp://:5656
+//
+// A line is either a topic and a payload, separated by the first space, or a JSON object with the
+// fields "topic" and "payload", as "pubsub sub -record" writes them (see record.go). A payload that
+// is not a JSON string is published as the JSON text. Malformed lines are logged and skipped, and
+// empty lines are ignored. At the end of the input, pub gives the subscribers a moment to receive
+// the last messages, and exits. Before it starts, it waits -wait for subscribers to connect.
+func runPub(args []string) error {
+	flags := flag.NewFlagSet("pub", flag.ExitOnError)
+	wait := flags.Duration("wait", 2*time.Second, "time for subscribers to connect before publishing")
+	tlsFlags := addTLSFlags(flags)
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	if err := logFlags.setup(); err != nil {
+		return err
+	}
+	url := defaultURL
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		url = flags.Arg(0)
+	default:
+		return errors.New("pub: want at most one URL argument")
+	}
+
+	urls := strings.Split(url, ",")
+	var opts pubsub.Options
+	if anyTLS(urls) {
+		var err error
+		opts.TLSConfig, err = tlsFlags.config(true)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	publisher, err := pubsub.NewPublisherURLs(urls, opts)
+	if err != nil {
+		return err
+	}
+	defer publisher.Close()
+	slog.Info("Publishing from stdin", "url", strings.Join(publisher.Addrs(), ","))
+	if sleep(ctx, *wait) != nil {
+		return nil
+	}
+
+	lines, readErr := readLines(ctx, os.Stdin)
+	var published, skipped int
+	for n := 1; ; n++ {
+		var line []byte
+		var ok bool
+		select {
+		case <-ctx.Done():
+			slog.Info("Published", "messages", published, "skipped", skipped)
+			return nil
+		case line, ok = <-lines:
+		}
+		if !ok {
+			break
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		msg, err := parseLine(line)
+		if err != nil {
+			slog.Warn("Skipping line", "line", n, "error", err)
+			skipped++
+			continue
+		}
+		if err := publisher.PublishMessage(msg); err != nil {
+			return fmt.Errorf("cannot publish line %d: %w", n, err)
+		}
+		published++
+	}
+	slog.Info("Published", "messages", published, "skipped", skipped)
+	// As in serve, the subscribers get a moment to consume the last
+	// messages before the publisher closes.
+	sleep(ctx, 1*time.Second)
+	return <-readErr
+}
+
+// maxLineSize is the length of the longest line that pub reads. It matches
+// the largest frame that a subscriber accepts.
+const maxLineSize = 1024 * 1024
+
+// readLines sends the lines of r, without their line endings, until r
+// ends or ctx is done. It then closes the channel of lines and sends the
+// error of reading, or nil, on the error channel.
+func readLines(ctx context.Context, r io.Reader) (<-chan []byte, <-chan error) {
+	lines := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, maxLineSize)
+		for scanner.Scan() {
+			line := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+			select {
+			case lines <- append([]byte(nil), line...):
+			case <-ctx.Done():
+				errs <- nil
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			errs <- fmt.Errorf("cannot read stdin: %w", err)
+			return
+		}
+		errs <- nil
+	}()
+	return lines, errs
+}
+
+// jsonLine is a line of input in JSON.
+type jsonLine struct {
+	Topic    *string         `json:"topic"`
+	Payload  json.RawMessage `json:"payload"`
+	Encoding string          `json:"encoding"`
+}
+
+// parseLine turns a line of input into a message.
+func parseLine(line []byte) (pubsub.Message, error) {
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseJSONLine(trimmed)
+	}
+	i := bytes.IndexByte(line, ' ')
+	if i <= 0 {
+		return pubsub.Message{}, errors.New("want a topic and a payload, separated by a space")
+	}
+	return pubsub.Message{Topic: string(line[:i]), Payload: line[i+1:]}, nil
+}
+
+func parseJSONLine(line []byte) (pubsub.Message, error) {
+	var l jsonLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return pubsub.Message{}, err
+	}
+	if l.Topic == nil {
+		return pubsub.Message{}, errors.New(`no "topic" field`)
+	}
+	if l.Payload == nil {
+		return pubsub.Message{}, errors.New(`no "payload" field`)
+	}
+	msg := pubsub.Message{Topic: *l.Topic, Payload: l.Payload}
+	var s string
+	isString := json.Unmarshal(l.Payload, &s) == nil
+	if isString {
+		msg.Payload = []byte(s)
+	}
+	switch l.Encoding {
+	case "":
+	case "base64":
+		if !isString {
+			return pubsub.Message{}, errors.New("invalid base64 payload: not a string")
+		}
+		p, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return pubsub.Message{}, fmt.Errorf("invalid base64 payload: %w", err)
+		}
+		msg.Payload = p
+	default:
+		return pubsub.Message{}, fmt.Errorf("unknown encoding %q", l.Encoding)
+	}
+	return msg, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		topic   string
+		payload string
+		err     string // part of the error, if one is expected
+	}{
+		{name: "space-separated", line: "Weather sunny and warm", topic: "Weather", payload: "sunny and warm"},
+		{name: "empty payload", line: "Weather ", topic: "Weather", payload: ""},
+		{name: "no payload", line: "Weather", err: "separated by a space"},
+		{name: "no topic", line: " sunny", err: "separated by a space"},
+		{name: "json string payload", line: `{"topic": "Weather", "payload": "sunny\nwarm"}`, topic: "Weather", payload: "sunny\nwarm"},
+		{name: "json raw payload", line: ` {"topic": "Weather", "payload": {"temp": 22}} `, topic: "Weather", payload: `{"temp": 22}`},
+		{name: "json empty topic", line: `{"topic": "", "payload": 1}`, topic: "", payload: "1"},
+		{name: "base64", line: `{"topic": "bin", "payload": "AAH/", "encoding": "base64"}`, topic: "bin", payload: "\x00\x01\xff"},
+		{name: "invalid base64", line: `{"topic": "bin", "payload": "not base64!", "encoding": "base64"}`, err: "invalid base64 payload"},
+		{name: "base64 not a string", line: `{"topic": "bin", "payload": 12, "encoding": "base64"}`, err: "invalid base64 payload"},
+		{name: "unknown encoding", line: `{"topic": "bin", "payload": "x", "encoding": "hex"}`, err: `unknown encoding "hex"`},
+		{name: "missing topic", line: `{"payload": "x"}`, err: `no "topic" field`},
+		{name: "missing payload", line: `{"topic": "x"}`, err: `no "payload" field`},
+		{name: "invalid json", line: `{"topic": "x", "payload": }`, err: "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := parseLine([]byte(tt.line))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("parseLine(%q) = %v, want an error with %q", tt.line, err, tt.err)
+				}
+				return
+			}
+			if err != nil || msg.Topic != tt.topic || string(msg.Payload) != tt.payload {
+				t.Errorf("parseLine(%q) = %q, %q, %v; want %q, %q", tt.line, msg.Topic, msg.Payload, err, tt.topic, tt.payload)
+			}
+		})
+	}
+}
+
+// collectLines returns the lines that readLines reads from input, and
+// the error it reports.
+func collectLines(input string) ([]string, error) {
+	lines, errs := readLines(context.Background(), strings.NewReader(input))
+	var got []string
+	for line := range lines {
+		got = append(got, string(line))
+	}
+	return got, <-errs
+}
+
+func TestReadLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"LF", "a 1\nb 2\n", []string{"a 1", "b 2"}},
+		{"CRLF", "a 1\r\nb 2\r\n", []string{"a 1", "b 2"}},
+		{"no final line ending", "a 1\nb 2", []string{"a 1", "b 2"}},
+		{"empty line", "a 1\n\nb 2\n", []string{"a 1", "", "b 2"}},
+		{"longest line", strings.Repeat("x", maxLineSize-1) + "\n", []string{strings.Repeat("x", maxLineSize-1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectLines(tt.input)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %d lines, %v; want %d lines", len(got), err, len(tt.want))
+			}
+		})
+	}
+
+	t.Run("too long", func(t *testing.T) {
+		got, err := collectLines("a 1\n" + strings.Repeat("x", maxLineSize+1) + "\nb 2\n")
+		if !errors.Is(err, bufio.ErrTooLong) {
+			t.Errorf("error %v, want bufio.ErrTooLong", err)
+		}
+		if !reflect.DeepEqual(got, []string{"a 1"}) {
+			t.Errorf("got %d lines before the long one, want 1", len(got))
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/go-mangos/mangos"
+	"github.com/go-mangos/mangos/protocol/rep"
+	"github.com/go-mangos/mangos/protocol/req"
+	"github.com/go-mangos/mangos/transport/inproc"
+	"github.com/go-mangos/mangos/transport/ipc"
+	"github.com/go-mangos/mangos/transport/tcp"
+)
+
+// ### The slow joiner
+//
+// A pub socket sends each message to the subscribers that are connected at
+// that moment. A client that connects a little late misses the first
+// messages. To avoid this, the server waits until the clients report that
+// they are ready, before it starts publishing.
+//
+// For this, the server listens on a second socket, a REP socket. Each client
+// connects its subscriber, sends its name through a REQ socket, and waits
+// for the server to acknowledge it. The server tells its clients where the
+// REP socket listens with the -ready flag. The server waits for the expected number
+// of clients, but only up to readyTimeout, so that a client that never shows
+// up cannot keep the server from publishing.
+
+// readyTimeout is how long the server waits for the clients to get ready,
+// and how long a client waits for the acknowledgement.
+const readyTimeout = 5 * time.Second
+
+// readyPollInterval bounds how long the server takes to notice that it
+// should stop waiting.
+const readyPollInterval = 100 * time.Millisecond
+
+// readyURL returns the URL for the readiness socket next to a publisher
+// address (see Publisher.Addrs): "inproc://demo" becomes
+// "inproc://demo-ready", "ipc:///tmp/demo.ipc" becomes
+// "ipc:///tmp/demo.ipc.ready", and for all other transports, the readiness
+// socket uses plain TCP on the same host, at a port that the system picks.
+// The readiness socket only carries the names of the clients, so it does
+// without TLS.
+func readyURL(addr string) (string, error) {
+	switch {
+	case strings.HasPrefix(addr, "inproc://"):
+		return addr + "-ready", nil
+	case strings.HasPrefix(addr, "ipc://"):
+		return addr + ".ready", nil
+	}
+	u, err := neturl.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("cannot derive the readiness URL from %s: no host", addr)
+	}
+	return "tcp://" + net.JoinHostPort(u.Hostname(), "0"), nil
+}
+
+// newReadySocket creates a REP or REQ socket for the readiness handshake.
+func newReadySocket(newSocket func() (mangos.Socket, error)) (mangos.Socket, error) {
+	socket, err := newSocket()
+	if err != nil {
+		return nil, err
+	}
+	socket.AddTransport(inproc.NewTransport())
+	socket.AddTransport(ipc.NewTransport())
+	socket.AddTransport(tcp.NewTransport())
+	return socket, nil
+}
+
+// readiness is the server side of the handshake.
+type readiness struct {
+	socket   mangos.Socket
+	expected int
+	addrs    []string // where the clients report, one per publisher address
+}
+
+// listenReady starts listening for ready messages of the given number of
+// clients, next to each of the publisher's addresses.
+func listenReady(addrs []string, expected int) (*readiness, error) {
+	socket, err := newReadySocket(rep.NewSocket)
+	if err != nil {
+		return nil, err
+	}
+	r := &readiness{socket: socket, expected: expected}
+	for _, addr := range addrs {
+		url, err := readyURL(addr)
+		var l mangos.Listener
+		if err == nil {
+			l, err = socket.NewListener(url, nil)
+		}
+		if err == nil {
+			err = l.Listen()
+		}
+		if err != nil {
+			socket.Close()
+			return nil, fmt.Errorf("cannot listen on %s: %w", url, err)
+		}
+		r.addrs = append(r.addrs, l.Address())
+	}
+	return r, nil
+}
+
+// wait acknowledges ready messages until all expected clients are ready,
+// readyTimeout has passed, or ctx is done. It returns the number of clients
+// that reported ready.
+func (r *readiness) wait(ctx context.Context) int {
+	deadline := time.Now().Add(readyTimeout)
+	ready := 0
+	for ready < r.expected && ctx.Err() == nil {
+		left := time.Until(deadline)
+		if left <= 0 {
+			break
+		}
+		if left > readyPollInterval {
+			left = readyPollInterval
+		}
+		r.socket.SetOption(mangos.OptionRecvDeadline, left)
+		name, err := r.socket.Recv()
+		if err == mangos.ErrRecvTimeout {
+			continue
+		}
+		if err != nil {
+			slog.Warn("Readiness", "error", err)
+			break
+		}
+		err = r.socket.Send([]byte("ok"))
+		if err != nil {
+			slog.Warn("Readiness", "error", err)
+			break
+		}
+		ready++
+		slog.Info("Client is ready", "client", string(name))
+	}
+	return ready
+}
+
+// Close closes the readiness socket.
+func (r *readiness) Close() error {
+	return r.socket.Close()
+}
+
+// signalReady tells the server whose readiness socket listens at url that
+// the client called name is ready, and waits for the acknowledgement.
+func signalReady(url, name string) error {
+	socket, err := newReadySocket(req.NewSocket)
+	if err != nil {
+		return err
+	}
+	defer socket.Close()
+	err = socket.Dial(url)
+	if err != nil {
+		return fmt.Errorf("cannot dial into %s: %w", url, err)
+	}
+
+	// The REQ socket queues the message until it is connected.
+	err = socket.SetOption(mangos.OptionSendDeadline, readyTimeout)
+	if err == nil {
+		err = socket.SetOption(mangos.OptionRecvDeadline, readyTimeout)
+	}
+	if err == nil {
+		err = socket.Send([]byte(name))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = socket.Recv()
+	if err == mangos.ErrRecvTimeout {
+		return fmt.Errorf("no acknowledgement from %s within %v", url, readyTimeout)
+	}
+	return err
+}
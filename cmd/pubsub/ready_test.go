@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyURL(t *testing.T) {
+	tests := []struct {
+		addr, want string
+		err        string // part of the error, if one is expected
+	}{
+		{addr: "inproc://demo", want: "inproc://demo-ready"},
+		{addr: "ipc:///tmp/demo.ipc", want: "ipc:///tmp/demo.ipc.ready"},
+		{addr: "tcp://127.0.0.1:56565", want: "tcp://127.0.0.1:0"},
+		{addr: "tls+tcp://localhost:56565", want: "tcp://localhost:0"},
+		{addr: "ws://[::1]:56565/pubsub", want: "tcp://[::1]:0"},
+		{addr: "tcp://:56565", err: "no host"},
+	}
+	for _, tt := range tests {
+		got, err := readyURL(tt.addr)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("readyURL(%s) = %s, %v; want an error with %q", tt.addr, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("readyURL(%s) = %s, %v; want %s", tt.addr, got, err, tt.want)
+		}
+	}
+}
+
+// The server waits until the expected clients have reported, at any of
+// its readiness addresses.
+func TestReadiness(t *testing.T) {
+	r, err := listenReady([]string{"inproc://" + t.Name(), "tcp://127.0.0.1:0"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.addrs) != 2 || strings.HasSuffix(r.addrs[1], ":0") {
+		t.Fatalf("readiness addresses %v, want two with the TCP port that was picked", r.addrs)
+	}
+
+	errs := make(chan error, 3)
+	for i, name := range []string{"a", "b", "c"} {
+		go func(addr, name string) {
+			errs <- signalReady(addr, name)
+		}(r.addrs[i%2], name)
+	}
+	start := time.Now()
+	if n := r.wait(context.Background()); n != 3 {
+		t.Errorf("wait = %d, want 3", n)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("wait took %v for clients that were ready", d)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("signalReady: %v", err)
+		}
+	}
+}
+
+// A client that does not show up keeps the server waiting only until ctx
+// is done.
+func TestReadinessMissingClient(t *testing.T) {
+	r, err := listenReady([]string{"inproc://" + t.Name()}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go signalReady(r.addrs[0], "a")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if n := r.wait(ctx); n != 1 {
+		t.Errorf("wait = %d, want 1", n)
+	}
+	if d := time.Since(start); d > 300*time.Millisecond+2*readyPollInterval {
+		t.Errorf("wait returned %v after ctx was done", d-300*time.Millisecond)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// With -record, a subscriber writes every message it receives to a file,
+// one JSON object per line (see Subscriber.RecordTo). The demo clients
+// share their flags, so each of them inserts its name into the file name:
+// with -record msgs.ndjson, client C1 writes msgs-C1.ndjson.
+
+// clientRecordFile returns the file that the client name records in.
+func clientRecordFile(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
+
+// startRecording makes subscriber record in the file at path, which it
+// creates or truncates. The returned function stops recording, flushes
+// the file, and closes it; it must be called before the subscriber is
+// closed.
+func startRecording(subscriber *pubsub.Subscriber, path string) (stop func() error, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	subscriber.RecordTo(f)
+	return func() error {
+		err := subscriber.RecordTo(nil)
+		if n := subscriber.RecordDropped(); n > 0 {
+			slog.Warn("Messages not recorded", "file", path, "dropped", n)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// `pubsub replay [-url URL[,URL...]] [-realtime] [-wait duration] [-cert file -key file -ca file] journal`
+// publishes the messages of a journal (see the -journal flag of the server) once more, as fast as it can,
+// or, with -realtime, with the pauses between them that they were first published with. journal is the
+// journal directory or one segment file in it. Before it starts, replay waits -wait for subscribers to
+// connect.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	url := flags.String("url", defaultURL, "URL to publish on, or a comma-separated list of URLs")
+	realtime := flags.Bool("realtime", false, "keep the original pauses between the messages")
+	wait := flags.Duration("wait", 2*time.Second, "time for subscribers to connect before replaying")
+	tlsFlags := addTLSFlags(flags)
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	if err := logFlags.setup(); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("replay: want exactly one journal")
+	}
+	path := flags.Arg(0)
+
+	urls := strings.Split(*url, ",")
+	var opts pubsub.Options
+	if anyTLS(urls) {
+		var err error
+		opts.TLSConfig, err = tlsFlags.config(true)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	publisher, err := pubsub.NewPublisherURLs(urls, opts)
+	if err != nil {
+		return err
+	}
+	defer publisher.Close()
+	slog.Info("Replaying", "journal", path, "url", strings.Join(publisher.Addrs(), ","))
+	if sleep(ctx, *wait) != nil {
+		return nil
+	}
+
+	var replayed int
+	var last time.Time
+	err = pubsub.ReadJournal(path, func(e pubsub.JournalEntry) error {
+		if *realtime && !last.IsZero() {
+			if err := sleep(ctx, e.Time.Sub(last)); err != nil {
+				return err
+			}
+		}
+		last = e.Time
+		if err := publisher.PublishMessage(pubsub.Message{Topic: e.Topic, Payload: e.Payload, Codec: e.Codec}); err != nil {
+			return err
+		}
+		replayed++
+		return nil
+	})
+	slog.Info("Replayed", "messages", replayed)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	// As in serve, the subscribers get a moment to consume the last
+	// messages before the publisher closes.
+	sleep(ctx, 1*time.Second)
+	return err
+}
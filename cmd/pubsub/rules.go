@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// The rules of `pubsub forward -rules file` are a JSON list of
+// pubsub.ForwardRule, for example:
+//
+//	[
+//		{"match": "Sports", "drop": true},
+//		{"match": "Weather", "rewrite": "env.weather"},
+//		{"match": "", "sample": 10}
+//	]
+//
+// On SIGHUP, the forwarder reads the file again. If it cannot, it keeps
+// the rules it has.
+
+// loadRules reads the rules from the file at path and sets them.
+func loadRules(forwarder *pubsub.Forwarder, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []pubsub.ForwardRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return err
+	}
+	return forwarder.SetRules(rules)
+}
+
+// reloadRules reloads the rules on every SIGHUP until ctx is done.
+func reloadRules(ctx context.Context, forwarder *pubsub.Forwarder, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				err := loadRules(forwarder, path)
+				if err != nil {
+					slog.Warn("Keeping the old rules", "file", path, "error", err)
+					continue
+				}
+				slog.Info("Reloaded the rules", "file", path)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A Schedule describes what the server publishes and when: in each round,
+// it publishes one message per topic, waiting for the interval before each
+// message.
+type Schedule struct {
+	// Rounds is the number of rounds. Zero means "run until canceled".
+	Rounds int
+
+	// Interval is the pause before each message.
+	Interval time.Duration
+
+	// IntervalFunc, if set, returns the pause for the given round
+	// (counting from 0) and overrides Interval.
+	IntervalFunc func(round int) time.Duration
+
+	// Topics are the topics to publish to, in this order.
+	Topics []string
+
+	// PayloadFunc returns the message for a round and topic. If nil,
+	// the message is "Message for <topic>".
+	PayloadFunc func(round int, topic string) string
+}
+
+// Run calls publish for each message of the schedule. It stops and returns
+// the error if publish fails, or ctx.Err() if ctx is canceled, even in the
+// middle of a round.
+func (s Schedule) Run(ctx context.Context, publish func(topic, payload string) error) error {
+	for round := 0; s.Rounds == 0 || round < s.Rounds; round++ {
+		interval := s.Interval
+		if s.IntervalFunc != nil {
+			interval = s.IntervalFunc(round)
+		}
+		for _, topic := range s.Topics {
+			if err := sleep(ctx, interval); err != nil {
+				return err
+			}
+			payload := fmt.Sprintf("Message for %s", topic)
+			if s.PayloadFunc != nil {
+				payload = s.PayloadFunc(round, topic)
+			}
+			if err := publish(topic, payload); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sleep waits for d or until ctx is done, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Run stops with ctx.Err() when ctx is canceled, both between two
+// messages and while it waits for the next one.
+func TestScheduleCancel(t *testing.T) {
+	t.Run("between messages", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var published []string
+		s := Schedule{Topics: []string{"a", "b"}}
+		err := s.Run(ctx, func(topic, payload string) error {
+			published = append(published, topic)
+			if len(published) == 3 {
+				cancel()
+			}
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run: %v, want context.Canceled", err)
+		}
+		if len(published) != 3 {
+			t.Errorf("published %v, want 3 messages before the cancel", published)
+		}
+	})
+
+	t.Run("while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		s := Schedule{Interval: time.Hour, Topics: []string{"a"}}
+		start := time.Now()
+		err := s.Run(ctx, func(topic, payload string) error {
+			t.Errorf("published %s", topic)
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run: %v, want context.Canceled", err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Run returned after %v", d)
+		}
+	})
+
+	t.Run("rounds", func(t *testing.T) {
+		n := 0
+		s := Schedule{Rounds: 2, Topics: []string{"a", "b"}}
+		err := s.Run(context.Background(), func(topic, payload string) error {
+			n++
+			return nil
+		})
+		if err != nil || n != 4 {
+			t.Errorf("Run: %v after %d messages, want nil after 4", err, n)
+		}
+	})
+}
+
+// A failing publish stops the schedule with its error.
+func TestSchedulePublishError(t *testing.T) {
+	failed := errors.New("failed")
+	n := 0
+	s := Schedule{Topics: []string{"a"}}
+	err := s.Run(context.Background(), func(topic, payload string) error {
+		n++
+		return failed
+	})
+	if err != failed || n != 1 {
+		t.Errorf("Run: %v after %d messages, want the publish error after 1", err, n)
+	}
+}
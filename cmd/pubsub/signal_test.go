@@ -0,0 +1,43 @@
+//go:build !windows
+
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A signal cancels the context, and further signals are ignored until
+// stop is called.
+func TestSignalContext(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGINT, syscall.SIGTERM} {
+		t.Run(sig.String(), func(t *testing.T) {
+			ctx, stop := signalContext()
+			defer stop()
+			if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(2 * time.Second):
+				t.Fatal("context not canceled")
+			}
+			// The process survives a second signal.
+			if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		})
+	}
+}
+
+func TestSignalContextStop(t *testing.T) {
+	ctx, stop := signalContext()
+	stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not canceled by stop")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// A client prints a table of what it received per topic when it exits,
+// and whenever it gets a SIGUSR1 while it runs (where there is such a
+// signal; see statssignal_unix.go).
+
+// writeStats writes the statistics of client name as a table, one row per
+// topic.
+func writeStats(w io.Writer, name string, stats map[string]pubsub.TopicStats) error {
+	topics := make([]string, 0, len(stats))
+	for t := range stats {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Client %s statistics:\n", name)
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TOPIC\tMESSAGES\tBYTES\tFIRST\tLAST\tMIN GAP\tMEAN GAP\tMAX GAP\t")
+	for _, t := range topics {
+		st := stats[t]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t\n", t, st.Messages, st.Bytes,
+			st.First.Format("15:04:05.000"), st.Last.Format("15:04:05.000"),
+			st.MinInterval.Round(time.Microsecond), st.MeanInterval.Round(time.Microsecond), st.MaxInterval.Round(time.Microsecond))
+	}
+	tw.Flush()
+	// One write, so that the tables of clients that run in the same
+	// process do not get mixed up.
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// reportStats writes the statistics of the subscriber to stdout on each
+// SIGUSR1 until ctx is done.
+func reportStats(ctx context.Context, name string, subscriber *pubsub.Subscriber) {
+	if len(statsSignals) == 0 {
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, statsSignals...)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-sig:
+			writeStats(os.Stdout, name, subscriber.Stats())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
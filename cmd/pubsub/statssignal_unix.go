@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statsSignals are the signals that make a client print its statistics.
+var statsSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// Windows has no SIGUSR1, so clients print their statistics only when
+// they exit.
+var statsSignals []os.Signal
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// `pubsub sub [-url URL[,URL...]] [-cert file -key file -ca file] [-pretty] [-record file] [-report-unused] [topic...]` subscribes
+// to the given topics, or to all topics if there are none or one of them is `*`, and prints every
+// message it receives until the connection fails or the process is interrupted. With several URLs,
+// it merges the messages of all publishers. With -record, it also writes them to a file (see record.go).
+// With -report-unused, it lists the topics that matched no message when it exits, to stderr.
+func runSub(args []string) (err error) {
+	flags := flag.NewFlagSet("sub", flag.ExitOnError)
+	url := flags.String("url", defaultURL, "URL of the publisher, or a comma-separated list of URLs")
+	pretty := flags.Bool("pretty", false, "detect the payload format and render it readably")
+	record := flags.String("record", "", "record the received messages in `file`")
+	reportUnused := flags.Bool("report-unused", false, "list the topics that matched no message at exit")
+	tlsFlags := addTLSFlags(flags)
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	if err := logFlags.setup(); err != nil {
+		return err
+	}
+
+	config, err := tlsFlags.config(false)
+	if err != nil {
+		return err
+	}
+	urls := strings.Split(*url, ",")
+	subscriber, err := pubsub.NewSubscriberURLs(urls, pubsub.Options{TLSConfig: config})
+	if err != nil {
+		return err
+	}
+	defer subscriber.Close()
+	if *record != "" {
+		stopRecording, rerr := startRecording(subscriber, *record)
+		if rerr != nil {
+			return rerr
+		}
+		defer func() {
+			if rerr := stopRecording(); rerr != nil && err == nil {
+				err = fmt.Errorf("cannot record messages: %w", rerr)
+			}
+		}()
+	}
+	// Mention when the publisher goes away and comes back.
+	subscriber.OnStateChange(func(state pubsub.ConnState) {
+		slog.Info("Connection", "state", state.String())
+	})
+	subscriber.OnGap(func(topic string, from, to uint64) {
+		slog.Warn("Missed messages", "topic", topic, "from", from, "to", to)
+	})
+	if *reportUnused {
+		start := time.Now()
+		defer func() {
+			for _, topic := range subscriber.UnusedSince(time.Since(start)) {
+				if topic == "" {
+					topic = "*"
+				}
+				fmt.Fprintln(os.Stderr, "Unused:", topic)
+			}
+		}()
+	}
+	topics := flags.Args()
+	if len(topics) == 0 {
+		topics = []string{"*"}
+	}
+	for _, topic := range topics {
+		if topic == "*" {
+			err = subscriber.SubscribeAll()
+		} else {
+			err = subscriber.Subscribe(topic)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// On SIGINT or SIGTERM, closing the subscriber ends the loop below, so
+	// that the recording is complete.
+	ctx, stop := signalContext()
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		subscriber.Close()
+	}()
+	for msg := range subscriber.Messages() {
+		if !*pretty {
+			fmt.Printf("%s|%s\n", msg.Topic, msg.Payload)
+			continue
+		}
+		label, text := renderMessage(msg)
+		fmt.Printf("%s [%s]\n%s\n", msg.Topic, label, text)
+	}
+	return subscriber.Err()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// ### Supervising the clients
+//
+// The clients run as child processes. The supervisor starts them, waits for
+// them, and makes sure that none of them outlives the demo: if one client
+// fails, the others are killed, too.
+
+// supervisor keeps track of the client processes.
+type supervisor struct {
+	names []string
+	cmds  []*exec.Cmd
+}
+
+// startClients starts the demo clients as copies of this executable, with
+// flags in front of their arguments. Local clients use the local URL, and
+// all clients report to the readiness socket at one of the ready addresses
+// (see clientArgs). If one of them does not start, the ones that did are
+// killed.
+func startClients(flags []string, local string, ready []string) (*supervisor, error) {
+	s := &supervisor{}
+	for i, c := range demoClients {
+		cmd := exec.Command("./pubsub", clientArgs(flags, local, ready, i)...)
+		cmd.Stdout = os.Stdout // Default is nil but we want to see what the clients say.
+		cmd.Stderr = os.Stderr // Same here.
+		slog.Info("Starting client", "client", c.name)
+		// Start the command and continue without waiting for the command to finish.
+		if err := cmd.Start(); err != nil {
+			s.kill()
+			s.wait()
+			return nil, fmt.Errorf("failed starting client %s: %w", c.name, err)
+		}
+		s.names = append(s.names, c.name)
+		s.cmds = append(s.cmds, cmd)
+	}
+	return s, nil
+}
+
+// signal sends sig to all clients that are still running.
+func (s *supervisor) signal(sig os.Signal) {
+	for _, cmd := range s.cmds {
+		// A client that has exited already reports an error; that is fine.
+		cmd.Process.Signal(sig)
+	}
+}
+
+// kill ends all clients that are still running.
+func (s *supervisor) kill() {
+	s.signal(os.Kill)
+}
+
+// wait waits for all clients to exit. As soon as one of them fails, the
+// others are killed. wait returns the error of the first client that
+// failed, or nil.
+func (s *supervisor) wait() error {
+	errs := make(chan error, len(s.cmds))
+	for i, cmd := range s.cmds {
+		go func(name string, cmd *exec.Cmd) {
+			err := cmd.Wait()
+			if err == nil {
+				errs <- nil
+				return
+			}
+			// Report the error before the kill, so that it arrives
+			// before those of the killed clients.
+			errs <- fmt.Errorf("client %s: %w", name, err)
+			s.kill()
+		}(s.names[i], cmd)
+	}
+	var first error
+	for range s.cmds {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// exitCode returns the exit code of the client that caused err, or 1.
+func exitCode(err error) int {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() > 0 {
+		return exit.ExitCode()
+	}
+	return 1
+}
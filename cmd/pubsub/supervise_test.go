@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startCommands starts a supervisor for shell commands, one per name.
+func startCommands(t *testing.T, commands map[string]string) *supervisor {
+	t.Helper()
+	s := &supervisor{}
+	for name, command := range commands {
+		cmd := exec.Command("sh", "-c", command)
+		if err := cmd.Start(); err != nil {
+			s.kill()
+			s.wait()
+			t.Fatal(err)
+		}
+		s.names = append(s.names, name)
+		s.cmds = append(s.cmds, cmd)
+	}
+	return s
+}
+
+func TestSuperviseSuccess(t *testing.T) {
+	s := startCommands(t, map[string]string{"a": "exit 0", "b": "sleep 0.1"})
+	if err := s.wait(); err != nil {
+		t.Errorf("wait = %v, want nil", err)
+	}
+}
+
+// When one client fails, the others are killed, and wait reports the
+// client that failed first, with its exit code.
+func TestSuperviseFailure(t *testing.T) {
+	s := startCommands(t, map[string]string{"fails": "sleep 0.1; exit 3", "sleeps": "sleep 30"})
+	start := time.Now()
+	err := s.wait()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("wait took %v; the sleeping client was not killed", d)
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "client fails:") {
+		t.Fatalf("wait = %v, want the error of client fails", err)
+	}
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exitCode(err) != 3 {
+		t.Errorf("exitCode(%v) = %d, want 3", err, exitCode(err))
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if code := exitCode(errors.New("no process")); code != 1 {
+		t.Errorf("exitCode of an error without an exit code = %d, want 1", code)
+	}
+	s := startCommands(t, map[string]string{"killed": "sleep 30"})
+	s.kill()
+	if code := exitCode(s.wait()); code != 1 {
+		t.Errorf("exitCode of a killed client = %d, want 1", code)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+// With a "tls+tcp://" or "wss://" URL, the demo and `pubsub sub` need certificates.
+// They come from PEM files given by the -cert, -key, and -ca flags.
+// The publisher presents -cert and -key; with -ca, it also requires client
+// certificates signed by that CA. A subscriber verifies the publisher
+// against -ca and presents -cert and -key if given.
+
+// tlsFlags holds the TLS flags of a flag set.
+type tlsFlags struct {
+	cert, key, ca *string
+}
+
+func addTLSFlags(flags *flag.FlagSet) tlsFlags {
+	return tlsFlags{
+		cert: flags.String("cert", "", "PEM `file` with the certificate to present (TLS only)"),
+		key:  flags.String("key", "", "PEM `file` with the private key for -cert"),
+		ca:   flags.String("ca", "", "PEM `file` with the CA certificates to verify the peer against"),
+	}
+}
+
+// set tells whether any TLS flag is set.
+func (f tlsFlags) set() bool {
+	return *f.cert != "" || *f.key != "" || *f.ca != ""
+}
+
+// config returns the TLS configuration for the publisher (server) or a
+// subscriber, or nil if no TLS flag is set.
+func (f tlsFlags) config(server bool) (*tls.Config, error) {
+	if !f.set() {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if *f.cert != "" || *f.key != "" {
+		cert, err := tls.LoadX509KeyPair(*f.cert, *f.key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if server {
+		return nil, errors.New("the publisher needs -cert and -key")
+	}
+	if *f.ca != "" {
+		pem, err := ioutil.ReadFile(*f.ca)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA certificates: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *f.ca)
+		}
+		if server {
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.RootCAs = pool
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,158 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// A Codec turns values into payloads and back. Publishers and subscribers
+// each use one; the default is JSON. The name of the codec travels with
+// every message (in the binary framing), so a subscriber notices when a
+// message was encoded with a codec other than its own.
+
+// Codec marshals and unmarshals payloads.
+type Codec interface {
+	// Name identifies the codec on the wire. It should be short.
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The codecs that come with this package. ProtoCodec is in proto.go.
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgPackCodec Codec = msgpackCodec{}
+)
+
+// ErrCodecMismatch is wrapped by the DecodeError for a message that names
+// a codec other than the one the subscriber uses.
+var ErrCodecMismatch = errors.New("unexpected codec")
+
+// decodeErrorExcerpt is the number of payload bytes a DecodeError keeps.
+const decodeErrorExcerpt = 64
+
+// DecodeError is returned when a payload cannot be unmarshaled.
+type DecodeError struct {
+	Topic   string
+	Excerpt []byte // the first bytes of the payload
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cannot decode message for topic %s: %s (payload starts with %q)", e.Topic, e.Err, e.Excerpt)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// newDecodeError keeps a copy of at most decodeErrorExcerpt bytes of payload.
+func newDecodeError(topic string, payload []byte, err error) *DecodeError {
+	if len(payload) > decodeErrorExcerpt {
+		payload = payload[:decodeErrorExcerpt]
+	}
+	return &DecodeError{Topic: topic, Excerpt: append([]byte(nil), payload...), Err: err}
+}
+
+// SetCodec sets the codec for PublishValue. It must not be called
+// concurrently with publishing.
+func (p *Publisher) SetCodec(c Codec) {
+	p.codec = c
+}
+
+// PublishValue marshals v with the publisher's codec and sends it to all
+// subscribers of topic.
+func (p *Publisher) PublishValue(topic string, v interface{}) error {
+	c := p.codec
+	if c == nil {
+		c = JSONCodec
+	}
+	return p.publishWith(c, topic, v)
+}
+
+// PublishJSON marshals v to JSON and sends it to all subscribers of topic.
+func (p *Publisher) PublishJSON(topic string, v interface{}) error {
+	return p.publishWith(JSONCodec, topic, v)
+}
+
+func (p *Publisher) publishWith(c Codec, topic string, v interface{}) error {
+	payload, err := c.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot encode message for topic %s: %w", topic, err)
+	}
+	return p.PublishMessage(Message{Topic: topic, Payload: payload, Codec: c.Name()})
+}
+
+// SetCodec sets the codec for ReceiveValue. It must be called before
+// receiving the first message.
+func (s *Subscriber) SetCodec(c Codec) {
+	s.codec = c
+}
+
+// ReceiveValue waits for the next message and unmarshals its payload into
+// v with the subscriber's codec. It returns the topic of the message, also
+// if the payload cannot be unmarshaled; the error is then a *DecodeError.
+// A message that names a different codec is not unmarshaled at all, and
+// the DecodeError wraps ErrCodecMismatch. Messages that do not name a codec
+// are unmarshaled with the subscriber's codec.
+func (s *Subscriber) ReceiveValue(v interface{}) (topic string, err error) {
+	c := s.codec
+	if c == nil {
+		c = JSONCodec
+	}
+	return s.receiveWith(c, v)
+}
+
+// ReceiveJSON is like ReceiveValue with JSONCodec.
+func (s *Subscriber) ReceiveJSON(v interface{}) (topic string, err error) {
+	return s.receiveWith(JSONCodec, v)
+}
+
+func (s *Subscriber) receiveWith(c Codec, v interface{}) (string, error) {
+	msg, err := s.ReceiveMessage()
+	if err != nil {
+		return "", err
+	}
+	if msg.Codec != "" && msg.Codec != c.Name() {
+		err := fmt.Errorf("%w %s, expected %s", ErrCodecMismatch, msg.Codec, c.Name())
+		return msg.Topic, newDecodeError(msg.Topic, msg.Payload, err)
+	}
+	if err := c.Unmarshal(msg.Payload, v); err != nil {
+		return msg.Topic, newDecodeError(msg.Topic, msg.Payload, err)
+	}
+	return msg.Topic, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// gobCodec encodes every message with a fresh encoder, so each payload
+// carries its own type information and can be decoded on its own.
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string                               { return "msgpack" }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
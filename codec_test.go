@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reading is a value that all codecs can carry.
+type reading struct {
+	Station string
+	Celsius float64
+	Tags    []string
+}
+
+// Each codec carries a value from a publisher to a subscriber with the
+// same codec.
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSONCodec, GobCodec, MsgPackCodec} {
+		t.Run(c.Name(), func(t *testing.T) {
+			p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+			p.SetCodec(c)
+			s := newTestSubscriber(t, p.Addr(), Options{}, "weather", "ping")
+			s.SetCodec(c)
+			waitFlow(t, p, s)
+
+			want := reading{Station: "Berlin", Celsius: 21.5, Tags: []string{"sunny", "calm"}}
+			if err := p.PublishValue("weather", want); err != nil {
+				t.Fatal(err)
+			}
+			var got reading
+			topic, err := s.ReceiveValue(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if topic != "weather" || !reflect.DeepEqual(got, want) {
+				t.Errorf("received %s %+v, want weather %+v", topic, got, want)
+			}
+		})
+	}
+}
+
+// Two subscribers with different codecs on one topic each decode the
+// messages of their own codec and get ErrCodecMismatch for the others,
+// instead of a value decoded from the wrong format.
+func TestCodecMismatch(t *testing.T) {
+	p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+	gobSub := newTestSubscriber(t, p.Addr(), Options{}, "weather", "ping")
+	gobSub.SetCodec(GobCodec)
+	msgpackSub := newTestSubscriber(t, p.Addr(), Options{}, "weather", "ping")
+	msgpackSub.SetCodec(MsgPackCodec)
+	waitFlow(t, p, gobSub)
+	waitFlow(t, p, msgpackSub)
+
+	want := reading{Station: "Berlin", Celsius: 21.5}
+	for _, c := range []Codec{GobCodec, MsgPackCodec} {
+		p.SetCodec(c)
+		if err := p.PublishValue("weather", want); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, s := range []struct {
+		name string
+		sub  *Subscriber
+		own  int // index of the message in its own codec
+	}{
+		{"gob", gobSub, 0},
+		{"msgpack", msgpackSub, 1},
+	} {
+		for i := 0; i < 2; i++ {
+			var got reading
+			topic, err := receiveValue(t, s.sub, &got)
+			if i == s.own {
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("%s subscriber: %+v, %v; want %+v", s.name, got, err, want)
+				}
+				continue
+			}
+			var derr *DecodeError
+			if !errors.As(err, &derr) || !errors.Is(err, ErrCodecMismatch) || derr.Topic != "weather" || topic != "weather" {
+				t.Errorf("%s subscriber: %v for topic %s, want a DecodeError wrapping ErrCodecMismatch", s.name, err, topic)
+			}
+			if !reflect.DeepEqual(got, reading{}) {
+				t.Errorf("%s subscriber decoded %+v from the other codec", s.name, got)
+			}
+		}
+	}
+}
+
+// receiveValue is ReceiveValue that skips pings that were still underway
+// after waitFlow.
+func receiveValue(t *testing.T, s *Subscriber, v interface{}) (string, error) {
+	t.Helper()
+	for {
+		topic, err := s.ReceiveValue(v)
+		if topic != "ping" {
+			return topic, err
+		}
+	}
+}
+
+// order has nested structs, slices, and maps, as JSON payloads often do.
+type order struct {
+	ID       string
+	Customer struct {
+		Name    string
+		Address struct{ City, Zip string }
+	}
+	Items []struct {
+		SKU   string
+		Count int
+		Tags  []string
+	}
+	Notes map[string][]int
+}
+
+// PublishJSON and ReceiveJSON carry nested values unchanged.
+func TestJSONNested(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "orders", "ping")
+	waitFlow(t, p, s)
+
+	var want order
+	want.ID = "A-17"
+	want.Customer.Name = "Ada"
+	want.Customer.Address.City = "Berlin"
+	want.Customer.Address.Zip = "10115"
+	want.Items = append(want.Items, struct {
+		SKU   string
+		Count int
+		Tags  []string
+	}{"tea", 2, []string{"green", "loose"}}, struct {
+		SKU   string
+		Count int
+		Tags  []string
+	}{"cup", 6, nil})
+	want.Notes = map[string][]int{"boxes": {1, 2}}
+	if err := p.PublishJSON("orders", want); err != nil {
+		t.Fatal(err)
+	}
+	var got order
+	if _, err := receiveValueJSON(s, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received %+v, want %+v", got, want)
+	}
+}
+
+// JSON that does not decode is a DecodeError with the start of the
+// payload.
+func TestJSONInvalid(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "orders", "ping")
+	waitFlow(t, p, s)
+
+	bad := `{"ID": "A-17", "Items": [{"SKU": "tea", "Count": 2}, {"SKU": "cup", "Count": }]}`
+	if err := p.Publish("orders", bad); err != nil {
+		t.Fatal(err)
+	}
+	var got order
+	topic, err := receiveValueJSON(s, &got)
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("ReceiveJSON = %v, want a DecodeError", err)
+	}
+	var serr *json.SyntaxError
+	if !errors.As(err, &serr) || errors.Is(err, ErrCodecMismatch) {
+		t.Errorf("error %v does not wrap the syntax error", err)
+	}
+	if topic != "orders" || derr.Topic != "orders" {
+		t.Errorf("topic %q, DecodeError topic %q; want orders", topic, derr.Topic)
+	}
+	if want := bad[:decodeErrorExcerpt]; string(derr.Excerpt) != want {
+		t.Errorf("excerpt %q, want %q", derr.Excerpt, want)
+	}
+	if !strings.Contains(err.Error(), "A-17") {
+		t.Errorf("error %q does not show the payload", err)
+	}
+}
+
+// receiveValueJSON is ReceiveJSON that skips pings that were still
+// underway after waitFlow.
+func receiveValueJSON(s *Subscriber, v interface{}) (string, error) {
+	for {
+		topic, err := s.ReceiveJSON(v)
+		if topic != "ping" {
+			return topic, err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Large payloads, like JSON documents of several kilobytes, shrink a lot
+// when compressed. A publisher can gzip the payloads above a threshold
+// and mark them in the frame header; subscribers decompress them before
+// anyone sees them. The topic and the header stay uncompressed, so that
+// subscriptions keep working. The text framing has no header, so its
+// payloads are never compressed.
+
+// encodingGzip is the header value of a gzipped payload.
+const encodingGzip = "gzip"
+
+// SetCompression makes the publisher gzip the payloads of more than
+// threshold bytes. A payload that does not get smaller is sent as it is.
+// A threshold of zero or less turns compression off, which is the
+// default. Subscribers decompress the payloads by themselves, but those
+// built before compression was added cannot read them.
+func (p *Publisher) SetCompression(threshold int) {
+	if threshold < 0 {
+		threshold = 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.send.compressAbove = threshold
+}
+
+// gzipWriters saves allocating a compressor for each message.
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// compress returns the gzipped payload, or nil if it would not be smaller.
+func compress(payload []byte) []byte {
+	var b bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&b)
+	// Writing to a bytes.Buffer cannot fail.
+	w.Write(payload)
+	w.Close()
+	if b.Len() >= len(payload) {
+		return nil
+	}
+	return b.Bytes()
+}
+
+// decompress undoes compress. A payload that cannot be decompressed makes
+// the frame malformed, and so does one that grows beyond maxRecvSize, the
+// largest that could have been sent uncompressed.
+func decompress(encoding string, payload []byte) ([]byte, error) {
+	if encoding != encodingGzip {
+		return nil, fmt.Errorf("%w: unknown payload encoding %q", ErrBadEnvelope, encoding)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	var out []byte
+	if err == nil {
+		out, err = ioutil.ReadAll(io.LimitReader(r, maxRecvSize+1))
+	}
+	if err == nil && len(out) > maxRecvSize {
+		err = errors.New("too large")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot decompress payload: %v", ErrBadEnvelope, err)
+	}
+	return out, nil
+}
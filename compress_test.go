@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// Only payloads above the threshold that get smaller are compressed, and
+// only in the binary framing.
+func TestCompressThreshold(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	opts := &sendOptions{compressAbove: 100}
+	tests := []struct {
+		name     string
+		format   wire.Format
+		payload  []byte
+		encoding string
+	}{
+		{"small", wire.Binary, []byte(strings.Repeat("a", 50)), ""},
+		{"at the threshold", wire.Binary, []byte(strings.Repeat("a", 100)), ""},
+		{"above the threshold", wire.Binary, []byte(strings.Repeat("a", 101)), encodingGzip},
+		{"incompressible", wire.Binary, random, ""},
+		{"text framing", wire.Text, []byte(strings.Repeat("a", 1000)), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := encodeMessage(tt.format, Message{Topic: "a", Payload: tt.payload}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := wire.Decode(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Header.Encoding != tt.encoding {
+				t.Fatalf("encoding %q, want %q", f.Header.Encoding, tt.encoding)
+			}
+			if tt.encoding == "" && !bytes.Equal(f.Payload, tt.payload) {
+				t.Errorf("uncompressed payload changed to %q", f.Payload)
+			}
+			if tt.encoding != "" {
+				out, err := decompress(f.Header.Encoding, f.Payload)
+				if err != nil || !bytes.Equal(out, tt.payload) || len(f.Payload) >= len(tt.payload) {
+					t.Errorf("compressed %d to %d bytes, decompressed to %q, %v", len(tt.payload), len(f.Payload), out, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	z := compress([]byte(strings.Repeat("a", 1000)))
+	bomb := compress(make([]byte, maxRecvSize+1))
+	tests := []struct {
+		name     string
+		encoding string
+		payload  []byte
+	}{
+		{"corrupt", encodingGzip, []byte{0x1f, 0x8b, 0xff, 0x00}},
+		{"not gzip", encodingGzip, []byte("plain text")},
+		{"truncated", encodingGzip, z[:len(z)/2]},
+		{"too large", encodingGzip, bomb},
+		{"unknown encoding", "zstd", z},
+	}
+	for _, tt := range tests {
+		if out, err := decompress(tt.encoding, tt.payload); !errors.Is(err, ErrBadEnvelope) {
+			t.Errorf("%s: decompress = %d bytes, %v; want ErrBadEnvelope", tt.name, len(out), err)
+		}
+	}
+}
+
+// A subscriber gets the payloads as they were published, compressed or
+// not, and a corrupt one as ErrBadEnvelope.
+func TestCompressRoundTrip(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	p.SetCompression(100)
+	s := newTestSubscriber(t, url, Options{}, "a", "ping")
+	waitFlow(t, p, s)
+
+	large := strings.Repeat("compress me ", 100)
+	publishPayloads(t, p, "a", "small", large)
+	got := receivePayloads(t, s, 2)
+	if got[0] != "small" || got[1] != large {
+		t.Errorf("received %q, want the payloads as published", got)
+	}
+
+	raw, err := encodeMessage(wire.Binary, Message{Topic: "a", Payload: []byte(large)}, &sendOptions{compressAbove: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := wire.Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Payload = f.Payload[:len(f.Payload)/2]
+	if raw, err = wire.Encode(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.socket.Send(raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReceiveMessage(); !errors.Is(err, ErrBadEnvelope) {
+		t.Errorf("Receive of a corrupt compressed payload = %v, want ErrBadEnvelope", err)
+	}
+}
@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// A PUB socket sends to anyone who can connect. Where TLS is too much,
+// the payloads can at least be kept secret with a key that the publisher
+// and its subscribers share (see Options.PayloadKey). The publisher
+// encrypts each payload with AES-GCM and a random nonce, which goes into
+// the frame header. The topic and the header stay in the clear, so that
+// subscriptions work, but they are authenticated along with the payload:
+// a frame whose topic, codec, sequence number, or encoding was changed on
+// the way fails to decrypt. That includes topics that a forwarder rewrites
+// (see ForwardRule). Only the nonce itself and what is set on the way, the
+// MAC and the flags, are left out. A compressed payload is compressed
+// first, as encrypted data does not compress.
+//
+// A subscriber without the right key gets ErrDecrypt for encrypted
+// messages. A subscriber with a key gets ErrDecrypt for messages in the
+// clear, too, as anyone could have sent them.
+
+// payloadKeyEnv is the environment variable with the hex-encoded key that
+// is used if Options.PayloadKey is nil.
+const payloadKeyEnv = "PUBSUB_PAYLOAD_KEY"
+
+// newAEAD returns the cipher for the payload key of opts, or nil if there
+// is no key.
+func newAEAD(opts Options) (cipher.AEAD, error) {
+	key := opts.PayloadKey
+	if key == nil {
+		env := os.Getenv(payloadKeyEnv)
+		if env == "" {
+			return nil, nil
+		}
+		var err error
+		key, err = hex.DecodeString(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", payloadKeyEnv, err)
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the payload of f, which is in the clear and has no MAC
+// yet, and returns f with the encrypted payload and its nonce.
+func seal(aead cipher.AEAD, f wire.Frame) (wire.Frame, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return f, fmt.Errorf("cannot make a nonce: %w", err)
+	}
+	ad, err := additionalData(f)
+	if err != nil {
+		return f, err
+	}
+	f.Payload = aead.Seal(nil, nonce, f.Payload, ad)
+	f.Header.Nonce = string(nonce)
+	return f, nil
+}
+
+// open decrypts the payload of a frame that seal encrypted. aead may be
+// nil. A frame without a nonce is in the clear, which open refuses.
+func open(aead cipher.AEAD, f wire.Frame) ([]byte, error) {
+	if aead == nil {
+		return nil, fmt.Errorf("%w: no payload key", ErrDecrypt)
+	}
+	if f.Header.Nonce == "" {
+		return nil, fmt.Errorf("%w: message in the clear", ErrDecrypt)
+	}
+	if len(f.Header.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: nonce of %d bytes", ErrDecrypt, len(f.Header.Nonce))
+	}
+	ad, err := additionalData(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	payload, err := aead.Open(nil, []byte(f.Header.Nonce), f.Payload, ad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return payload, nil
+}
+
+// additionalData returns what the encryption of the payload of f
+// authenticates besides the payload: the frame without the payload, the
+// nonce, the MAC, and the flags.
+func additionalData(f wire.Frame) ([]byte, error) {
+	f.Payload = nil
+	f.Header.Nonce, f.Header.MAC = "", ""
+	f.Header.Repeat, f.Header.Unsequenced = false, false
+	return wire.Encode(f)
+}
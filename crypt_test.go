@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef")
+	otherKey = []byte("fedcba9876543210")
+)
+
+func testAEAD(t *testing.T, key []byte) cipher.AEAD {
+	t.Helper()
+	aead, err := newAEAD(Options{PayloadKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+// sealedFrame returns an encrypted frame of a compressible message.
+func sealedFrame(t *testing.T) []byte {
+	t.Helper()
+	msg := Message{Topic: "secret", Payload: bytes.Repeat([]byte("confidential "), 20), Codec: "json", Seq: 7}
+	raw, err := encodeMessage(wire.Binary, msg, &sendOptions{aead: testAEAD(t, testKey), compressAbove: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("confidential")) {
+		t.Fatal("payload is in the clear")
+	}
+	return raw
+}
+
+func TestCryptRoundTrip(t *testing.T) {
+	raw := sealedFrame(t)
+	msg, err := parseMessage(raw, &receiveOptions{aead: testAEAD(t, testKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Repeat([]byte("confidential "), 20); !bytes.Equal(msg.Payload, want) || msg.Topic != "secret" || msg.Seq != 7 {
+		t.Errorf("got %q %q seq %d", msg.Topic, msg.Payload, msg.Seq)
+	}
+
+	// The flags are set on the way and may change.
+	f, _ := wire.Decode(raw)
+	f.Header.Repeat = true
+	marked, _ := wire.Encode(f)
+	if _, err := parseMessage(marked, &receiveOptions{aead: testAEAD(t, testKey)}); err != nil {
+		t.Errorf("repeat: %v", err)
+	}
+}
+
+func TestCryptRejects(t *testing.T) {
+	raw := sealedFrame(t)
+	tamper := func(edit func(f *wire.Frame)) []byte {
+		f, err := wire.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Payload = append([]byte(nil), f.Payload...)
+		edit(&f)
+		out, err := wire.Encode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	clear, err := encodeMessage(wire.Binary, Message{Topic: "secret", Payload: []byte("x")}, &sendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		raw  []byte
+		key  []byte
+	}{
+		{"no key", raw, nil},
+		{"wrong key", raw, otherKey},
+		{"ciphertext", tamper(func(f *wire.Frame) { f.Payload[0] ^= 1 }), testKey},
+		{"topic", tamper(func(f *wire.Frame) { f.Topic = "public" }), testKey},
+		{"sequence", tamper(func(f *wire.Frame) { f.Header.Seq++ }), testKey},
+		{"codec", tamper(func(f *wire.Frame) { f.Header.Codec = "proto" }), testKey},
+		{"encoding", tamper(func(f *wire.Frame) { f.Header.Encoding = "" }), testKey},
+		{"nonce", tamper(func(f *wire.Frame) { f.Header.Nonce = f.Header.Nonce[1:] }), testKey},
+		{"in the clear", clear, testKey},
+		{"text framing", []byte("secret|x"), testKey},
+	}
+	for _, tt := range tests {
+		opts := &receiveOptions{}
+		if tt.key != nil {
+			opts.aead = testAEAD(t, tt.key)
+		}
+		if _, err := parseMessage(tt.raw, opts); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: got %v, want ErrDecrypt", tt.name, err)
+		}
+	}
+}
+
+func TestCryptPublishSubscribe(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{PayloadKey: testKey})
+	s := newTestSubscriber(t, url, Options{PayloadKey: testKey}, "secret")
+	wrong := newTestSubscriber(t, url, Options{PayloadKey: otherKey}, "secret")
+	if err := p.Publish("secret", "hush"); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := s.Receive(); err != nil || msg != "hush" {
+		t.Errorf("got %q, %v; want hush", msg, err)
+	}
+	if _, _, err := wrong.Receive(); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("subscriber with the wrong key got %v, want ErrDecrypt", err)
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A publisher with several URLs sends every message to the subscribers of
+// all of them.
+func TestPublisherURLs(t *testing.T) {
+	inproc := testURL(t)
+	p, err := NewPublisherURLs([]string{inproc, "tcp://127.0.0.1:0"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	addrs := p.Addrs()
+	if len(addrs) != 2 || addrs[0] != inproc || p.Addr() != inproc || strings.HasSuffix(addrs[1], ":0") {
+		t.Fatalf("Addrs() = %v, want %s and the TCP port that was picked", addrs, inproc)
+	}
+
+	var subs []*Subscriber
+	for _, addr := range addrs {
+		s := newTestSubscriber(t, addr, Options{}, "a", "ping")
+		waitFlow(t, p, s)
+		subs = append(subs, s)
+	}
+	if err := p.Publish("a", "to all"); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range subs {
+		if msg := receiveTopics(t, s, 1)[0]; string(msg.Payload) != "to all" {
+			t.Errorf("subscriber of %s received %q", addrs[i], msg.Payload)
+		}
+	}
+}
+
+// If one URL fails, the error names it, and the other URLs are free
+// again.
+func TestPublisherURLsFail(t *testing.T) {
+	ok := testURL(t)
+	_, err := NewPublisherURLs([]string{ok, "nosuch://x"}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "nosuch://x") {
+		t.Fatalf("NewPublisherURLs = %v, want an error that names nosuch://x", err)
+	}
+	if strings.Contains(err.Error(), ok) {
+		t.Errorf("error %q names %s, which did not fail", err, ok)
+	}
+	newTestPublisher(t, ok, Options{})
+}
+
+// A subscriber of several publishers receives the messages of all of
+// them, with the sequence numbers of each.
+func TestSubscriberURLs(t *testing.T) {
+	var pubs []*Publisher
+	var urls []string
+	for _, name := range []string{"-eu", "-us"} {
+		url := testURL(t) + name
+		pubs = append(pubs, newTestPublisher(t, url, Options{}))
+		urls = append(urls, url)
+	}
+	s, err := NewSubscriberURLs(append(urls, "nosuch://x"), Options{}, "a", "ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetRecvDeadline(2 * time.Second)
+	for _, p := range pubs {
+		waitFlow(t, p, s)
+	}
+
+	for i := 0; i < 3; i++ {
+		for j, p := range pubs {
+			if err := p.Publish("a", urls[j]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	got := map[string][]uint64{}
+	for _, msg := range receiveTopics(t, s, 6) {
+		got[string(msg.Payload)] = append(got[string(msg.Payload)], msg.Seq)
+	}
+	want := map[string][]uint64{urls[0]: {1, 2, 3}, urls[1]: {1, 2, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if s.Missed() != 0 {
+		t.Errorf("Missed() = %d, want 0", s.Missed())
+	}
+
+	strict, err := NewSubscriberURLs(urls[:1], Options{StrictDial: true})
+	if err != nil {
+		t.Fatalf("StrictDial with a good URL: %v", err)
+	}
+	strict.Close()
+	_, err = NewSubscriberURLs(append(urls, "nosuch://x"), Options{StrictDial: true})
+	if err == nil || !strings.Contains(err.Error(), "nosuch://x") {
+		t.Errorf("StrictDial with a bad URL = %v, want an error that names it", err)
+	}
+	if _, err := NewSubscriberURLs([]string{"nosuch://x", "nosuch://y"}, Options{}); err == nil {
+		t.Error("NewSubscriberURLs without a URL that can be dialed succeeds")
+	}
+}
@@ -0,0 +1,77 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-mangos/mangos"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// Callers need to tell the usual failures apart: a quiet period, a closed
+// subscriber, or a message that cannot be parsed. These errors can be
+// checked with errors.Is, no matter how much context was added to them.
+
+var (
+	// ErrTimeout is returned when no message arrives within the receive
+	// deadline (see Subscriber.SetRecvDeadline).
+	ErrTimeout = errors.New("receive timed out")
+
+	// ErrClosed is returned when sending or receiving on a publisher or
+	// subscriber that was closed, including a receive that was waiting
+	// when Close was called.
+	ErrClosed = errors.New("publisher or subscriber is closed")
+
+	// ErrBadEnvelope is returned for a message whose frame is malformed,
+	// and for a message that cannot be framed, like one whose topic
+	// contains a NUL byte or "|".
+	ErrBadEnvelope = wire.ErrBadFrame
+
+	// ErrDecrypt is returned for an encrypted message that the subscriber
+	// cannot decrypt, because it has no key or the wrong one, or because
+	// the message was tampered with, and for a message in the clear that
+	// a subscriber with a key receives (see crypt.go).
+	ErrDecrypt = errors.New("cannot decrypt payload")
+)
+
+// socketError replaces the errors of Mangos for which there is an error of
+// our own.
+func socketError(err error) error {
+	switch err {
+	case mangos.ErrRecvTimeout:
+		return ErrTimeout
+	case mangos.ErrClosed:
+		return ErrClosed
+	}
+	return err
+}
+
+// endpointErrors collects the errors of several endpoints (see
+// NewPublisherURLs). Unwrap returns the first one, so that errors.Is and
+// errors.As can look into it.
+type endpointErrors []error
+
+func (e endpointErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e endpointErrors) Unwrap() error {
+	return e[0]
+}
+
+// errorOf returns nil for no errors, the error itself for one error, and
+// an endpointErrors for more.
+func (e endpointErrors) errorOf() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
@@ -0,0 +1,213 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/appliedgo/pubsub"
+)
+
+// waitReady is the readiness barrier of the examples. When WaitConnected
+// returns, the subscriber has a connection, but the publisher starts
+// sending on it a moment later. So the publisher repeats a message on the
+// topic "ready" until the subscriber has one. The subscriber is subscribed
+// to "ready" only meanwhile.
+func waitReady(p *pubsub.Publisher, subscribers ...*pubsub.Subscriber) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, s := range subscribers {
+		if err := s.WaitConnected(ctx); err != nil {
+			log.Fatal(err)
+		}
+		if err := s.Subscribe("ready"); err != nil {
+			log.Fatal(err)
+		}
+		for {
+			if err := p.Publish("ready", ""); err != nil {
+				log.Fatal(err)
+			}
+			short, stop := context.WithTimeout(ctx, 20*time.Millisecond)
+			topic, _, err := s.ReceiveContext(short)
+			stop()
+			if err == nil && topic == "ready" {
+				break
+			}
+			if ctx.Err() != nil {
+				log.Fatal("subscriber not ready")
+			}
+		}
+		// Unsubscribing discards the repetitions that are still queued.
+		if err := s.Unsubscribe("ready"); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func Example_publisher() {
+	// A publisher listens on a URL; inproc connects sockets within the
+	// process, tcp and the other transports work the same way.
+	p, err := pubsub.NewPublisher("inproc://example-publisher")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+
+	// A subscriber to watch what the publisher sends.
+	s, err := pubsub.NewSubscriber(p.Addr(), "Weather")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+	waitReady(p, s)
+
+	p.Publish("Weather", "Sunny")
+	p.Publish("Finance", "Stocks up") // nobody subscribed
+	p.PublishMessage(pubsub.Message{Topic: "Weather.Berlin", Payload: []byte("Rain")})
+	p.PublishJSON("Weather", map[string]int{"celsius": 21})
+
+	for i := 0; i < 3; i++ {
+		msg, err := s.ReceiveMessage()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s (seq %d, codec %q): %s\n", msg.Topic, msg.Seq, msg.Codec, msg.Payload)
+	}
+
+	// Output:
+	// Weather (seq 1, codec ""): Sunny
+	// Weather.Berlin (seq 1, codec ""): Rain
+	// Weather (seq 2, codec "json"): {"celsius":21}
+}
+
+func Example_subscriber() {
+	p, err := pubsub.NewPublisher("inproc://example-subscriber")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+
+	// A subscriber gets the topics it subscribed to and all topics below
+	// them, and whatever its patterns match.
+	s, err := pubsub.NewSubscriber(p.Addr(), "finance.eu")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := s.SubscribePattern("sports.*.scores"); err != nil {
+		log.Fatal(err)
+	}
+	waitReady(p, s)
+
+	for _, topic := range []string{
+		"finance.eu",
+		"finance.europe",
+		"finance.eu.bonds",
+		"sports.tennis.scores",
+		"sports.tennis.live.scores",
+	} {
+		p.Publish(topic, "news")
+	}
+	for i := 0; i < 3; i++ {
+		topic, message, err := s.Receive()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(topic, message)
+	}
+
+	// After Close, receiving fails with ErrClosed.
+	s.Close()
+	_, _, err = s.Receive()
+	fmt.Println(errors.Is(err, pubsub.ErrClosed))
+
+	// Output:
+	// finance.eu news
+	// finance.eu.bonds news
+	// sports.tennis.scores news
+	// true
+}
+
+// The demo of cmd/pubsub in a nutshell: a server publishes a few rounds of
+// messages, and three clients with different subscriptions handle the
+// topics they are interested in, until the server says goodbye.
+func Example_demo() {
+	p, err := pubsub.NewPublisher("inproc://example-demo")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+
+	clients := []struct {
+		name   string
+		topics []string
+	}{
+		{"C1", []string{"Technology"}},
+		{"C2", []string{"Technology", "Weather"}},
+		{"C3", []string{"Finance"}},
+	}
+	subscribers := make([]*pubsub.Subscriber, len(clients))
+	received := make([][]string, len(clients))
+	runs := make([]context.Context, len(clients))
+	for i, c := range clients {
+		s, err := pubsub.NewSubscriber(p.Addr())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		subscribers[i] = s
+
+		// A client is done when it has the goodbye of each of its topics.
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		runs[i] = ctx
+		goodbyes := 0
+		i := i
+		for _, topic := range c.topics {
+			err := s.Handle(topic, func(msg pubsub.Message) error {
+				received[i] = append(received[i], msg.Topic+": "+string(msg.Payload))
+				if string(msg.Payload) == "goodbye" {
+					goodbyes++
+				}
+				if goodbyes == len(clients[i].topics) {
+					stop()
+				}
+				return nil
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	waitReady(p, subscribers...)
+	done := make(chan struct{})
+	for i, s := range subscribers {
+		go func(ctx context.Context, s *pubsub.Subscriber) {
+			// Run calls the handlers until ctx is done.
+			s.Run(ctx)
+			done <- struct{}{}
+		}(runs[i], s)
+	}
+
+	topics := []string{"Technology", "Weather", "Finance"}
+	for round := 1; round <= 2; round++ {
+		for _, topic := range topics {
+			p.Publish(topic, fmt.Sprintf("news #%d", round))
+		}
+	}
+	for _, topic := range topics {
+		p.Publish(topic, "goodbye")
+	}
+	for range clients {
+		<-done
+	}
+	for i, c := range clients {
+		fmt.Println(c.name, received[i])
+	}
+
+	// Output:
+	// C1 [Technology: news #1 Technology: news #2 Technology: goodbye]
+	// C2 [Technology: news #1 Weather: news #1 Technology: news #2 Weather: news #2 Technology: goodbye Weather: goodbye]
+	// C3 [Finance: news #1 Finance: news #2 Finance: goodbye]
+}
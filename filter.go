@@ -0,0 +1,44 @@
+package pubsub
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Topics select messages by what they are about. A filter can select them
+// by content, for example only Weather messages that mention "storm".
+
+// SetFilter sets a function that decides, after topic and pattern matching,
+// which messages the subscriber delivers. Rejected messages are counted
+// (see Filtered). If the filter panics, the message is logged and dropped
+// as well; receiving continues. A nil filter delivers all messages.
+//
+// The filter runs on the receiving goroutine, so it should be fast.
+func (s *Subscriber) SetFilter(filter func(Message) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = filter
+}
+
+// applyFilter calls filter and turns a panic into a rejection.
+func applyFilter(filter func(Message) bool, msg Message) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger().Error("Filter panicked", "topic", msg.Topic, "panic", r)
+			ok = false
+		}
+	}()
+	return filter(msg)
+}
+
+// PayloadRegexpFilter returns a filter for SetFilter that accepts messages
+// whose payload matches the regular expression pattern.
+func PayloadRegexpFilter(pattern string) (func(Message) bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return func(msg Message) bool {
+		return re.Match(msg.Payload)
+	}, nil
+}
@@ -0,0 +1,102 @@
+package pubsub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPayloadRegexpFilter(t *testing.T) {
+	if _, err := PayloadRegexpFilter("storm("); err == nil || !strings.Contains(err.Error(), "invalid filter") {
+		t.Errorf("PayloadRegexpFilter with an invalid pattern = %v, want an error", err)
+	}
+	filter, err := PayloadRegexpFilter(`^storm|\bhail\b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for payload, want := range map[string]bool{
+		"storm warning": true,
+		"no storm":      false,
+		"hail, later":   true,
+		"hailstones":    false,
+		"":              false,
+	} {
+		if got := filter(Message{Payload: []byte(payload)}); got != want {
+			t.Errorf("filter(%q) = %v, want %v", payload, got, want)
+		}
+	}
+}
+
+// publishPayloads publishes each payload on topic.
+func publishPayloads(t *testing.T, p *Publisher, topic string, payloads ...string) {
+	t.Helper()
+	for _, payload := range payloads {
+		if err := p.Publish(topic, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// receivePayloads receives n messages from s, without pings, and returns
+// their payloads.
+func receivePayloads(t *testing.T, s *Subscriber, n int) []string {
+	t.Helper()
+	var got []string
+	for _, msg := range receiveTopics(t, s, n) {
+		got = append(got, string(msg.Payload))
+	}
+	return got
+}
+
+// Messages that the filter rejects are skipped and counted.
+func TestFilterSkips(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "Weather", "ping")
+	waitFlow(t, p, s)
+	filter, err := PayloadRegexpFilter("storm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetFilter(filter)
+
+	publishPayloads(t, p, "Weather", "sunny", "storm warning", "calm", "storm")
+	if got := receivePayloads(t, s, 2); got[0] != "storm warning" || got[1] != "storm" {
+		t.Errorf("received %q, want the two storm messages", got)
+	}
+	if n := s.Filtered(); n != 2 {
+		t.Errorf("Filtered() = %d, want 2", n)
+	}
+
+	s.SetFilter(nil)
+	publishPayloads(t, p, "Weather", "sunny")
+	if got := receivePayloads(t, s, 1); got[0] != "sunny" {
+		t.Errorf("without a filter, received %q, want sunny", got[0])
+	}
+}
+
+// A panicking filter drops the message and logs the panic, and the
+// subscriber goes on receiving.
+func TestFilterPanic(t *testing.T) {
+	logs := captureLog(t)
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "Weather", "ping")
+	waitFlow(t, p, s)
+	s.SetFilter(func(msg Message) bool {
+		if string(msg.Payload) == "boom" {
+			panic("boom")
+		}
+		return true
+	})
+
+	publishPayloads(t, p, "Weather", "before", "boom", "after")
+	if got := receivePayloads(t, s, 2); got[0] != "before" || got[1] != "after" {
+		t.Errorf("received %q, want before and after", got)
+	}
+	if n := s.Filtered(); n != 1 {
+		t.Errorf("Filtered() = %d, want 1", n)
+	}
+	if line := waitLog(t, logs, "Filter panicked"); !strings.Contains(line, "topic=Weather") {
+		t.Errorf("log line %q does not name the topic", line)
+	}
+}
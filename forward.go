@@ -0,0 +1,164 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-mangos/mangos"
+)
+
+// With many subscribers, a single publisher has to send each message to
+// each of them. A forwarder takes some of this load: it subscribes to one
+// or more publishers and publishes everything it receives again, for its
+// own subscribers. Forwarders can be chained, and subscribers dial into a
+// forwarder just like into a publisher.
+//
+// Without rules (see SetRules), the forwarder does not look into the
+// messages; they go out exactly as they came in. Its subscribers filter by
+// topic as usual. The exception is a forwarder with several upstream
+// publishers: their sequence numbers of the same topic would look like
+// gaps and restarts downstream, so it marks the frames as unsequenced (see
+// seq.go).
+
+// Forwarder relays all messages from upstream publishers to its own
+// subscribers.
+type Forwarder struct {
+	// Accessed atomically; first fields for 64-bit alignment.
+	forwarded uint64
+	dropped   uint64
+	filtered  uint64
+
+	up    mangos.Socket // SUB
+	down  mangos.Socket // PUB
+	addrs []string
+
+	// asciiTopics tells the rules how topics go on the wire upstream;
+	// see Options.ASCIITopics.
+	asciiTopics bool
+
+	// merged is true with several upstream publishers.
+	merged bool
+
+	closing int32 // set by Close; accessed atomically
+
+	mu    sync.Mutex
+	rules *ruleSet // nil if there are no rules
+}
+
+// NewForwarder creates a forwarder that dials into the publishers at the
+// upstream URLs and listens on the downstream URLs. upOpts apply to the
+// upstream side, as for NewSubscriberURLs, and downOpts to the downstream
+// side, as for NewPublisherURLs. Call Run to start forwarding.
+func NewForwarder(upstream []string, upOpts Options, downstream []string, downOpts Options) (*Forwarder, error) {
+	down, addrs, err := newPublisherSocket(downstream, downOpts, nil)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forwarder{
+		down:        down,
+		addrs:       addrs,
+		asciiTopics: upOpts.ASCIITopics,
+		merged:      len(upstream) > 1,
+	}
+	up, err := newSubscriberSocket(upstream, upOpts, f.portHook)
+	if err != nil {
+		down.Close()
+		return nil, err
+	}
+	// The empty prefix matches everything.
+	err = subscribe(up, "")
+	if err != nil {
+		up.Close()
+		down.Close()
+		return nil, err
+	}
+	f.up = up
+	return f, nil
+}
+
+// portHook reports lost upstream connections, except while the forwarder
+// closes.
+func (f *Forwarder) portHook(action mangos.PortAction, port mangos.Port) bool {
+	reportDisconnect(action, port, atomic.LoadInt32(&f.closing) == 1)
+	return true
+}
+
+// Addrs returns the URLs that the forwarder listens on, like
+// Publisher.Addrs.
+func (f *Forwarder) Addrs() []string {
+	return append([]string(nil), f.addrs...)
+}
+
+// Run forwards messages until ctx is done or the forwarder is closed.
+// It returns nil in both cases, and any other error that stops it.
+func (f *Forwarder) Run(ctx context.Context) error {
+	// As with ReceiveContext, a short receive deadline lets Run check the
+	// context now and then.
+	err := f.up.SetOption(mangos.OptionRecvDeadline, receivePollInterval)
+	if err != nil {
+		return socketError(err)
+	}
+	for ctx.Err() == nil {
+		msg, err := f.up.RecvMsg()
+		switch socketError(err) {
+		case nil:
+		case ErrTimeout:
+			continue
+		case ErrClosed:
+			return nil
+		default:
+			return socketError(err)
+		}
+		body := f.applyRules(msg.Body)
+		if body == nil {
+			atomic.AddUint64(&f.filtered, 1)
+			msg.Free()
+			continue
+		}
+		if f.merged {
+			body = markUnsequenced(body)
+		}
+		msg.Body = body
+		// SendMsg takes over msg, including the original bytes.
+		if f.down.SendMsg(msg) != nil {
+			atomic.AddUint64(&f.dropped, 1)
+			continue
+		}
+		atomic.AddUint64(&f.forwarded, 1)
+	}
+	return nil
+}
+
+// applyRules returns the frame to send for raw, or nil if the rules drop
+// it.
+func (f *Forwarder) applyRules(raw []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rules == nil {
+		return raw
+	}
+	return f.rules.apply(raw)
+}
+
+// Forwarded returns the number of messages forwarded so far.
+func (f *Forwarder) Forwarded() uint64 {
+	return atomic.LoadUint64(&f.forwarded)
+}
+
+// Dropped returns the number of messages that could not be sent on.
+// Messages that a slow subscriber of the forwarder cannot take are dropped
+// by Mangos, as with any publisher, and not counted here.
+func (f *Forwarder) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// Close closes both sides of the forwarder. A running Run returns.
+func (f *Forwarder) Close() error {
+	atomic.StoreInt32(&f.closing, 1)
+	err := f.up.Close()
+	if derr := f.down.Close(); err == nil {
+		err = derr
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// A forwarder can also reshape the traffic that passes through it: rename
+// topics, drop them, or pass on only a sample. Rules select messages by
+// topic, like subscriptions do, so a rule for "Weather" also applies to
+// "Weather.eu", but not to "Weatherman".
+
+// ForwardRule is a rule for a Forwarder. Exactly one of Rewrite, Drop, and
+// Sample must be set. The JSON form is, for example,
+//
+//	{"match": "Weather", "rewrite": "env.weather"}
+type ForwardRule struct {
+	// Match is the topic that the rule applies to, including its
+	// children. The empty topic matches all messages.
+	Match string `json:"match"`
+
+	// Rewrite replaces the matched part of the topic. "Weather.eu"
+	// becomes "env.weather.eu" with Match "Weather" and Rewrite
+	// "env.weather". Match must not be empty, as there would be no
+	// separator between Rewrite and the rest of the topic. The payload
+	// and header stay as they are, so
+	// subscribers with an authentication or payload key reject the
+	// rewritten messages, whose MAC and encryption cover the old topic.
+	Rewrite string `json:"rewrite,omitempty"`
+
+	// Drop discards the messages.
+	Drop bool `json:"drop,omitempty"`
+
+	// Sample passes on the first message and then every Sample-th. The
+	// messages that pass are marked as unsequenced, as subscribers would
+	// take the left-out ones for a gap (see seq.go).
+	Sample int `json:"sample,omitempty"`
+}
+
+// ErrBadRule is returned by SetRules for an invalid rule.
+var ErrBadRule = errors.New("invalid forward rule")
+
+// ruleSet is the state of the rules of a forwarder.
+type ruleSet struct {
+	rules   []ForwardRule
+	match   []string // Match in wire form
+	rewrite []string // Rewrite in wire form
+	seen    []uint64 // matching messages per rule, for sampling
+}
+
+// SetRules replaces the rules of the forwarder. For each message, the
+// first rule that matches its topic applies; messages that no rule
+// matches pass unchanged. Sampling starts over with the new rules.
+// SetRules may be called while Run is running.
+func (f *Forwarder) SetRules(rules []ForwardRule) error {
+	set := &ruleSet{
+		rules:   append([]ForwardRule(nil), rules...),
+		match:   make([]string, len(rules)),
+		rewrite: make([]string, len(rules)),
+		seen:    make([]uint64, len(rules)),
+	}
+	for i, r := range rules {
+		actions := 0
+		if r.Rewrite != "" {
+			actions++
+		}
+		if r.Drop {
+			actions++
+		}
+		if r.Sample != 0 {
+			actions++
+		}
+		switch {
+		case actions != 1:
+			return fmt.Errorf("%w %d (%q): needs exactly one of rewrite, drop, and sample", ErrBadRule, i+1, r.Match)
+		case r.Sample < 0:
+			return fmt.Errorf("%w %d (%q): negative sample", ErrBadRule, i+1, r.Match)
+		case r.Rewrite != "" && r.Match == "":
+			return fmt.Errorf("%w %d: rewrite needs a topic to match", ErrBadRule, i+1)
+		}
+		set.match[i] = wire.Topic(r.Match, f.asciiTopics)
+		set.rewrite[i] = wire.Topic(r.Rewrite, f.asciiTopics)
+	}
+	f.mu.Lock()
+	f.rules = set
+	f.mu.Unlock()
+	return nil
+}
+
+// Filtered returns the number of messages dropped or sampled out by rules.
+func (f *Forwarder) Filtered() uint64 {
+	return atomic.LoadUint64(&f.filtered)
+}
+
+// apply applies the rules to the raw frame. It returns the frame to send,
+// or nil if the message is to be dropped.
+func (set *ruleSet) apply(raw []byte) []byte {
+	for i, r := range set.rules {
+		if !matchesTopic(raw, set.match[i]) {
+			continue
+		}
+		switch {
+		case r.Drop:
+			return nil
+		case r.Sample > 0:
+			set.seen[i]++
+			if (set.seen[i]-1)%uint64(r.Sample) != 0 {
+				return nil
+			}
+			return markUnsequenced(raw)
+		}
+		return rewriteTopic(raw, set.match[i], set.rewrite[i])
+	}
+	return raw
+}
+
+// rewriteTopic replaces the prefix from of the frame's topic by to. The
+// frame keeps its format, header, and payload. A frame that cannot be
+// decoded or encoded again is passed on unchanged.
+func rewriteTopic(raw []byte, from, to string) []byte {
+	f, err := wire.Decode(raw)
+	if err != nil {
+		return raw
+	}
+	f.Topic = to + f.Topic[len(from):]
+	out, err := wire.Encode(f)
+	if err != nil {
+		return raw
+	}
+	return out
+}
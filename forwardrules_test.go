@@ -0,0 +1,141 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// rulesOf returns the rule set that SetRules makes of rules.
+func rulesOf(t *testing.T, rules ...ForwardRule) *ruleSet {
+	t.Helper()
+	f := &Forwarder{}
+	if err := f.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	return f.rules
+}
+
+func TestForwardRulesInvalid(t *testing.T) {
+	for _, r := range []ForwardRule{
+		{Match: "a"},
+		{Match: "a", Drop: true, Sample: 2},
+		{Match: "a", Rewrite: "b", Drop: true},
+		{Match: "a", Sample: -1},
+		{Match: "", Rewrite: "env"},
+	} {
+		f := &Forwarder{}
+		if err := f.SetRules([]ForwardRule{{Match: "ok", Drop: true}, r}); !errors.Is(err, ErrBadRule) {
+			t.Errorf("SetRules with %+v = %v, want ErrBadRule", r, err)
+		}
+	}
+}
+
+// A rewrite changes the topic and nothing else, in both framings.
+func TestForwardRulesRewrite(t *testing.T) {
+	set := rulesOf(t, ForwardRule{Match: "Weather", Rewrite: "env.weather"})
+	payload := []byte("22\x00|\xff°C\n")
+	for _, format := range []wire.Format{wire.Binary, wire.Text} {
+		raw, err := encodeMessage(format, Message{Topic: "Weather.eu", Payload: payload, Seq: 7}, &sendOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, err := wire.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := wire.Decode(set.apply(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Topic != "env.weather.eu" {
+			t.Errorf("format %v: topic %q, want env.weather.eu", format, after.Topic)
+		}
+		if !bytes.Equal(after.Payload, before.Payload) || after.Header != before.Header || after.Format != format {
+			t.Errorf("format %v: rewritten frame %+v, want %+v with another topic", format, after, before)
+		}
+	}
+
+	// "Weatherman" is not below "Weather".
+	raw, _ := encodeMessage(wire.Binary, Message{Topic: "Weatherman", Payload: payload}, &sendOptions{})
+	if out := set.apply(raw); !bytes.Equal(out, raw) {
+		t.Errorf("Weatherman rewritten to %q", out)
+	}
+}
+
+// Sampling passes the first message of a topic and then every n-th, the
+// same way each time, and starts over with new rules.
+func TestForwardRulesSampling(t *testing.T) {
+	rules := []ForwardRule{{Match: "ticks", Sample: 3}}
+	sample := func(set *ruleSet) []uint64 {
+		var passed []uint64
+		for seq := uint64(1); seq <= 10; seq++ {
+			raw, err := encodeMessage(wire.Binary, Message{Topic: "ticks", Seq: seq}, &sendOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := set.apply(raw)
+			if out == nil {
+				continue
+			}
+			f, err := wire.Decode(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.Header.Unsequenced {
+				t.Errorf("seq %d passed without the unsequenced flag", seq)
+			}
+			passed = append(passed, f.Header.Seq)
+		}
+		return passed
+	}
+	want := []uint64{1, 4, 7, 10}
+	set := rulesOf(t, rules...)
+	if got := sample(set); !reflect.DeepEqual(got, want) {
+		t.Errorf("passed %v, want %v", got, want)
+	}
+	if got := sample(rulesOf(t, rules...)); !reflect.DeepEqual(got, want) {
+		t.Errorf("with new rules, passed %v, want %v", got, want)
+	}
+	// The count goes on with the same rules: the 11th and 12th message
+	// are left out, the 13th passes.
+	if got := sample(set); !reflect.DeepEqual(got, []uint64{3, 6, 9}) {
+		t.Errorf("continued, passed %v, want [3 6 9]", got)
+	}
+}
+
+// Dropped messages count as filtered, and the others pass.
+func TestForwardRulesDrop(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	f, err := NewForwarder([]string{up}, Options{}, []string{down}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.SetRules([]ForwardRule{{Match: "noise", Drop: true}}); err != nil {
+		t.Fatal(err)
+	}
+	go f.Run(context.Background())
+	s := newTestSubscriber(t, down, Options{}, "", "ping")
+	waitFlow(t, p, s)
+
+	publishAll(t, p, "noise", "noise.loud", "a", "noise")
+	publishAll(t, p, "b")
+	got := receiveTopics(t, s, 2)
+	if got[0].Topic != "a" || got[1].Topic != "b" {
+		t.Errorf("received %s and %s, want a and b", got[0].Topic, got[1].Topic)
+	}
+	deadline := time.Now().Add(time.Second)
+	for f.Filtered() != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := f.Filtered(); n != 3 {
+		t.Errorf("Filtered() = %d, want 3", n)
+	}
+}
@@ -1,8 +1,13 @@
 module github.com/appliedgo/pubsub
 
-go 1.13
+go 1.21
 
 require (
 	github.com/go-mangos/mangos v1.1.1-0.20160525152327-83e303c317b5
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/gorilla/websocket v1.4.2
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/text v0.3.8
+	google.golang.org/protobuf v1.26.0
 )
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,194 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// A third way to consume messages, after Receive and Messages: register a
+// handler per topic, like with http.ServeMux, and let Run call them. Each
+// message goes to the handler of the longest registered topic that
+// matches it, so a handler for "finance.eu" takes precedence over one for
+// "finance". Topics match as in Subscribe. Messages that no topic matches
+// go to the handlers of topic templates, which get the placeholder values
+// along with the message, and then to the default handler.
+
+// HandlerFunc handles a message. Errors go to the function set with
+// OnHandlerError.
+type HandlerFunc func(Message) error
+
+// handler is a registered handler.
+type handler struct {
+	topic string // in wire form
+	fn    HandlerFunc
+}
+
+// templateHandler is a handler registered for a topic template.
+type templateHandler struct {
+	template *TopicTemplate
+	fn       HandlerFunc
+}
+
+// Handle subscribes to topic and registers fn for it. Registering a topic
+// again replaces its handler.
+func (s *Subscriber) Handle(topic string, fn HandlerFunc) error {
+	err := s.Subscribe(topic)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.wireTopic(topic)
+	for i, h := range s.handlers {
+		if h.topic == t {
+			s.handlers[i].fn = fn
+			return nil
+		}
+	}
+	s.handlers = append(s.handlers, handler{topic: t, fn: fn})
+	return nil
+}
+
+// HandleTemplate subscribes to the topics that match t and registers fn
+// for them. Before fn gets a message, Run sets Message.Vars to the values
+// of the placeholders in its topic. A topic registered with Handle takes
+// precedence over templates, and of several matching templates, the one
+// registered first gets the message. Registering a template with the same
+// text again replaces its handler.
+//
+// Like SubscribePattern, HandleTemplate subscribes the socket to the
+// literal part before the first placeholder and drops the messages that
+// do not match the whole template.
+func (s *Subscriber) HandleTemplate(t *TopicTemplate, fn HandlerFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, h := range s.templates {
+		if h.template.text == t.text {
+			s.templates[i].fn = fn
+			return nil
+		}
+	}
+	literal := t.literal()
+	err := s.addPrefixes(s.wireTopic(literal))
+	if err != nil {
+		return fmt.Errorf("cannot subscribe to template %s: %w", t, err)
+	}
+	s.index.Insert(literal, t.match.MatchString, s.use(t.text))
+	s.templates = append(s.templates, templateHandler{template: t, fn: fn})
+	return nil
+}
+
+// HandleDefault sets the handler for messages that no registered topic
+// matches, like those of topics subscribed with SubscribePattern or
+// SubscribeAll. Without a default handler, Run ignores them.
+func (s *Subscriber) HandleDefault(fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = fn
+}
+
+// OnHandlerError sets a function that is called when a handler returns an
+// error or panics. By default, the error is logged.
+func (s *Subscriber) OnHandlerError(fn func(msg Message, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onHandlerError = fn
+}
+
+// Run receives messages and passes each to its handler, until ctx is done
+// or the subscriber is closed. It returns nil in both cases, and any other
+// error that stops receiving. Like Messages, it skips malformed messages
+// and does not mind receive timeouts. Handlers run one after the other on
+// the goroutine that called Run.
+//
+// Do not mix Run with Receive, ReceiveContext, or Messages.
+func (s *Subscriber) Run(ctx context.Context) error {
+	for {
+		msg, err := receiveContext(ctx, s.socket, &s.recv)
+		switch {
+		case err == nil:
+		case err == ErrClosed, err == ctx.Err(), err == context.DeadlineExceeded:
+			// receiveContext may notice the deadline of ctx before ctx
+			// does.
+			return nil
+		case err == ErrTimeout, errors.Is(err, ErrBadEnvelope), errors.Is(err, ErrDecrypt):
+			continue
+		default:
+			return err
+		}
+		s.dispatch(msg)
+	}
+}
+
+// dispatch calls the handler for msg.
+func (s *Subscriber) dispatch(msg Message) {
+	s.mu.Lock()
+	fn := s.handlerFor(s.wireTopic(msg.Topic))
+	if fn == nil {
+		fn, msg.Vars = s.templateHandlerFor(msg.Topic)
+	}
+	if fn == nil {
+		fn = s.fallback
+	}
+	if fn != nil {
+		fn = s.chain(fn) // see middleware.go
+	}
+	onError := s.onHandlerError
+	s.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	err := callHandler(fn, msg)
+	if err == nil {
+		return
+	}
+	if onError == nil {
+		logger().Error("Handler failed", "topic", msg.Topic, "error", err)
+		return
+	}
+	onError(msg, err)
+}
+
+// handlerFor returns the handler of the longest registered topic that
+// matches t, which is in wire form, or nil. s.mu must be held.
+func (s *Subscriber) handlerFor(t string) HandlerFunc {
+	var fn HandlerFunc
+	longest := -1
+	for _, h := range s.handlers {
+		if len(h.topic) <= longest {
+			continue
+		}
+		if h.topic == "" || t == h.topic ||
+			strings.HasPrefix(t, h.topic) && strings.IndexByte(topicSeparators, t[len(h.topic)]) >= 0 {
+			fn, longest = h.fn, len(h.topic)
+		}
+	}
+	return fn
+}
+
+// templateHandlerFor returns the handler of the first registered template
+// that matches topic, along with the placeholder values, or nil. s.mu must
+// be held.
+func (s *Subscriber) templateHandlerFor(topic string) (HandlerFunc, map[string]string) {
+	for _, h := range s.templates {
+		if vars, ok := h.template.Match(topic); ok {
+			return h.fn, vars
+		}
+	}
+	return nil, nil
+}
+
+// callHandler calls fn and turns a panic into an error. The stack trace
+// is logged, as the error cannot carry it.
+func callHandler(fn HandlerFunc, msg Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger().Error("Handler panicked", "topic", msg.Topic, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return fn(msg)
+}
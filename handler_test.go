@@ -0,0 +1,159 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runHandlers starts s.Run and returns a function that stops it and
+// reports what Run returned. Calls after the first do nothing.
+func runHandlers(t *testing.T, s *Subscriber) (stop func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	var once sync.Once
+	return func() {
+		t.Helper()
+		once.Do(func() { stopRun(t, cancel, done) })
+	}
+}
+
+// stopRun cancels Run and waits for it to return.
+func stopRun(t *testing.T, cancel func(), done <-chan error) {
+	t.Helper()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Run did not return after cancel")
+	}
+}
+
+// nextHandled returns the next string from handled.
+func nextHandled(t *testing.T, handled <-chan string) string {
+	t.Helper()
+	select {
+	case h := <-handled:
+		return h
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message handled")
+		return ""
+	}
+}
+
+// The handler of the longest matching topic gets a message; the default
+// handler gets those that no topic matches.
+func TestHandleOverlapping(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "ping")
+	waitFlow(t, p, s)
+
+	handled := make(chan string, 10)
+	handle := func(name string) HandlerFunc {
+		return func(msg Message) error {
+			handled <- name + " " + msg.Topic
+			return nil
+		}
+	}
+	s.Handle("ping", func(Message) error { return nil })
+	for _, topic := range []string{"finance", "finance.eu", "finance.eu.de"} {
+		if err := s.Handle(topic, handle(topic)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SubscribePattern("news.*"); err != nil {
+		t.Fatal(err)
+	}
+	s.HandleDefault(handle("default"))
+	stop := runHandlers(t, s)
+	defer stop()
+
+	tests := []struct{ topic, want string }{
+		{"finance", "finance finance"},
+		{"finance.us", "finance finance.us"},
+		{"finance.eu", "finance.eu finance.eu"},
+		{"finance.europe", "finance finance.europe"},
+		{"finance.eu.fr", "finance.eu finance.eu.fr"},
+		{"finance.eu.de.bonds", "finance.eu.de finance.eu.de.bonds"},
+		{"news.today", "default news.today"},
+	}
+	for _, tt := range tests {
+		publishAll(t, p, tt.topic)
+		if got := nextHandled(t, handled); got != tt.want {
+			t.Errorf("%s: handled by %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+
+	// Registering a topic again replaces its handler.
+	s.Handle("finance.eu", handle("replaced"))
+	publishAll(t, p, "finance.eu.fr")
+	if got := nextHandled(t, handled); got != "replaced finance.eu.fr" {
+		t.Errorf("handled by %q after replacing the handler", got)
+	}
+}
+
+// A panicking handler is reported like a handler error, and Run goes on
+// with the next message.
+func TestHandlePanic(t *testing.T) {
+	logs := captureLog(t)
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "ping")
+	waitFlow(t, p, s)
+
+	handled := make(chan string, 10)
+	errs := make(chan error, 10)
+	s.Handle("ping", func(Message) error { return nil })
+	s.Handle("jobs", func(msg Message) error {
+		switch string(msg.Payload) {
+		case "boom":
+			panic("boom")
+		case "fail":
+			return errors.New("failed")
+		}
+		handled <- string(msg.Payload)
+		return nil
+	})
+	s.OnHandlerError(func(msg Message, err error) {
+		errs <- err
+	})
+	stop := runHandlers(t, s)
+	defer stop()
+
+	publishPayloads(t, p, "jobs", "first", "boom", "fail", "second")
+	if got := nextHandled(t, handled); got != "first" {
+		t.Errorf("handled %q, want first", got)
+	}
+	if got := nextHandled(t, handled); got != "second" {
+		t.Errorf("handled %q, want second after the panic", got)
+	}
+	for _, want := range []string{"handler panicked: boom", "failed"} {
+		select {
+		case err := <-errs:
+			if err.Error() != want {
+				t.Errorf("OnHandlerError got %q, want %q", err, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no handler error %q", want)
+		}
+	}
+	if line := waitLog(t, logs, "Handler panicked"); !strings.Contains(line, "topic=jobs") {
+		t.Errorf("log line %q does not name the topic", line)
+	}
+
+	// Without OnHandlerError, handler errors are logged.
+	s.OnHandlerError(nil)
+	publishPayloads(t, p, "jobs", "fail")
+	if line := waitLog(t, logs, "Handler failed"); !strings.Contains(line, "error=failed") {
+		t.Errorf("log line %q does not show the error", line)
+	}
+}
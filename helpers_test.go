@@ -0,0 +1,60 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testURL returns an inproc URL that no other test uses.
+func testURL(t *testing.T) string {
+	return "inproc://" + strings.ReplaceAll(t.Name(), "/", "-")
+}
+
+// newTestPublisher creates a publisher that is closed when the test ends.
+func newTestPublisher(t *testing.T, url string, opts Options) *Publisher {
+	t.Helper()
+	p, err := NewPublisherWithOptions(url, opts)
+	if err != nil {
+		t.Fatalf("NewPublisherWithOptions(%s): %v", url, err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// newTestSubscriber creates a subscriber that is connected to the
+// publisher at url when it returns, and closed when the test ends. It
+// waits for at most two seconds for a message.
+func newTestSubscriber(t *testing.T, url string, opts Options, topics ...string) *Subscriber {
+	t.Helper()
+	s, err := NewSubscriberWithOptions(url, opts, topics...)
+	if err != nil {
+		t.Fatalf("NewSubscriberWithOptions(%s): %v", url, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.SetRecvDeadline(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.WaitConnected(ctx); err != nil {
+		t.Fatalf("subscriber of %s not connected: %v", url, err)
+	}
+	// The subscriber learns about the connection a moment before the
+	// publisher starts sending on it.
+	time.Sleep(50 * time.Millisecond)
+	return s
+}
+
+// receiveN receives n messages from s.
+func receiveN(t *testing.T, s *Subscriber, n int) []Message {
+	t.Helper()
+	msgs := make([]Message, 0, n)
+	for len(msgs) < n {
+		msg, err := s.ReceiveMessage()
+		if err != nil {
+			t.Fatalf("after %d of %d messages: %v", len(msgs), n, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
@@ -0,0 +1,118 @@
+package pubsub
+
+import "time"
+
+// A fixed pace (see ExpectEvery) needs to be known in advance, and one
+// pace rarely fits all topics: a second is far too long for a topic with
+// a thousand messages a second, and far too short for an hourly digest.
+// With ExpectAdaptive, a subscriber learns the pace of a topic instead. It
+// keeps an exponentially weighted moving average (EWMA) of the time
+// between messages and takes the topic for stale, unusually quiet, when
+// no message arrives within a multiple of that mean.
+//
+// An adaptive topic is stale and fresh again just like one with a fixed
+// pace: OnStale reports it, Stale lists it, and Health fails while a
+// critical one is stale. The receive deadline does not take part; it can
+// stay loose and just keep the receive loop responsive.
+//
+// The mean follows the topic when it slows down for good: the long gaps
+// raise it until they are no longer unusual.
+
+// IdleConfig tunes adaptive idle detection; see ExpectAdaptive. The zero
+// value of a field selects its default.
+type IdleConfig struct {
+	// Factor is the multiple of the mean time between messages after
+	// which the topic is stale. The default is 4.
+	Factor float64
+
+	// Alpha is the weight of the latest time between messages in the
+	// mean, above 0 and up to 1. The higher it is, the faster the mean
+	// follows a change of pace. The default is 0.2.
+	Alpha float64
+
+	// Warmup is the number of times between messages that the subscriber
+	// measures before it takes the topic for stale. Until then, only Max
+	// applies. The default is 5.
+	Warmup int
+
+	// Min is the shortest time after which the topic can be stale, to
+	// keep a fast topic from going stale at every hiccup. The default is
+	// no minimum.
+	Min time.Duration
+
+	// Max is the time after which the topic is stale, however slow its
+	// pace is, and even before the warmup is over. The default is no
+	// maximum.
+	Max time.Duration
+}
+
+// Defaults of IdleConfig.
+const (
+	DefaultIdleFactor = 4
+	DefaultIdleAlpha  = 0.2
+	DefaultIdleWarmup = 5
+)
+
+// withDefaults returns c with the defaults for its zero fields.
+func (c IdleConfig) withDefaults() IdleConfig {
+	if c.Factor <= 0 {
+		c.Factor = DefaultIdleFactor
+	}
+	if c.Alpha <= 0 || c.Alpha > 1 {
+		c.Alpha = DefaultIdleAlpha
+	}
+	if c.Warmup <= 0 {
+		c.Warmup = DefaultIdleWarmup
+	}
+	return c
+}
+
+// ExpectAdaptive watches topic with a pace that the subscriber learns
+// from the messages of the topic, as configured by cfg. It replaces a pace
+// set with ExpectEvery and starts learning anew. ExpectEvery with a d of
+// zero stops watching the topic.
+func (s *Subscriber) ExpectAdaptive(topic string, cfg IdleConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.expectation(topic)
+	e.idle = &idleEstimator{cfg: cfg.withDefaults()}
+	e.arm()
+}
+
+// idleEstimator keeps the mean time between the messages of a topic.
+type idleEstimator struct {
+	cfg  IdleConfig
+	mean time.Duration
+	n    int       // number of times between messages measured
+	last time.Time // of the last message
+}
+
+// observe records a message that arrived at now.
+func (est *idleEstimator) observe(now time.Time) {
+	if !est.last.IsZero() {
+		gap := now.Sub(est.last)
+		if est.n == 0 {
+			est.mean = gap
+		} else {
+			est.mean += time.Duration(est.cfg.Alpha * float64(gap-est.mean))
+		}
+		est.n++
+	}
+	est.last = now
+}
+
+// limit returns how long the topic may be quiet after a message. It
+// returns false if there is no limit yet.
+func (est *idleEstimator) limit() (time.Duration, bool) {
+	if est.n < est.cfg.Warmup {
+		return est.cfg.Max, est.cfg.Max > 0
+	}
+	d := time.Duration(est.cfg.Factor * float64(est.mean))
+	if d < est.cfg.Min {
+		d = est.cfg.Min
+	}
+	if est.cfg.Max > 0 && d > est.cfg.Max {
+		d = est.cfg.Max
+	}
+	return d, true
+}
@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// arrivals returns n arrival times, every apart, with up to jitter added
+// to each gap, after start.
+func arrivals(start time.Time, n int, every, jitter time.Duration, rng *rand.Rand) []time.Time {
+	times := make([]time.Time, n)
+	at := start
+	for i := range times {
+		at = at.Add(every)
+		if jitter > 0 {
+			at = at.Add(time.Duration(rng.Int63n(int64(jitter))))
+		}
+		times[i] = at
+	}
+	return times
+}
+
+func TestIdleEstimator(t *testing.T) {
+	rng := rand.New(rand.NewSource(207))
+	start := time.Unix(0, 0)
+	steady := arrivals(start, 50, time.Second, 0, rng)
+	tests := []struct {
+		name    string
+		cfg     IdleConfig
+		times   []time.Time
+		want    time.Duration // limit after the last arrival
+		tol     time.Duration
+		noLimit bool
+	}{
+		{
+			name:  "steady",
+			times: steady,
+			want:  4 * time.Second,
+		},
+		{
+			name:  "1kHz with jitter",
+			times: arrivals(start, 1000, 900*time.Microsecond, 200*time.Microsecond, rng),
+			want:  4 * time.Millisecond,
+			tol:   400 * time.Microsecond,
+		},
+		{
+			name:  "hourly digest",
+			cfg:   IdleConfig{Factor: 1.5},
+			times: arrivals(start, 10, time.Hour, 0, rng),
+			want:  90 * time.Minute,
+		},
+		{
+			name:    "warming up",
+			times:   steady[:5], // four gaps
+			noLimit: true,
+		},
+		{
+			name:  "warming up with a maximum",
+			cfg:   IdleConfig{Max: time.Minute},
+			times: steady[:5],
+			want:  time.Minute,
+		},
+		{
+			name:  "warmup set",
+			cfg:   IdleConfig{Warmup: 1},
+			times: steady[:2],
+			want:  4 * time.Second,
+		},
+		{
+			name:  "minimum",
+			cfg:   IdleConfig{Min: 10 * time.Second},
+			times: steady,
+			want:  10 * time.Second,
+		},
+		{
+			name:  "maximum",
+			cfg:   IdleConfig{Max: 2 * time.Second},
+			times: steady,
+			want:  2 * time.Second,
+		},
+		{
+			// After the pace drops from 1s to 10s, the mean approaches
+			// 10s at a rate of Alpha per gap: 1 - 0.5^10 of the way.
+			name:  "slowing down",
+			cfg:   IdleConfig{Alpha: 0.5, Factor: 1},
+			times: append(steady[:10:10], arrivals(steady[9], 10, 10*time.Second, 0, rng)...),
+			want:  10*time.Second - 9*time.Second/1024,
+			tol:   time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			est := &idleEstimator{cfg: tt.cfg.withDefaults()}
+			for _, at := range tt.times {
+				est.observe(at)
+			}
+			got, ok := est.limit()
+			if tt.noLimit {
+				if ok {
+					t.Errorf("limit() = %v, want none", got)
+				}
+				return
+			}
+			if !ok || got < tt.want-tt.tol || got > tt.want+tt.tol {
+				t.Errorf("limit() = %v, %v; want %v±%v", got, ok, tt.want, tt.tol)
+			}
+		})
+	}
+}
+
+// A topic that goes quiet for much longer than usual is stale, fails the
+// health check if it is critical, and is fresh again with the next
+// message.
+func TestIdleAdaptive(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "ticks", "ping")
+	s.SetRecvDeadline(time.Hour)
+	waitFlow(t, p, s)
+	events := watchStale(s)
+	s.SetCritical("ticks", true)
+	s.ExpectAdaptive("ticks", IdleConfig{Factor: 10, Warmup: 3})
+
+	for i := 0; i < 6; i++ {
+		publishAll(t, p, "ticks")
+		receiveTopics(t, s, 1)
+		time.Sleep(20 * time.Millisecond)
+	}
+	quiet := time.Now()
+	if e := nextStale(t, events); e != (staleEvent{"ticks", true}) {
+		t.Fatalf("OnStale got %v, want ticks stale", e)
+	}
+	// The mean is about 20ms, so the topic is stale after about 200ms,
+	// not after the hour of the receive deadline.
+	if d := time.Since(quiet); d > time.Second {
+		t.Errorf("stale after %v", d)
+	}
+	if err := s.Health(); !errors.Is(err, ErrStale) {
+		t.Errorf("Health() = %v, want ErrStale", err)
+	}
+
+	publishAll(t, p, "ticks")
+	receiveTopics(t, s, 1)
+	if e := nextStale(t, events); e != (staleEvent{"ticks", false}) {
+		t.Fatalf("OnStale got %v, want ticks fresh", e)
+	}
+	if err := s.Health(); err != nil {
+		t.Errorf("Health() = %v after a message, want nil", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package topicindex finds the subscriptions that match a topic without
+// looking at each of them.
+//
+// Every registration has a literal prefix that a topic must start with,
+// and optionally a match function for whatever comes after the literal
+// part (a pattern, for example). Registrations live in a byte trie under
+// their prefix, so Match only visits the nodes along the topic's path.
+// Patterns without a literal prefix end up at the root, where they are
+// checked for every topic.
+package topicindex
+
+import "sync"
+
+// ID identifies a registration.
+type ID uint64
+
+// Index is a set of registrations. It is safe for concurrent use: Match
+// calls run in parallel, Insert and Remove wait for them.
+type Index struct {
+	mu     sync.RWMutex
+	root   node
+	where  map[ID]string // prefix of each registration
+	nextID ID
+}
+
+type node struct {
+	children map[byte]*node
+	entries  []entry
+}
+
+type entry struct {
+	id    ID
+	match func(topic string) bool
+	value interface{}
+}
+
+// New returns an empty index.
+func New() *Index {
+	return &Index{where: make(map[ID]string)}
+}
+
+// Insert registers value for all topics that start with prefix and, if
+// match is not nil, for which match returns true. match gets the complete
+// topic.
+func (x *Index) Insert(prefix string, match func(topic string) bool, value interface{}) ID {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.nextID++
+	id := x.nextID
+	n := &x.root
+	for i := 0; i < len(prefix); i++ {
+		c := n.children[prefix[i]]
+		if c == nil {
+			if n.children == nil {
+				n.children = make(map[byte]*node)
+			}
+			c = &node{}
+			n.children[prefix[i]] = c
+		}
+		n = c
+	}
+	n.entries = append(n.entries, entry{id: id, match: match, value: value})
+	x.where[id] = prefix
+	return id
+}
+
+// Remove deletes a registration. It reports whether the registration
+// existed.
+func (x *Index) Remove(id ID) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	prefix, ok := x.where[id]
+	if !ok {
+		return false
+	}
+	delete(x.where, id)
+	x.root.remove(prefix, id)
+	return true
+}
+
+// remove deletes the entry id below n and reports whether n is empty
+// afterwards, so that the caller can prune it.
+func (n *node) remove(prefix string, id ID) bool {
+	if prefix == "" {
+		for i, e := range n.entries {
+			if e.id == id {
+				n.entries = append(n.entries[:i], n.entries[i+1:]...)
+				break
+			}
+		}
+	} else if c := n.children[prefix[0]]; c != nil && c.remove(prefix[1:], id) {
+		delete(n.children, prefix[0])
+	}
+	return len(n.entries) == 0 && len(n.children) == 0
+}
+
+// Match returns the values of all registrations that match topic, in no
+// particular order.
+func (x *Index) Match(topic string) []interface{} {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var values []interface{}
+	n := &x.root
+	for i := 0; ; i++ {
+		for _, e := range n.entries {
+			if e.match == nil || e.match(topic) {
+				values = append(values, e.value)
+			}
+		}
+		if i == len(topic) {
+			break
+		}
+		if n = n.children[topic[i]]; n == nil {
+			break
+		}
+	}
+	return values
+}
+
+// Matches reports whether any registration matches topic. Unlike Match, it
+// stops at the first one and does not allocate.
+func (x *Index) Matches(topic string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n := &x.root
+	for i := 0; ; i++ {
+		for _, e := range n.entries {
+			if e.match == nil || e.match(topic) {
+				return true
+			}
+		}
+		if i == len(topic) {
+			return false
+		}
+		if n = n.children[topic[i]]; n == nil {
+			return false
+		}
+	}
+}
+
+// Len returns the number of registrations.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.where)
+}
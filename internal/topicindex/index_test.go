@@ -0,0 +1,142 @@
+package topicindex
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// matched returns the string values that x matches for topic, sorted.
+func matched(x *Index, topic string) []string {
+	var got []string
+	for _, v := range x.Match(topic) {
+		got = append(got, v.(string))
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestMatch(t *testing.T) {
+	x := New()
+	x.Insert("", nil, "all")
+	x.Insert("", func(topic string) bool { return strings.HasSuffix(topic, ".scores") }, "*.scores")
+	x.Insert("sports", nil, "sports")
+	x.Insert("sports.", func(topic string) bool { return !strings.Contains(topic[len("sports."):], ".") }, "sports.*")
+	x.Insert("sports.tennis", nil, "sports.tennis")
+	x.Insert("weather", func(string) bool { return false }, "never")
+
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"", []string{"all"}},
+		{"news", []string{"all"}},
+		{"sports", []string{"all", "sports"}},
+		{"sports.golf", []string{"all", "sports", "sports.*"}},
+		{"sports.tennis", []string{"all", "sports", "sports.*", "sports.tennis"}},
+		{"sports.tennis.scores", []string{"*.scores", "all", "sports", "sports.tennis"}},
+		{"sport", []string{"all"}},
+		{"weather", []string{"all"}},
+	}
+	for _, tt := range tests {
+		got := matched(x, tt.topic)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Match(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+		if ok := x.Matches(tt.topic); ok != (len(tt.want) > 0) {
+			t.Errorf("Matches(%q) = %v, want %v", tt.topic, ok, len(tt.want) > 0)
+		}
+	}
+}
+
+// Matches agrees with Match also where only a match function decides, and
+// for registrations at the root, which every topic passes.
+func TestMatchesRoot(t *testing.T) {
+	x := New()
+	if x.Matches("") || x.Matches("a") {
+		t.Error("empty index matches")
+	}
+	id := x.Insert("", func(topic string) bool { return topic == "" }, "empty")
+	if !x.Matches("") || x.Matches("a") {
+		t.Errorf("Matches with an empty prefix: %v for \"\", %v for \"a\"", x.Matches(""), x.Matches("a"))
+	}
+	x.Insert("a", func(topic string) bool { return topic == "ab" }, "ab")
+	for topic, want := range map[string]bool{"": true, "a": false, "ab": true, "abc": false} {
+		if got := x.Matches(topic); got != want || (len(x.Match(topic)) > 0) != want {
+			t.Errorf("Matches(%q) = %v, Match = %v; want %v", topic, got, x.Match(topic), want)
+		}
+	}
+	x.Remove(id)
+	if x.Matches("") {
+		t.Error("Matches(\"\") after removing the root registration")
+	}
+}
+
+// Remove deletes one registration, also of several with the same prefix,
+// and prunes the nodes that are left empty.
+func TestRemovePrunes(t *testing.T) {
+	x := New()
+	a1 := x.Insert("abc", nil, "abc1")
+	a2 := x.Insert("abc", nil, "abc2")
+	ab := x.Insert("ab", nil, "ab")
+	if x.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", x.Len())
+	}
+
+	if !x.Remove(a1) || x.Remove(a1) {
+		t.Error("Remove does not report the registration once")
+	}
+	if got := matched(x, "abcd"); !reflect.DeepEqual(got, []string{"ab", "abc2"}) {
+		t.Errorf("Match(abcd) = %v after removing abc1", got)
+	}
+	x.Remove(a2)
+	if c := x.root.children['a'].children['b'].children['c']; c != nil {
+		t.Errorf("node abc left with %+v", c)
+	}
+	x.Remove(ab)
+	if len(x.root.children) != 0 || len(x.where) != 0 || x.Len() != 0 {
+		t.Errorf("index not empty after removing all: %+v, %v", x.root, x.where)
+	}
+	if x.Remove(ID(99)) {
+		t.Error("Remove of an unknown ID reports true")
+	}
+}
+
+// Match runs in parallel with Insert and Remove; run with -race.
+func TestConcurrent(t *testing.T) {
+	x := New()
+	stable := x.Insert("a.b", nil, "stable")
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if !x.Matches("a.b.c") {
+					t.Error("lost the stable registration")
+					return
+				}
+				x.Match("a.b.c")
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		prefix := fmt.Sprintf("a.b.%d", i%10)
+		id := x.Insert(prefix[:len(prefix)-i%3], nil, i)
+		x.Remove(id)
+	}
+	close(stop)
+	wg.Wait()
+	if x.Len() != 1 || !x.Remove(stable) {
+		t.Errorf("Len() = %d after the churn, want 1", x.Len())
+	}
+}
@@ -0,0 +1,269 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Framing
+//
+// Every message starts with its topic, so that Mangos can filter by
+// subscription prefix. What follows the topic depends on the format:
+//
+//	binary   topic  0x00  version (1 byte)  topic length (uvarint)  header length (uvarint)  header  payload
+//	text     topic  "|"   payload
+//
+// The text format is the original one. It is easy to read in a packet dump,
+// but it relies on "|" being unique. The binary format ends the topic with a
+// NUL byte instead and is followed by a version byte, the length of the
+// topic, and a header. In both formats, the payload is taken as it is, so it
+// may contain "|", NUL bytes, newlines, or any other binary data.
+//
+// The header is a sequence of fields, each consisting of a key byte, the
+// length of the value as uvarint, and the value. Decode skips fields with
+// unknown keys, so new fields can be added without a new version. Fields
+// with an empty value are not written at all; a frame without any fields
+// has a header length of 0. Text frames cannot carry a header.
+//
+// The fields are:
+//
+//	1  codec     name of the codec that encoded the payload
+//	2  sequence  per-topic sequence number, as uvarint; 0 is not written
+//	3  encoding  compression of the payload, like "gzip"; none if absent
+//	4  nonce     nonce of an encrypted payload; none if it is in the clear
+//	5  mac       HMAC of the frame; none if the frame is not signed
+//	6  flags     one byte: 1 if the frame is a repeat, 2 if it is unsequenced
+//
+// The MAC covers the frame as Encode writes it without the mac and flags
+// fields, so a receiver that drops unknown fields cannot verify frames that
+// have any. The flags are left out because forwarders and last-value caches
+// set them, and those do not know the key.
+//
+// The topic and the header are never compressed or encrypted, so that
+// subscriptions keep working and the header can be read before the payload.
+//
+// A length prefix in front of the topic would have been the textbook way to
+// frame it, but then no message would start with the topic anymore, and
+// prefix subscriptions would stop working. The length therefore follows
+// the topic. Together with the NUL and the version byte, it marks a binary
+// frame: the first NUL ends the topic, a control character follows, and
+// the length must match the position of the NUL. A text frame whose payload
+// happens to contain all three in a row would be taken for a binary one;
+// where that can happen, DecodeText splits at "|" only.
+//
+// Topics must not contain NUL, or the NUL would end them early. In the
+// binary format, they may contain "|"; in the text format, the first "|"
+// ends the topic, so the text format rejects topics with "|". A message
+// without a binary marker and without "|" has no topic.
+
+// Format selects how Encode frames a message.
+type Format int
+
+const (
+	// Binary is the default format: topic, NUL, version, header, payload.
+	Binary Format = iota
+
+	// Text is the legacy format: topic, "|", payload.
+	Text
+)
+
+// FrameVersion is the version byte of binary frames. Versions are control
+// characters (1 to 31), so that a text payload with a NUL followed by a
+// printable character never looks like a binary frame.
+const FrameVersion = 1
+
+// maxFrameVersion is the highest byte that counts as a version.
+const maxFrameVersion = 0x1f
+
+// Header field keys.
+const (
+	keyCodec    = 1
+	keySeq      = 2
+	keyEncoding = 3
+	keyNonce    = 4
+	keyMAC      = 5
+	keyFlags    = 6
+)
+
+// Bits of the flags field.
+const (
+	flagRepeat      = 1 << 0
+	flagUnsequenced = 1 << 1
+)
+
+// ErrBadFrame is returned by Decode for a binary frame that is truncated or
+// has an unknown version, and by Encode for a frame it cannot represent.
+var ErrBadFrame = errors.New("malformed frame")
+
+// Frame is a message as it goes on the wire.
+type Frame struct {
+	Format  Format
+	Topic   string // in wire form (see Topic)
+	Header  Header
+	Payload []byte
+}
+
+// Header holds the optional fields of a binary frame.
+type Header struct {
+	Codec    string // name of the codec that encoded the payload
+	Seq      uint64 // sequence number within the topic, or 0 for none
+	Encoding string // compression of the payload, or "" for none
+	Nonce    string // nonce of an encrypted payload, or "" for none
+	MAC      string // HMAC of the frame, or "" for none
+
+	// The flags are set on the way rather than by the publisher, so
+	// neither the MAC nor the encryption covers them.
+	Repeat      bool // a last-value cache sent the frame again
+	Unsequenced bool // Seq does not follow the topic's earlier frames
+}
+
+// Encode frames a message.
+func Encode(f Frame) ([]byte, error) {
+	if strings.IndexByte(f.Topic, 0) >= 0 {
+		return nil, fmt.Errorf("%w: topic %q contains NUL", ErrBadFrame, f.Topic)
+	}
+	if f.Format == Text {
+		if strings.IndexByte(f.Topic, '|') >= 0 {
+			return nil, fmt.Errorf("%w: topic %q contains '|', which the text format cannot carry", ErrBadFrame, f.Topic)
+		}
+		if f.Header != (Header{}) {
+			return nil, fmt.Errorf("%w: text frames cannot carry a header", ErrBadFrame)
+		}
+		raw := make([]byte, 0, len(f.Topic)+1+len(f.Payload))
+		raw = append(raw, f.Topic...)
+		raw = append(raw, '|')
+		return append(raw, f.Payload...), nil
+	}
+
+	hdr := appendField(nil, keyCodec, f.Header.Codec)
+	if f.Header.Seq != 0 {
+		hdr = appendField(hdr, keySeq, string(appendUvarint(nil, f.Header.Seq)))
+	}
+	hdr = appendField(hdr, keyEncoding, f.Header.Encoding)
+	hdr = appendField(hdr, keyNonce, f.Header.Nonce)
+	hdr = appendField(hdr, keyMAC, f.Header.MAC)
+	var flags byte
+	if f.Header.Repeat {
+		flags |= flagRepeat
+	}
+	if f.Header.Unsequenced {
+		flags |= flagUnsequenced
+	}
+	if flags != 0 {
+		hdr = appendField(hdr, keyFlags, string(flags))
+	}
+	raw := make([]byte, 0, len(f.Topic)+2+2*binary.MaxVarintLen64+len(hdr)+len(f.Payload))
+	raw = append(raw, f.Topic...)
+	raw = append(raw, 0, FrameVersion)
+	raw = appendUvarint(raw, uint64(len(f.Topic)))
+	raw = appendUvarint(raw, uint64(len(hdr)))
+	raw = append(raw, hdr...)
+	return append(raw, f.Payload...), nil
+}
+
+// IsBinary tells whether raw starts like a binary frame: with a topic, a
+// NUL, a version byte, and the length of the topic. It checks neither
+// whether the version is known nor the rest of the frame.
+func IsBinary(raw []byte) bool {
+	_, ok := binaryTopic(raw)
+	return ok
+}
+
+// binaryTopic returns the length of the topic of a binary frame and
+// whether raw starts like one.
+func binaryTopic(raw []byte) (int, bool) {
+	i := bytes.IndexByte(raw, 0)
+	if i < 0 || len(raw) < i+2 || raw[i+1] == 0 || raw[i+1] > maxFrameVersion {
+		return 0, false
+	}
+	n, size := binary.Uvarint(raw[i+2:])
+	return i, size > 0 && n == uint64(i)
+}
+
+// Decode splits a frame of either format into its parts. A message that
+// does not start like a binary frame counts as Text.
+func Decode(raw []byte) (Frame, error) {
+	i, ok := binaryTopic(raw)
+	if !ok {
+		return DecodeText(raw), nil
+	}
+	if raw[i+1] != FrameVersion {
+		return Frame{}, ErrBadFrame
+	}
+	f := Frame{Format: Binary, Topic: string(raw[:i])}
+	_, size := binary.Uvarint(raw[i+2:])
+	hdr, rest, ok := cutField(raw[i+2+size:])
+	if !ok {
+		return Frame{}, ErrBadFrame
+	}
+	for len(hdr) > 0 {
+		key := hdr[0]
+		var value []byte
+		value, hdr, ok = cutField(hdr[1:])
+		if !ok {
+			return Frame{}, ErrBadFrame
+		}
+		switch key {
+		case keyCodec:
+			f.Header.Codec = string(value)
+		case keySeq:
+			seq, size := binary.Uvarint(value)
+			if size != len(value) {
+				return Frame{}, ErrBadFrame
+			}
+			f.Header.Seq = seq
+		case keyEncoding:
+			f.Header.Encoding = string(value)
+		case keyNonce:
+			f.Header.Nonce = string(value)
+		case keyMAC:
+			f.Header.MAC = string(value)
+		case keyFlags:
+			if len(value) == 0 {
+				return Frame{}, ErrBadFrame
+			}
+			// Unknown bits are for later versions to define.
+			f.Header.Repeat = value[0]&flagRepeat != 0
+			f.Header.Unsequenced = value[0]&flagUnsequenced != 0
+		}
+	}
+	f.Payload = rest
+	return f, nil
+}
+
+// DecodeText splits a text frame into topic and payload.
+func DecodeText(raw []byte) Frame {
+	i := bytes.IndexByte(raw, '|')
+	if i < 0 {
+		return Frame{Format: Text, Payload: raw}
+	}
+	return Frame{Format: Text, Topic: string(raw[:i]), Payload: raw[i+1:]}
+}
+
+// appendField appends a header field, unless value is empty.
+func appendField(b []byte, key byte, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = append(b, key)
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// cutField splits a uvarint-prefixed value off b.
+func cutField(b []byte) (value, rest []byte, ok bool) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(len(b)-size) {
+		return nil, nil, false
+	}
+	b = b[size:]
+	return b[:n], b[n:], true
+}
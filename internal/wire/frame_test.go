@@ -0,0 +1,142 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var payloads = [][]byte{
+	[]byte(""),
+	[]byte("plain"),
+	[]byte("with|pipe|s"),
+	[]byte("line\nbreaks\r\n"),
+	[]byte("nul\x00bytes\x00"),
+	[]byte("\x00\x01\x05"), // looks like a binary marker
+	{0xff, 0xfe, 0x00, '|', '\n'},
+}
+
+func TestEncodeDecodeBinary(t *testing.T) {
+	headers := []Header{
+		{},
+		{Codec: "json", Seq: 42},
+		{Codec: "msgpack", Seq: 1 << 40, Encoding: "gzip", Nonce: "\x00|nonce\n", MAC: "mac\x00|"},
+		{Seq: 3, Repeat: true},
+		{Seq: 3, Unsequenced: true},
+		{Seq: 3, MAC: "mac", Repeat: true, Unsequenced: true},
+	}
+	for _, topic := range []string{"", "Weather", "a|b", "sensors/eu.temp|raw"} {
+		for _, h := range headers {
+			for _, p := range payloads {
+				in := Frame{Format: Binary, Topic: topic, Header: h, Payload: p}
+				raw, err := Encode(in)
+				if err != nil {
+					t.Fatalf("Encode(%+v): %v", in, err)
+				}
+				if !bytes.HasPrefix(raw, []byte(topic+"\x00")) {
+					t.Errorf("frame %q does not start with the topic %q", raw, topic)
+				}
+				out, err := Decode(raw)
+				if err != nil {
+					t.Fatalf("Decode(%q): %v", raw, err)
+				}
+				if out.Format != Binary || out.Topic != topic || out.Header != h || !bytes.Equal(out.Payload, p) {
+					t.Errorf("Decode(Encode(%+v)) = %+v", in, out)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeDecodeText(t *testing.T) {
+	for _, topic := range []string{"", "Weather", "sensors/eu.temp"} {
+		for _, p := range payloads {
+			raw, err := Encode(Frame{Format: Text, Topic: topic, Payload: p})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := topic + "|" + string(p); string(raw) != want {
+				t.Errorf("text frame %q, want %q", raw, want)
+			}
+			out := DecodeText(raw)
+			if out.Topic != topic || !bytes.Equal(out.Payload, p) {
+				t.Errorf("DecodeText(%q) = %q %q", raw, out.Topic, out.Payload)
+			}
+			// Detection takes text frames for text frames, unless the
+			// payload fakes a binary marker right after a NUL.
+			if bytes.Contains(p, []byte{0, FrameVersion}) {
+				continue
+			}
+			if out, err := Decode(raw); err != nil || out.Format != Text || out.Topic != topic || !bytes.Equal(out.Payload, p) {
+				t.Errorf("Decode(%q) = %+v, %v", raw, out, err)
+			}
+		}
+	}
+}
+
+func TestEncodeRejects(t *testing.T) {
+	tests := []Frame{
+		{Format: Binary, Topic: "nul\x00topic"},
+		{Format: Text, Topic: "nul\x00topic"},
+		{Format: Text, Topic: "a|b"},
+		{Format: Text, Topic: "a", Header: Header{Seq: 1}},
+	}
+	for _, f := range tests {
+		if _, err := Encode(f); !errors.Is(err, ErrBadFrame) {
+			t.Errorf("Encode(%+v) = %v, want ErrBadFrame", f, err)
+		}
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	tests := []struct {
+		raw            string
+		topic, payload string
+	}{
+		{"Weather|Sunny", "Weather", "Sunny"},
+		{"Weather|a|b", "Weather", "a|b"},
+		{"no delimiter", "", "no delimiter"},
+		// Old publishers did not check for NUL in topics.
+		{"nul\x00topic|x", "nul\x00topic", "x"},
+		{"t|x\x00y", "t", "x\x00y"},
+	}
+	for _, tt := range tests {
+		f, err := Decode([]byte(tt.raw))
+		if err != nil || f.Format != Text || f.Topic != tt.topic || string(f.Payload) != tt.payload {
+			t.Errorf("Decode(%q) = %q %q (format %d), %v; want %q %q", tt.raw, f.Topic, f.Payload, f.Format, err, tt.topic, tt.payload)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	good, err := Encode(Frame{Topic: "topic", Header: Header{Codec: "json", Seq: 7}, Payload: []byte("payload")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownVersion := append([]byte(nil), good...)
+	unknownVersion[len("topic")+1] = FrameVersion + 1
+	tests := map[string][]byte{
+		"unknown version":      unknownVersion,
+		"no header length":     good[:len("topic")+3],
+		"truncated header":     good[:len("topic")+6],
+		"header beyond end":    append(append([]byte("topic\x00\x01\x05"), 0x20), "short"...),
+		"field beyond its end": append([]byte("topic\x00\x01\x05\x03"), keyCodec, 9, 'x'),
+	}
+	for name, raw := range tests {
+		if f, err := Decode(raw); !errors.Is(err, ErrBadFrame) {
+			t.Errorf("%s: Decode(%q) = %+v, %v; want ErrBadFrame", name, raw, f, err)
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	raw, _ := Encode(Frame{Topic: "a|b", Payload: []byte("x")})
+	if !IsBinary(raw) {
+		t.Errorf("IsBinary(%q) = false", raw)
+	}
+	for _, s := range []string{"a|b\x00", "a|b", "", "a\x00\x01\x02"} {
+		if IsBinary([]byte(s)) {
+			t.Errorf("IsBinary(%q) = true", s)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Replay
+//
+// A subscriber that missed messages asks the publisher's replay socket
+// for them. Both the request and the reply are plain byte strings:
+//
+//	request  topic  0x00  from (uvarint)  to (uvarint)
+//	reply    evicted before (uvarint)  { frame length (uvarint)  frame }
+//
+// The topic is in wire form, and from and to are sequence numbers, both
+// inclusive. The reply holds the archived frames of the range, as they
+// were sent. If the publisher no longer has the start of the range,
+// "evicted before" is the oldest sequence number it still has; otherwise
+// it is 0.
+
+// ReplayRequest asks for the frames from From to To of Topic.
+type ReplayRequest struct {
+	Topic    string
+	From, To uint64
+}
+
+// ReplayReply answers a ReplayRequest.
+type ReplayReply struct {
+	EvictedBefore uint64
+	Frames        [][]byte
+}
+
+// EncodeReplayRequest encodes r.
+func EncodeReplayRequest(r ReplayRequest) []byte {
+	b := append([]byte(r.Topic), 0)
+	b = appendUvarint(b, r.From)
+	return appendUvarint(b, r.To)
+}
+
+// DecodeReplayRequest decodes a request. It returns ErrBadFrame if raw is
+// not a valid request.
+func DecodeReplayRequest(raw []byte) (ReplayRequest, error) {
+	i := bytes.IndexByte(raw, 0)
+	if i < 0 {
+		return ReplayRequest{}, ErrBadFrame
+	}
+	r := ReplayRequest{Topic: string(raw[:i])}
+	rest := raw[i+1:]
+	var size int
+	r.From, size = binary.Uvarint(rest)
+	if size <= 0 {
+		return ReplayRequest{}, ErrBadFrame
+	}
+	r.To, size = binary.Uvarint(rest[size:])
+	if size <= 0 {
+		return ReplayRequest{}, ErrBadFrame
+	}
+	return r, nil
+}
+
+// EncodeReplayReply encodes r.
+func EncodeReplayReply(r ReplayReply) []byte {
+	b := appendUvarint(nil, r.EvictedBefore)
+	for _, f := range r.Frames {
+		b = appendUvarint(b, uint64(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+// DecodeReplayReply decodes a reply. The frames share memory with raw. It
+// returns ErrBadFrame if raw is not a valid reply.
+func DecodeReplayReply(raw []byte) (ReplayReply, error) {
+	var r ReplayReply
+	var size int
+	r.EvictedBefore, size = binary.Uvarint(raw)
+	if size <= 0 {
+		return ReplayReply{}, ErrBadFrame
+	}
+	rest := raw[size:]
+	for len(rest) > 0 {
+		var f []byte
+		var ok bool
+		f, rest, ok = cutField(rest)
+		if !ok {
+			return ReplayReply{}, ErrBadFrame
+		}
+		r.Frames = append(r.Frames, f)
+	}
+	return r, nil
+}
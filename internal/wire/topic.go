@@ -0,0 +1,96 @@
+// Package wire holds the encoding details of what goes on the wire.
+package wire
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode topics
+//
+// Mangos matches subscriptions by comparing raw bytes. For non-ASCII topics,
+// this is a trap: "é" can be written as a single code point (U+00E9) or as
+// "e" followed by a combining accent (U+0065 U+0301). Both look the same but
+// differ in their bytes, so a subscriber would never see the messages of a
+// publisher that happens to use the other form.
+//
+// Therefore, the pubsub package normalizes every topic to Unicode
+// Normalization Form C (NFC) before it touches the wire. The NFC form is
+// the only form that ever goes on the wire.
+//
+// Some environments (log scrapers, packet filters, legacy consumers) need
+// topics that are visible as plain ASCII bytes. If asked to (see
+// Options.ASCIITopics of the pubsub package), Topic additionally
+// percent-encodes topics after normalization: every byte outside printable
+// ASCII, as well as "%" and "|", becomes "%XX". As the encoding works byte
+// by byte, the encoded form of a prefix is still a prefix of the encoded
+// topic, so subscriptions keep working. All publishers and subscribers of a
+// topic must agree on this setting; it is per Publisher and Subscriber, so
+// that the two can differ within one process.
+//
+// Migration note: publishers built before topics were normalized send
+// whatever form their input used. If such a publisher used decomposed
+// (NFD) topics, normalized subscribers will not match its messages. Upgrade
+// the publishers first--old subscribers that used the same decomposed form
+// will then need an upgrade, too--or keep non-normalized topics ASCII-only
+// until all peers are upgraded. ASCII topics are unaffected, as they are
+// always in NFC.
+
+// Topic turns a topic into the form that goes on the wire. With ascii, the
+// topic is percent-encoded, too.
+func Topic(topic string, ascii bool) string {
+	topic = norm.NFC.String(topic)
+	if ascii {
+		topic = encodeTopic(topic)
+	}
+	return topic
+}
+
+// encodeTopic percent-encodes all bytes of topic that are not printable
+// ASCII, as well as the escape character "%" and the delimiter "|".
+func encodeTopic(topic string) string {
+	var b strings.Builder
+	for i := 0; i < len(topic); i++ {
+		c := topic[i]
+		if c < 0x21 || c > 0x7e || c == '%' || c == '|' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// DecodeTopic reverses the percent-encoding of ASCII-safe topics. Malformed escapes are kept as they are.
+func DecodeTopic(topic string) string {
+	if !strings.Contains(topic, "%") {
+		return topic
+	}
+	var b strings.Builder
+	for i := 0; i < len(topic); i++ {
+		if topic[i] == '%' && i+2 < len(topic) && isHex(topic[i+1]) && isHex(topic[i+2]) {
+			b.WriteByte(unhex(topic[i+1])<<4 | unhex(topic[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(topic[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'F' || 'a' <= c && c <= 'f'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
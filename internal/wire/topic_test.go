@@ -0,0 +1,70 @@
+package wire
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	composed   = "caf\u00e9"  // "é" as one code point
+	decomposed = "cafe\u0301" // "e" and a combining accent
+)
+
+func TestTopicNormalizes(t *testing.T) {
+	for _, ascii := range []bool{false, true} {
+		if Topic(composed, ascii) != Topic(decomposed, ascii) {
+			t.Errorf("ascii=%v: %q and %q differ on the wire: %q, %q", ascii, composed, decomposed, Topic(composed, ascii), Topic(decomposed, ascii))
+		}
+	}
+	if got := Topic(decomposed, false); got != composed {
+		t.Errorf("Topic(%q) = %q, want %q", decomposed, got, composed)
+	}
+}
+
+func TestTopicASCII(t *testing.T) {
+	tests := []struct {
+		topic, wire string
+	}{
+		{"Weather", "Weather"},
+		{composed, "caf%C3%A9"},
+		{decomposed, "caf%C3%A9"},
+		{"50% off", "50%25%20off"},
+		{"a|b", "a%7Cb"},
+		{"tab\there", "tab%09here"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := Topic(tt.topic, true)
+		if got != tt.wire {
+			t.Errorf("Topic(%q, true) = %q, want %q", tt.topic, got, tt.wire)
+		}
+		for i := 0; i < len(got); i++ {
+			if got[i] < 0x21 || got[i] > 0x7e {
+				t.Errorf("Topic(%q, true) = %q has byte %#x", tt.topic, got, got[i])
+			}
+		}
+		want := Topic(tt.topic, false)
+		if back := DecodeTopic(got); back != want {
+			t.Errorf("DecodeTopic(%q) = %q, want %q", got, back, want)
+		}
+	}
+}
+
+func TestTopicASCIIKeepsPrefixes(t *testing.T) {
+	topic := "produits.café.crème"
+	for i := 0; i <= len(topic); i++ {
+		prefix := topic[:i]
+		if !strings.HasPrefix(Topic(topic, true), encodeTopic(prefix)) {
+			t.Errorf("encoded %q is not a prefix of encoded %q", prefix, topic)
+		}
+	}
+}
+
+func TestDecodeTopicKeepsMalformedEscapes(t *testing.T) {
+	for _, s := range []string{"100%", "%4", "%zz", "a%%41"} {
+		want := strings.Replace(s, "%41", "A", 1)
+		if got := DecodeTopic(s); got != want {
+			t.Errorf("DecodeTopic(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package pubsub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appliedgo/pubsub/storage"
+)
+
+// For auditing and disaster recovery, a publisher can record every
+// message in a journal (see Options.Journal) before it sends it. The
+// journal is a directory of segments, which are namespaces of a file
+// storage (see the storage package): "journal-000001.log",
+// "journal-000002.log", and so on. Each publisher starts a new segment,
+// and so does a segment that outgrows Options.JournalSegmentSize.
+//
+// Each record holds the time of publishing, the sequence number, the topic,
+// the codec, and the payload, as the publisher got them after the
+// interceptors; compression, encryption, and signing are left to the
+// replaying publisher. A record is encoded as
+//
+//	time      8 bytes, nanoseconds since 1970, big endian
+//	sequence  uvarint
+//	topic     uvarint length, then the topic
+//	codec     uvarint length, then the codec name
+//	payload   the rest
+//
+// The records are synced to disk every journalSyncInterval and when the
+// publisher closes, so a crash loses at most the last second. A record
+// that a crash left half written stays in the segment, as each publisher
+// starts a new one; ReadJournal skips it.
+
+// DefaultJournalSegmentSize is the size at which a journal segment ends
+// if Options.JournalSegmentSize is zero.
+const DefaultJournalSegmentSize = 64 << 20
+
+// journalSyncInterval is how often the journal is synced to disk.
+const journalSyncInterval = time.Second
+
+// journalPrefix starts the namespace of each segment.
+const journalPrefix = "journal-"
+
+// JournalEntry is a message as the journal recorded it.
+type JournalEntry struct {
+	Time    time.Time // when it was published
+	Seq     uint64    // its sequence number, or 0 with the text framing
+	Topic   string
+	Codec   string
+	Payload []byte
+}
+
+// journal appends the published messages to a file storage.
+type journal struct {
+	store   storage.Storage
+	maxSize int64
+	done    chan struct{}
+	stopped chan struct{}
+	closing sync.Once
+
+	mu      sync.Mutex
+	segment int   // number of the current segment
+	size    int64 // bytes written to it
+	dirty   bool  // whether it has unsynced records
+}
+
+// openJournal opens the journal in dir and starts a new segment there.
+func openJournal(dir string, opts Options) (*journal, error) {
+	store, err := storage.NewFile(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open journal: %w", err)
+	}
+	segments, err := journalSegments(store)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("cannot open journal: %w", err)
+	}
+	j := &journal{
+		store:   store,
+		maxSize: opts.JournalSegmentSize,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if j.maxSize <= 0 {
+		j.maxSize = DefaultJournalSegmentSize
+	}
+	if len(segments) > 0 {
+		j.segment = segments[len(segments)-1]
+	}
+	j.segment++
+	go j.syncLoop()
+	return j, nil
+}
+
+// journalSegments returns the numbers of the segments in store, in order.
+func journalSegments(store storage.Storage) ([]int, error) {
+	names, err := store.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, name := range names {
+		var n int
+		if _, err := fmt.Sscanf(name, journalPrefix+"%d", &n); err == nil && name == segmentName(n) {
+			segments = append(segments, n)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func segmentName(n int) string {
+	return fmt.Sprintf("%s%06d", journalPrefix, n)
+}
+
+// add appends msg, which was published at t.
+func (j *journal) add(t time.Time, msg Message) error {
+	rec := encodeJournalEntry(JournalEntry{Time: t, Seq: msg.Seq, Topic: msg.Topic, Codec: msg.Codec, Payload: msg.Payload})
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.size > 0 && j.size+int64(len(rec)) > j.maxSize {
+		// The full segment is synced right away, as the sync loop
+		// only looks after the current one.
+		if err := j.store.Sync(segmentName(j.segment)); err != nil {
+			return fmt.Errorf("cannot sync journal: %w", err)
+		}
+		j.segment++
+		j.size = 0
+	}
+	if _, err := j.store.Append(segmentName(j.segment), rec); err != nil {
+		return fmt.Errorf("cannot write journal: %w", err)
+	}
+	j.size += int64(len(rec))
+	j.dirty = true
+	return nil
+}
+
+// syncLoop syncs the current segment every journalSyncInterval.
+func (j *journal) syncLoop() {
+	defer close(j.stopped)
+	tick := time.NewTicker(journalSyncInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-tick.C:
+		}
+		j.mu.Lock()
+		if j.dirty {
+			if err := j.store.Sync(segmentName(j.segment)); err != nil {
+				logger().Error("Cannot sync journal", "error", err)
+			} else {
+				j.dirty = false
+			}
+		}
+		j.mu.Unlock()
+	}
+}
+
+// close syncs and closes the journal. Closing it again returns the
+// error of the storage.
+func (j *journal) close() error {
+	j.closing.Do(func() { close(j.done) })
+	<-j.stopped
+	return j.store.Close()
+}
+
+func encodeJournalEntry(e JournalEntry) []byte {
+	b := make([]byte, 8, 8+3*binary.MaxVarintLen64+len(e.Topic)+len(e.Codec)+len(e.Payload))
+	binary.BigEndian.PutUint64(b, uint64(e.Time.UnixNano()))
+	b = appendUvarint(b, e.Seq)
+	b = appendUvarint(b, uint64(len(e.Topic)))
+	b = append(b, e.Topic...)
+	b = appendUvarint(b, uint64(len(e.Codec)))
+	b = append(b, e.Codec...)
+	return append(b, e.Payload...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// errBadJournalEntry is returned by decodeJournalEntry for a record that
+// is not a journal entry.
+var errBadJournalEntry = errors.New("malformed journal entry")
+
+func decodeJournalEntry(rec []byte) (JournalEntry, error) {
+	if len(rec) < 8 {
+		return JournalEntry{}, errBadJournalEntry
+	}
+	e := JournalEntry{Time: time.Unix(0, int64(binary.BigEndian.Uint64(rec)))}
+	rest := rec[8:]
+	var size int
+	e.Seq, size = binary.Uvarint(rest)
+	if size <= 0 {
+		return JournalEntry{}, errBadJournalEntry
+	}
+	rest = rest[size:]
+	for _, s := range []*string{&e.Topic, &e.Codec} {
+		n, size := binary.Uvarint(rest)
+		if size <= 0 || n > uint64(len(rest)-size) {
+			return JournalEntry{}, errBadJournalEntry
+		}
+		*s = string(rest[size : size+int(n)])
+		rest = rest[size+int(n):]
+	}
+	e.Payload = append([]byte(nil), rest...)
+	return e, nil
+}
+
+// ReadJournal calls fn for each entry of the journal at path, oldest
+// first. path is a journal directory (see Options.Journal), or one
+// segment file in it. If fn returns an error, ReadJournal stops and
+// returns it.
+//
+// ReadJournal does not change the files, so it is safe to use on the
+// journal of a running publisher. A record at the end of a segment that a
+// crash left half written, or that the publisher is still writing, is
+// skipped with a warning, and the entries before it are read as usual.
+func ReadJournal(path string, fn func(JournalEntry) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	dir := path
+	var segments []string
+	if info.IsDir() {
+		store, err := storage.NewFile(dir)
+		if err != nil {
+			return err
+		}
+		numbers, err := journalSegments(store)
+		store.Close()
+		if err != nil {
+			return err
+		}
+		for _, n := range numbers {
+			segments = append(segments, segmentName(n))
+		}
+	} else {
+		dir = filepath.Dir(path)
+		name := filepath.Base(path)
+		if !strings.HasSuffix(name, ".log") {
+			return fmt.Errorf("%s is not a journal segment", path)
+		}
+		segments = []string{strings.TrimSuffix(name, ".log")}
+	}
+	for _, ns := range segments {
+		if err := readSegment(dir, ns, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment calls fn for each entry of the segment ns in dir.
+func readSegment(dir, ns string, fn func(JournalEntry) error) error {
+	file := filepath.Join(dir, ns+".log")
+	torn, err := storage.ReadFile(file, func(_ uint64, rec []byte) error {
+		e, err := decodeJournalEntry(rec)
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %w", file, err)
+		}
+		return fn(e)
+	})
+	if err != nil {
+		return err
+	}
+	if torn > 0 {
+		logger().Warn("Skipped an incomplete record at the end of the journal", "file", file, "bytes", torn)
+	}
+	return nil
+}
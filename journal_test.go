@@ -0,0 +1,177 @@
+package pubsub
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// readJournal returns all entries of the journal at path.
+func readJournal(t *testing.T, path string) []JournalEntry {
+	t.Helper()
+	var entries []JournalEntry
+	err := ReadJournal(path, func(e JournalEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadJournal(%s): %v", path, err)
+	}
+	return entries
+}
+
+// received is what a test compares of a received message.
+type received struct {
+	Topic, Payload, Codec string
+	Seq                   uint64
+}
+
+func receivedOf(msgs []Message) []received {
+	var r []received
+	for _, msg := range msgs {
+		r = append(r, received{msg.Topic, string(msg.Payload), msg.Codec, msg.Seq})
+	}
+	return r
+}
+
+// A journal replayed by a second publisher gives its subscribers the same
+// stream that the subscribers of the first one got.
+func TestJournalRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{Journal: dir})
+	p.SetCompression(16)
+	s := newTestSubscriber(t, url, Options{}, "")
+	msgs := []Message{
+		{Topic: "weather", Payload: []byte("sunny")},
+		{Topic: "stocks", Payload: []byte(`{"ACME":42}`), Codec: "json"},
+		{Topic: "weather", Payload: []byte("rain|\x00\nhail, a long forecast that gets compressed")},
+		{Topic: "", Payload: []byte("no topic")},
+	}
+	for _, msg := range msgs {
+		if err := p.PublishMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original := receivedOf(receiveN(t, s, len(msgs)))
+	p.Close()
+
+	url = testURL(t) + "-replay"
+	replay := newTestPublisher(t, url, Options{})
+	s = newTestSubscriber(t, url, Options{}, "")
+	for _, e := range readJournal(t, dir) {
+		if err := replay.PublishMessage(Message{Topic: e.Topic, Payload: e.Payload, Codec: e.Codec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if replayed := receivedOf(receiveN(t, s, len(msgs))); !reflect.DeepEqual(replayed, original) {
+		t.Errorf("replayed %v,\noriginally received %v", replayed, original)
+	}
+}
+
+func TestJournalSegments(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestPublisher(t, testURL(t), Options{Journal: dir, JournalSegmentSize: 100})
+	for i := 1; i <= 20; i++ {
+		if err := p.Publish("counter", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+	// A second run starts a segment of its own.
+	p = newTestPublisher(t, testURL(t)+"-again", Options{Journal: dir, JournalSegmentSize: 100})
+	if err := p.Publish("counter", "21"); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	segments, err := filepath.Glob(filepath.Join(dir, journalPrefix+"*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 {
+		t.Fatalf("%d segments, want several", len(segments))
+	}
+	entries := readJournal(t, dir)
+	if len(entries) != 21 {
+		t.Fatalf("%d entries, want 21", len(entries))
+	}
+	for i, e := range entries {
+		if string(e.Payload) != strconv.Itoa(i+1) {
+			t.Errorf("entry %d is %q", i+1, e.Payload)
+		}
+		if i > 0 && e.Time.Before(entries[i-1].Time) {
+			t.Errorf("entry %d is older than the one before", i+1)
+		}
+	}
+	last := readJournal(t, segments[len(segments)-1])
+	if len(last) != 1 || string(last[0].Payload) != "21" || last[0].Seq != 1 {
+		t.Errorf("last segment holds %v, want the message of the second run", last)
+	}
+}
+
+// A half-written record at the end of a segment is skipped, and the file
+// stays as it is, as a publisher may still be writing to it.
+func TestJournalTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestPublisher(t, testURL(t), Options{Journal: dir})
+	for _, payload := range []string{"one", "two", "three"} {
+		if err := p.Publish("t", payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+
+	segment := filepath.Join(dir, segmentName(1)+".log")
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := info.Size() - 3
+	if err := os.Truncate(segment, torn); err != nil {
+		t.Fatal(err)
+	}
+	entries := readJournal(t, dir)
+	if len(entries) != 2 || string(entries[1].Payload) != "two" {
+		t.Errorf("read %v, want the first two entries", entries)
+	}
+	if info, err := os.Stat(segment); err != nil || info.Size() != torn {
+		t.Errorf("ReadJournal changed the segment: %v, %v", info.Size(), err)
+	}
+}
+
+// ReadJournal reads the journal of a running publisher without cutting
+// off what the publisher has not finished writing.
+func TestJournalReadWhileRunning(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestPublisher(t, testURL(t), Options{Journal: dir})
+	if err := p.Publish("t", "one"); err != nil {
+		t.Fatal(err)
+	}
+	segment := filepath.Join(dir, segmentName(1)+".log")
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The beginning of a record's frame, as if the publisher were in the
+	// middle of writing it.
+	_, err = f.Write([]byte{0, 0, 0, 9})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{dir, segment} {
+		if entries := readJournal(t, path); len(entries) != 1 {
+			t.Errorf("read %d entries from %s, want 1", len(entries), path)
+		}
+	}
+	if after, _ := os.Stat(segment); after.Size() != before.Size() {
+		t.Errorf("ReadJournal cut the segment from %d to %d bytes", before.Size(), after.Size())
+	}
+}
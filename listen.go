@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	neturl "net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-mangos/mangos"
+)
+
+// A publisher that restarts quickly may find its address still taken by
+// the process it replaces. Instead of failing right away, it tries again a
+// few times. For ipc, the address is a file, which stays behind if the
+// previous process crashed. Such a stale file is removed, as long as no
+// process listens on it anymore.
+
+// listen makes socket listen on url, retrying as long as the address is in
+// use (see Options.ListenAttempts). It returns the address that the
+// listener reports, which has the actual port if url has port 0.
+func listen(socket mangos.Socket, url string, topts map[string]interface{}, opts Options) (string, error) {
+	attempts, backoff := opts.ListenAttempts, opts.ListenBackoff
+	if attempts <= 0 {
+		attempts = DefaultListenAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultListenBackoff
+	}
+	if strings.HasPrefix(url, "ipc://") {
+		removeStaleSocket(strings.TrimPrefix(url, "ipc://"))
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var addr string
+		addr, err = pickPort(url)
+		if err != nil {
+			return "", err
+		}
+		var l mangos.Listener
+		l, err = socket.NewListener(addr, topts)
+		if err != nil {
+			return "", err
+		}
+		err = l.Listen()
+		if err == nil {
+			return l.Address(), nil
+		}
+		if !addrInUse(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("gave up after %d attempts: %w", attempts, err)
+}
+
+// pickPort replaces port 0 in addr with a port that is free at the moment.
+// Mangos reports the port that the system picked for port 0 only for tcp,
+// so for tls+tcp, ws, and wss, listen picks one itself. If another process
+// takes the port in the meantime, the retry loop picks another one.
+func pickPort(addr string) (string, error) {
+	if scheme(addr) == "tcp" {
+		return addr, nil
+	}
+	u, err := neturl.Parse(addr)
+	if err != nil || u.Port() != "0" {
+		return addr, nil
+	}
+	l, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	u.Host = l.Addr().String()
+	return u.String(), nil
+}
+
+// addrInUse tells whether err means that another socket holds the address.
+func addrInUse(err error) bool {
+	return err == mangos.ErrAddrInUse || errors.Is(err, syscall.EADDRINUSE)
+}
+
+// removeStaleSocket removes the Unix socket file at path if nobody accepts
+// connections on it.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		// Someone is listening; the retry loop waits for them to go.
+		conn.Close()
+		return
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+
+package pubsub
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A socket file that a crashed process left behind is removed, and the
+// publisher listens in its place.
+func TestListenStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("no stale socket file: %v", err)
+	}
+
+	url := "ipc://" + path
+	p := newTestPublisher(t, url, Options{ListenAttempts: 1})
+	s := newTestSubscriber(t, url, Options{}, "ping")
+	waitFlow(t, p, s)
+}
+
+// A socket file that a process listens on, and a file that is no socket,
+// are left alone.
+func TestListenKeepsLiveSocket(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.ipc")
+	l, err := net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	plain := filepath.Join(dir, "plain")
+	if err := os.WriteFile(plain, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{live, plain} {
+		p, err := NewPublisherWithOptions("ipc://"+path, Options{ListenAttempts: 2, ListenBackoff: time.Millisecond})
+		if err == nil {
+			p.Close()
+			t.Errorf("publisher listens on %s", path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s removed: %v", path, err)
+		}
+	}
+	if conn, err := net.Dial("unix", live); err != nil {
+		t.Errorf("live socket does not accept connections anymore: %v", err)
+	} else {
+		conn.Close()
+	}
+}
+
+// The publisher tries again while the address is in use, and succeeds once
+// it is free.
+func TestListenRetry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "tcp://" + l.Addr().String()
+	time.AfterFunc(100*time.Millisecond, func() { l.Close() })
+	p := newTestPublisher(t, url, Options{ListenAttempts: 10, ListenBackoff: 10 * time.Millisecond})
+	if p.Addr() != url {
+		t.Errorf("Addr() = %s, want %s", p.Addr(), url)
+	}
+
+	_, err = NewPublisherWithOptions(url, Options{ListenAttempts: 2, ListenBackoff: time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "gave up after 2 attempts") {
+		t.Errorf("publisher on a taken address: %v, want it to give up after 2 attempts", err)
+	}
+}
@@ -0,0 +1,41 @@
+package pubsub
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// Some things cannot be returned as errors: a lost connection, a panicking
+// handler, a replay that failed in the background. The package logs them,
+// along with debug records for each message published or received,
+// through a Logger. By default, that is slog.Default(), so the records go
+// wherever the application sends its slog output.
+
+// Logger receives the log records of the package. The arguments are
+// alternating keys and values, as with slog; *slog.Logger implements
+// Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// loggerBox lets atomic.Value hold any Logger.
+type loggerBox struct{ Logger }
+
+var currentLogger atomic.Value // of loggerBox
+
+// SetLogger sets the logger of the package. nil restores the default,
+// slog.Default().
+func SetLogger(l Logger) {
+	currentLogger.Store(loggerBox{l})
+}
+
+// logger returns the logger of the package.
+func logger() Logger {
+	if box, ok := currentLogger.Load().(loggerBox); ok && box.Logger != nil {
+		return box.Logger
+	}
+	return slog.Default()
+}
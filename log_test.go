@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+// recordHandler is a slog.Handler that keeps the records.
+type recordHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler      { return h }
+
+// find returns the attributes of the first record with message msg and
+// the given topic, and its level.
+func (h *recordHandler) find(msg, topic string) (map[string]slog.Value, slog.Level, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.records {
+		attrs := make(map[string]slog.Value)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		if r.Message == msg && attrs["topic"].String() == topic {
+			return attrs, r.Level, true
+		}
+	}
+	return nil, 0, false
+}
+
+// Published and received messages are logged at level Debug, with topic,
+// sequence number, and size.
+func TestLogMessages(t *testing.T) {
+	h := &recordHandler{}
+	SetLogger(slog.New(h))
+	t.Cleanup(func() { SetLogger(nil) })
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "a", "ping")
+	waitFlow(t, p, s)
+	publishPayloads(t, p, "a", "hello")
+	receiveTopics(t, s, 1)
+
+	for _, msg := range []string{"Published", "Received"} {
+		attrs, level, ok := h.find(msg, "a")
+		if !ok {
+			t.Errorf("no %s record for topic a", msg)
+			continue
+		}
+		if level != slog.LevelDebug || attrs["seq"].Uint64() != 1 || attrs["bytes"].Int64() < int64(len("hello")) {
+			t.Errorf("%s record at level %v with %v, want Debug with seq 1 and the size", msg, level, attrs)
+		}
+	}
+}
+
+// Without a logger of its own, the package logs to slog.Default().
+func TestLogDefault(t *testing.T) {
+	h := &recordHandler{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(h))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	SetLogger(nil) // restores the default
+
+	logger().Warn("Lost connection", "topic", "a")
+	if _, level, ok := h.find("Lost connection", "a"); !ok || level != slog.LevelWarn {
+		t.Errorf("record in the default logger: %v at %v, want a warning", ok, level)
+	}
+}
+
+// LoggingMiddleware logs handled messages at level Info, and failed ones
+// at level Error, with the error.
+func TestLoggingMiddleware(t *testing.T) {
+	h := &recordHandler{}
+	handle := LoggingMiddleware(slog.New(h))(func(msg Message) error {
+		if string(msg.Payload) == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	handle(Message{Topic: "ok", Payload: []byte("fine")})
+	if err := handle(Message{Topic: "bad", Payload: []byte("fail")}); err == nil {
+		t.Error("LoggingMiddleware swallows the error")
+	}
+
+	attrs, level, ok := h.find("Handled", "ok")
+	if !ok || level != slog.LevelInfo || attrs["bytes"].Int64() != 4 || attrs["duration"].Kind() != slog.KindDuration {
+		t.Errorf("Handled record: %v at %v with %v, want Info with 4 bytes and the duration", ok, level, attrs)
+	}
+	if attrs, level, ok := h.find("Handler failed", "bad"); !ok || level != slog.LevelError || attrs["error"].String() != "failed" {
+		t.Errorf("Handler failed record: %v at %v with %v, want Error with the error", ok, level, attrs)
+	}
+}
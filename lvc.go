@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-mangos/mangos"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// A subscriber that connects late sees nothing until the next message of
+// each topic arrives. For topics that change rarely, like configuration or
+// market snapshots, that can be a long wait. A publisher with a last-value
+// cache (see Options.LastValueCache) remembers the latest frame of every
+// topic and sends all of them again whenever a subscriber connects.
+//
+// A PUB socket cannot send to a single connection, so all subscribers get
+// these messages again, not only the new one. The repeated frames are
+// copies of the originals, including their sequence numbers, with the
+// repeat flag set in the header. Subscribers skip those that they have
+// seen already (see seq.go).
+
+// lastValueDelay is how long the publisher waits after a subscriber
+// connects before it repeats the cached messages. Mangos reports the new
+// connection before it adds it to the socket; messages sent in between
+// would not reach the new subscriber. Subscribers that connect within the
+// delay share one repetition.
+const lastValueDelay = 100 * time.Millisecond
+
+// lastValues is a last-value cache.
+type lastValues struct {
+	mu      sync.Mutex
+	socket  mangos.Socket     // set once the publisher's socket exists
+	frames  map[string][]byte // latest frame per topic in wire form
+	topics  []string          // in the order of their first message
+	pending *time.Timer       // repetition that is about to happen
+	closed  bool
+}
+
+func newLastValues() *lastValues {
+	return &lastValues{frames: make(map[string][]byte)}
+}
+
+// publish stores raw as the latest frame of topic and sends it. raw must
+// not be modified afterwards. Sending under the lock keeps a repetition
+// from overtaking a newer message of the same topic.
+func (c *lastValues) publish(topic string, raw []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.frames[topic]; !ok {
+		c.topics = append(c.topics, topic)
+	}
+	c.frames[topic] = raw
+	return socketError(c.socket.Send(raw))
+}
+
+// setSocket sets the socket to repeat the cached messages on. The socket
+// listens before it is known here, so subscribers can connect earlier;
+// there is nothing cached for them anyway.
+func (c *lastValues) setSocket(socket mangos.Socket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.socket = socket
+}
+
+// portHook schedules a repetition of the cached messages whenever a
+// subscriber connects.
+func (c *lastValues) portHook(action mangos.PortAction, _ mangos.Port) bool {
+	if action != mangos.PortActionAdd {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil && !c.closed {
+		c.pending = time.AfterFunc(lastValueDelay, c.repeat)
+	}
+	return true
+}
+
+// repeat sends the cached messages again, in the order in which their
+// topics first appeared. A PUB socket does not block on sending, so
+// holding the lock does not hold up publishing for long.
+func (c *lastValues) repeat() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = nil
+	if c.socket == nil {
+		return
+	}
+	for _, t := range c.topics {
+		raw := markFrame(c.frames[t], func(h *wire.Header) { h.Repeat = true })
+		// Send copies the frame. An error means the publisher is closed.
+		if c.socket.Send(raw) != nil {
+			return
+		}
+	}
+}
+
+// close cancels a pending repetition.
+func (c *lastValues) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.pending != nil {
+		c.pending.Stop()
+		c.pending = nil
+	}
+}
@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// A late subscriber gets the latest message of each of its topics once,
+// marked as a repeat, and then the new messages.
+func TestLastValueLateJoiner(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{LastValueCache: true})
+	// The early subscriber makes sure that the messages have gone out
+	// before the late one connects.
+	early := newTestSubscriber(t, url, Options{}, "")
+	for _, m := range []struct{ topic, payload string }{
+		{"a", "a1"}, {"b", "b1"}, {"a", "a2"}, {"c", "c1"}, {"a", "a3"},
+	} {
+		if err := p.Publish(m.topic, m.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	receiveN(t, early, 5)
+
+	late := newTestSubscriber(t, url, Options{}, "a", "b")
+	want := []struct {
+		topic, payload string
+		seq            uint64
+	}{
+		{"a", "a3", 3},
+		{"b", "b1", 1},
+	}
+	for _, w := range want {
+		msg := receiveN(t, late, 1)[0]
+		if msg.Topic != w.topic || string(msg.Payload) != w.payload || msg.Seq != w.seq || !msg.Repeat {
+			t.Errorf("late subscriber got %s %s, seq %d, repeat %v; want %s %s, seq %d, repeat true",
+				msg.Topic, msg.Payload, msg.Seq, msg.Repeat, w.topic, w.payload, w.seq)
+		}
+	}
+	late.SetRecvDeadline(lastValueDelay + 100*time.Millisecond)
+	if msg, err := late.ReceiveMessage(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("late subscriber got %s %s after the latest values, want nothing", msg.Topic, msg.Payload)
+	}
+
+	late.SetRecvDeadline(2 * time.Second)
+	publishAll(t, p, "a")
+	if msg := receiveN(t, late, 1)[0]; msg.Seq != 4 || msg.Repeat {
+		t.Errorf("late subscriber got seq %d, repeat %v; want 4, false", msg.Seq, msg.Repeat)
+	}
+}
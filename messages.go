@@ -0,0 +1,113 @@
+package pubsub
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Instead of calling Receive in a loop, a subscriber can also range over a
+// channel of messages. A goroutine receives from the socket, splits off the
+// topic, and feeds the channel.
+
+// OverflowPolicy decides what happens when the channel returned by
+// Subscriber.Messages is full because the consumer is too slow.
+type OverflowPolicy int
+
+const (
+	// Block stops receiving until the consumer catches up. Meanwhile,
+	// incoming messages queue up in the socket and get dropped there
+	// once its read queue is full, without being counted.
+	Block OverflowPolicy = iota
+
+	// DropNewest discards a message that does not fit into the channel
+	// and counts it (see Subscriber.Dropped). The receive loop never
+	// waits for the consumer.
+	DropNewest
+)
+
+// DefaultBufferSize is the default capacity of the Messages channel.
+const DefaultBufferSize = 64
+
+// SetBuffer sets the capacity of the Messages channel and the policy to
+// apply when it is full. The defaults are DefaultBufferSize and Block.
+// SetBuffer has no effect once Messages has been called.
+func (s *Subscriber) SetBuffer(size int, policy OverflowPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	s.bufferSize = size
+	s.overflow = policy
+}
+
+// Messages returns a channel that delivers all messages received by the
+// subscriber. The first call starts the receiving goroutine; later calls
+// return the same channel.
+//
+// Receive timeouts are not errors here; the goroutine simply keeps waiting.
+// Malformed messages are skipped.
+// The channel is closed when the subscriber is closed, or when receiving
+// fails with any other error, which Err then returns.
+//
+// Do not mix Messages with Receive or ReceiveContext: they would compete
+// for the same incoming messages.
+func (s *Subscriber) Messages() <-chan Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages == nil {
+		s.messages = make(chan Message, s.bufferSize)
+		go s.pump(s.messages, s.overflow)
+	}
+	return s.messages
+}
+
+// Err returns the error that closed the Messages channel, or nil if the
+// channel is still open or was closed by Close.
+func (s *Subscriber) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Dropped returns the number of messages discarded because the Messages
+// channel was full (only with the DropNewest policy).
+func (s *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// pump receives messages and sends them to ch until receiving fails.
+func (s *Subscriber) pump(ch chan<- Message, policy OverflowPolicy) {
+	defer close(ch)
+	for {
+		msg, err := receive(s.socket, &s.recv)
+		if err == ErrTimeout || errors.Is(err, ErrBadEnvelope) || errors.Is(err, ErrDecrypt) {
+			// Malformed and undecryptable messages are skipped, too.
+			continue
+		}
+		if err != nil {
+			s.mu.Lock()
+			if !s.closed {
+				s.err = err
+			}
+			s.mu.Unlock()
+			return
+		}
+
+		if policy == Block {
+			// A consumer that stopped reading must not keep the
+			// goroutine alive after Close.
+			select {
+			case ch <- msg:
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package pubsub
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pumpState returns the state of the goroutine that feeds the Messages
+// channel, such as "select" or "chan send", or "" if there is none.
+func pumpState() string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, ").pump(") {
+			header := strings.SplitN(g, "\n", 2)[0] // goroutine 7 [select]:
+			state := header[strings.Index(header, "[")+1:]
+			return strings.SplitN(state, "]", 2)[0]
+		}
+	}
+	return ""
+}
+
+// Close ends the goroutine behind Messages even if the consumer has
+// stopped reading and the goroutine waits to hand over a message.
+func TestMessagesBlockCloseDoesNotLeak(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "")
+	s.SetBuffer(0, Block)
+	ch := s.Messages()
+	if err := p.Publish("t", "never read"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for state := pumpState(); state != "select" && state != "chan send"; state = pumpState() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the goroutine does not wait for the consumer, state %q", pumpState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Close()
+	for pumpState() != "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("the goroutine is still running after Close, state %q", pumpState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The goroutine returned without handing over the message, so the
+	// channel is closed and empty.
+	if msg, ok := <-ch; ok {
+		t.Errorf("received %q after Close", msg.Payload)
+	}
+	if s.Err() != nil {
+		t.Errorf("Err() = %v after Close, want nil", s.Err())
+	}
+}
+
+func TestMessagesDropNewest(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "")
+	s.SetBuffer(2, DropNewest)
+	ch := s.Messages()
+	for _, payload := range []string{"1", "2", "3", "4"} {
+		if err := p.Publish("t", payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Dropped() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Dropped() = %d, want 2", s.Dropped())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, want := range []string{"1", "2"} {
+		if msg := <-ch; string(msg.Payload) != want {
+			t.Errorf("received %q, want %q", msg.Payload, want)
+		}
+	}
+	s.Close()
+	if _, ok := <-ch; ok {
+		t.Error("the channel is still open after Close")
+	}
+}
+
+// A subscriber of a v0.1.0 publisher, which sends "topic|payload" on a
+// plain PUB socket, and of a current one gets the messages of both, each
+// split by its own framing.
+func TestMessagesMixedFraming(t *testing.T) {
+	oldURL, newURL := testURL(t)+"-old", testURL(t)+"-new"
+	old, _, err := newPublisherSocket([]string{oldURL}, Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	p := newTestPublisher(t, newURL, Options{})
+	s, err := NewSubscriberURLs([]string{oldURL, newURL}, Options{}, "weather", "ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetRecvDeadline(2 * time.Second)
+	waitFlow(t, p, s)
+	sendOld := func(raw string) {
+		t.Helper()
+		if err := old.Send([]byte(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Like waitFlow, for the old publisher.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if time.Now().After(deadline) {
+			t.Fatal("no message of the old publisher got through")
+		}
+		sendOld("ping|")
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		msg, err := receiveContext(ctx, s.socket, &s.recv)
+		cancel()
+		if err == nil && msg.Legacy {
+			break
+		}
+	}
+
+	sendOld("weather|sunny")
+	if err := p.Publish("weather.eu|de", "rain\x00|"); err != nil {
+		t.Fatal(err)
+	}
+	sendOld("weather.eu|cloudy|windy")
+	sendOld("news|not subscribed")
+	if err := p.Publish("weather", "fog"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		topic, payload string
+		legacy         bool
+	}{
+		{"weather", "sunny", true},
+		{"weather.eu|de", "rain\x00|", false},
+		{"weather.eu", "cloudy|windy", true},
+		{"weather", "fog", false},
+	}
+	got := receiveTopics(t, s, len(want))
+	// The two publishers' messages may interleave differently, but each
+	// keeps its order.
+	for _, legacy := range []bool{true, false} {
+		var g, w []string
+		for _, msg := range got {
+			if msg.Legacy == legacy {
+				g = append(g, msg.Topic+" "+string(msg.Payload))
+			}
+		}
+		for _, m := range want {
+			if m.legacy == legacy {
+				w = append(w, m.topic+" "+m.payload)
+			}
+		}
+		if strings.Join(g, "\n") != strings.Join(w, "\n") {
+			t.Errorf("legacy %v: received %q, want %q", legacy, g, w)
+		}
+	}
+	for _, msg := range got {
+		if msg.Legacy && msg.Seq != 0 {
+			t.Errorf("legacy message %s with seq %d", msg.Topic, msg.Seq)
+		}
+	}
+}
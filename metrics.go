@@ -0,0 +1,178 @@
+package pubsub
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Publishers and subscribers count what passes through them. Metrics
+// returns a snapshot of the counters, which WritePrometheus formats for a
+// Prometheus scraper.
+
+// Metrics is a snapshot of the counters of a publisher or subscriber.
+// Counters that do not apply are zero: a publisher does not receive, and
+// a subscriber does not publish.
+type Metrics struct {
+	// Published and Received count the messages per topic.
+	Published map[string]uint64
+	Received  map[string]uint64
+
+	// BytesOut and BytesIn count the bytes of the frames, including the
+	// topics and headers. Replayed messages (see replay.go) add no bytes.
+	BytesOut uint64
+	BytesIn  uint64
+
+	// PublishErrors and ReceiveErrors count failed publishes and
+	// receives, including malformed messages. Receive timeouts are
+	// counted separately, in ReceiveTimeouts.
+	PublishErrors   uint64
+	ReceiveErrors   uint64
+	ReceiveTimeouts uint64
+
+	// Subscriptions is the number of topics, patterns, and topic
+	// templates that a subscriber is subscribed to.
+	Subscriptions int
+
+	// Reconnects counts how often a subscriber got a connection back
+	// after losing one.
+	Reconnects uint64
+}
+
+// metrics holds the counters and, for a subscriber, the statistics per
+// topic (see stats.go). A nil *metrics counts nothing.
+type metrics struct {
+	mu     sync.Mutex
+	m      Metrics
+	topics map[string]*TopicStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{m: Metrics{
+		Published: make(map[string]uint64),
+		Received:  make(map[string]uint64),
+	}, topics: make(map[string]*TopicStats)}
+}
+
+func (m *metrics) published(topic string, size int, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.m.PublishErrors++
+		return
+	}
+	m.m.Published[topic]++
+	m.m.BytesOut += uint64(size)
+}
+
+func (m *metrics) received(topic string, size int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m.Received[topic]++
+	m.m.BytesIn += uint64(size)
+	st := m.topics[topic]
+	if st == nil {
+		st = &TopicStats{}
+		m.topics[topic] = st
+	}
+	st.add(size, time.Now())
+}
+
+// receiveFailed counts a failed receive. A closed subscriber does not
+// count.
+func (m *metrics) receiveFailed(err error) {
+	if m == nil || err == ErrClosed {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == ErrTimeout {
+		m.m.ReceiveTimeouts++
+		return
+	}
+	m.m.ReceiveErrors++
+}
+
+func (m *metrics) reconnected() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m.Reconnects++
+}
+
+// snapshot returns a copy of the counters.
+func (m *metrics) snapshot() Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.m
+	s.Published = make(map[string]uint64, len(m.m.Published))
+	for t, n := range m.m.Published {
+		s.Published[t] = n
+	}
+	s.Received = make(map[string]uint64, len(m.m.Received))
+	for t, n := range m.m.Received {
+		s.Received[t] = n
+	}
+	return s
+}
+
+// Metrics returns the counters of the publisher.
+func (p *Publisher) Metrics() Metrics {
+	return p.metrics.snapshot()
+}
+
+// Metrics returns the counters of the subscriber.
+func (s *Subscriber) Metrics() Metrics {
+	m := s.recv.metrics.snapshot()
+	s.mu.Lock()
+	m.Subscriptions = len(s.topics) + len(s.patterns) + len(s.templates)
+	s.mu.Unlock()
+	return m
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format. All
+// names start with "pubsub_"; the per-topic counters have a "topic" label.
+func (m Metrics) WritePrometheus(w io.Writer) error {
+	var b strings.Builder
+	perTopic := func(name, help string, counts map[string]uint64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		topics := make([]string, 0, len(counts))
+		for t := range counts {
+			topics = append(topics, t)
+		}
+		sort.Strings(topics)
+		for _, t := range topics {
+			fmt.Fprintf(&b, "%s{topic=\"%s\"} %d\n", name, escapeLabel(t), counts[t])
+		}
+	}
+	single := func(name, typ, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+	}
+	perTopic("pubsub_published_messages_total", "Messages published, per topic.", m.Published)
+	perTopic("pubsub_received_messages_total", "Messages received, per topic.", m.Received)
+	single("pubsub_sent_bytes_total", "counter", "Bytes of published frames.", m.BytesOut)
+	single("pubsub_received_bytes_total", "counter", "Bytes of received frames.", m.BytesIn)
+	single("pubsub_publish_errors_total", "counter", "Failed publishes.", m.PublishErrors)
+	single("pubsub_receive_errors_total", "counter", "Failed receives, including malformed messages.", m.ReceiveErrors)
+	single("pubsub_receive_timeouts_total", "counter", "Receives that timed out.", m.ReceiveTimeouts)
+	single("pubsub_subscriptions", "gauge", "Subscribed topics and patterns.", m.Subscriptions)
+	single("pubsub_reconnects_total", "counter", "Connections regained after a loss.", m.Reconnects)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeLabel escapes a label value for the Prometheus text format.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := Metrics{
+		Published:     map[string]uint64{"b": 2, "a": 1},
+		Received:      map[string]uint64{"say \"hi\"\\\n": 3},
+		BytesOut:      10,
+		BytesIn:       20,
+		PublishErrors: 1,
+		ReceiveErrors: 2,
+		Subscriptions: 4,
+		Reconnects:    5,
+	}
+	var b strings.Builder
+	if err := m.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP pubsub_published_messages_total Messages published, per topic.
+# TYPE pubsub_published_messages_total counter
+pubsub_published_messages_total{topic="a"} 1
+pubsub_published_messages_total{topic="b"} 2
+# HELP pubsub_received_messages_total Messages received, per topic.
+# TYPE pubsub_received_messages_total counter
+pubsub_received_messages_total{topic="say \"hi\"\\\n"} 3
+# HELP pubsub_sent_bytes_total Bytes of published frames.
+# TYPE pubsub_sent_bytes_total counter
+pubsub_sent_bytes_total 10
+# HELP pubsub_received_bytes_total Bytes of received frames.
+# TYPE pubsub_received_bytes_total counter
+pubsub_received_bytes_total 20
+# HELP pubsub_publish_errors_total Failed publishes.
+# TYPE pubsub_publish_errors_total counter
+pubsub_publish_errors_total 1
+# HELP pubsub_receive_errors_total Failed receives, including malformed messages.
+# TYPE pubsub_receive_errors_total counter
+pubsub_receive_errors_total 2
+# HELP pubsub_receive_timeouts_total Receives that timed out.
+# TYPE pubsub_receive_timeouts_total counter
+pubsub_receive_timeouts_total 0
+# HELP pubsub_subscriptions Subscribed topics and patterns.
+# TYPE pubsub_subscriptions gauge
+pubsub_subscriptions 4
+# HELP pubsub_reconnects_total Connections regained after a loss.
+# TYPE pubsub_reconnects_total counter
+pubsub_reconnects_total 5
+`
+	if got := b.String(); got != want {
+		t.Errorf("WritePrometheus wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+// The counters of a publisher and a subscriber agree on what passed
+// between them, and count errors and timeouts.
+func TestMetricsCount(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "a", "b", "ping")
+	if err := s.SubscribePattern("c.*"); err != nil {
+		t.Fatal(err)
+	}
+	waitFlow(t, p, s)
+	before := p.Metrics()
+
+	publishPayloads(t, p, "a", "1", "22", "333")
+	publishPayloads(t, p, "b", "4444")
+	if err := p.Publish("bad\x00topic", ""); err == nil {
+		t.Fatal("publishing a topic with a NUL byte succeeds")
+	}
+	receiveTopics(t, s, 4)
+	raw, err := encodeMessage(wire.Binary, Message{Topic: "a"}, &sendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.socket.Send(raw[:len(raw)-1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReceiveMessage(); err == nil {
+		t.Fatal("truncated frame received")
+	}
+	s.SetRecvDeadline(10 * time.Millisecond)
+	s.ReceiveMessage()
+
+	pm, sm := p.Metrics(), s.Metrics()
+	if pm.Published["a"] != 3 || pm.Published["b"] != 1 || pm.PublishErrors != 1 {
+		t.Errorf("publisher counted %v and %d errors, want a: 3, b: 1, and 1 error", pm.Published, pm.PublishErrors)
+	}
+	if sm.Received["a"] != 3 || sm.Received["b"] != 1 || sm.ReceiveErrors != 1 || sm.ReceiveTimeouts != 1 {
+		t.Errorf("subscriber counted %v, %d errors, and %d timeouts; want a: 3, b: 1, 1 error, and 1 timeout",
+			sm.Received, sm.ReceiveErrors, sm.ReceiveTimeouts)
+	}
+	sent := pm.BytesOut - before.BytesOut
+	if sent < uint64(len("122333")+len("4444")) || sm.Subscriptions != 4 {
+		t.Errorf("publisher sent %d bytes, subscriber has %d subscriptions; want at least 10 bytes and 4", sent, sm.Subscriptions)
+	}
+}
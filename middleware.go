@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Logging, metrics, or validation apply to all messages alike. Instead of
+// repeating them in every handler, they can wrap the handlers as
+// middleware on the subscriber side, or intercept the messages on the
+// publisher side.
+
+// Middleware wraps a handler in another one. It can act before and after
+// calling next, change the message, or not call next at all.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use adds middleware to the handlers of Run, including the default
+// handler. The middleware registered first runs outermost: with Use(a, b),
+// a message passes a, then b, then the handler.
+func (s *Subscriber) Use(mw ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, mw...)
+}
+
+// chain wraps fn in the middleware. s.mu must be held.
+func (s *Subscriber) chain(fn HandlerFunc) HandlerFunc {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		fn = s.middleware[i](fn)
+	}
+	return fn
+}
+
+// LoggingMiddleware logs each message with its topic, size, how long the
+// handler took, and the error, if any, at level Info, or Error if the
+// handler failed. A nil logger means the logger of the package (see
+// SetLogger).
+func LoggingMiddleware(l Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(msg Message) error {
+			start := time.Now()
+			err := next(msg)
+			log := l
+			if log == nil {
+				log = logger()
+			}
+			args := []any{"topic", msg.Topic, "bytes", len(msg.Payload), "duration", time.Since(start)}
+			if err != nil {
+				log.Error("Handler failed", append(args, "error", err)...)
+			} else {
+				log.Info("Handled", args...)
+			}
+			return err
+		}
+	}
+}
+
+// RecoveryMiddleware turns a panic of an inner handler into an error and
+// logs the stack trace. Run recovers from panics anyway; with this
+// middleware, the outer middleware sees them as errors, too.
+func RecoveryMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(msg Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger().Error("Handler panicked", "topic", msg.Topic, "panic", r, "stack", string(debug.Stack()))
+					err = fmt.Errorf("handler panicked: %v", r)
+				}
+			}()
+			return next(msg)
+		}
+	}
+}
+
+// PublishInterceptor sees each message before the publisher frames and
+// sends it. It returns the message to send, which may have a different
+// topic or payload. If it returns an error, the message is not sent: for
+// ErrDropMessage, Publish returns nil; for any other error, Publish
+// returns the error.
+type PublishInterceptor func(Message) (Message, error)
+
+// ErrDropMessage makes a PublishInterceptor drop a message silently.
+var ErrDropMessage = errors.New("message dropped")
+
+// Intercept adds interceptors to the publisher. They run in the order in
+// which they were added.
+func (p *Publisher) Intercept(i ...PublishInterceptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interceptors = append(p.interceptors, i...)
+}
+
+// intercept passes msg through the interceptors. p.mu must be held.
+func (p *Publisher) intercept(msg Message) (Message, error) {
+	for _, i := range p.interceptors {
+		var err error
+		msg, err = i(msg)
+		if err != nil {
+			return Message{}, err
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Middleware registered first runs outermost, and middleware can change a
+// message or keep it from the handler.
+func TestUseOrder(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "ping")
+	waitFlow(t, p, s)
+
+	var mu sync.Mutex
+	var trace []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		trace = append(trace, step)
+	}
+	named := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(msg Message) error {
+				record(name + ">")
+				err := next(msg)
+				record("<" + name)
+				return err
+			}
+		}
+	}
+	upper := func(next HandlerFunc) HandlerFunc {
+		return func(msg Message) error {
+			msg.Payload = []byte(strings.ToUpper(string(msg.Payload)))
+			return next(msg)
+		}
+	}
+	dropSecret := func(next HandlerFunc) HandlerFunc {
+		return func(msg Message) error {
+			if string(msg.Payload) == "SECRET" {
+				return nil
+			}
+			return next(msg)
+		}
+	}
+	handled := make(chan string, 10)
+	s.Handle("ping", func(Message) error { return nil })
+	s.Handle("jobs", func(msg Message) error {
+		record("handler")
+		handled <- string(msg.Payload)
+		return nil
+	})
+	s.Use(named("a"), upper)
+	s.Use(dropSecret, named("b"))
+	stop := runHandlers(t, s)
+	defer stop()
+
+	publishPayloads(t, p, "jobs", "secret", "hello")
+	if got := nextHandled(t, handled); got != "HELLO" {
+		t.Errorf("handled %q, want HELLO", got)
+	}
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "a> <a a> b> handler <b <a"
+	if got := strings.Join(trace, " "); !strings.HasSuffix(got, want) {
+		t.Errorf("trace %q, want it to end with %q", got, want)
+	}
+}
+
+// A panic becomes an error that outer middleware sees.
+func TestRecoveryMiddleware(t *testing.T) {
+	captureLog(t)
+	var seen error
+	outer := func(next HandlerFunc) HandlerFunc {
+		return func(msg Message) error {
+			seen = next(msg)
+			return seen
+		}
+	}
+	panicking := func(Message) error { panic("boom") }
+	err := outer(RecoveryMiddleware()(panicking))(Message{Topic: "jobs"})
+	if err == nil || err.Error() != "handler panicked: boom" || seen != err {
+		t.Errorf("error %v, outer middleware saw %v; want handler panicked: boom for both", err, seen)
+	}
+}
+
+// Interceptors run in the order in which they were added, and can change
+// or drop a message.
+func TestIntercept(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "ping", "jobs", "archive")
+	waitFlow(t, p, s)
+
+	appendTo := func(suffix string) PublishInterceptor {
+		return func(msg Message) (Message, error) {
+			msg.Payload = append(msg.Payload, suffix...)
+			return msg, nil
+		}
+	}
+	errRejected := errors.New("rejected")
+	p.Intercept(appendTo("1"), func(msg Message) (Message, error) {
+		switch string(msg.Payload) {
+		case "noise1":
+			return Message{}, ErrDropMessage
+		case "bad1":
+			return Message{}, errRejected
+		case "old1":
+			msg.Topic = "archive"
+		}
+		return msg, nil
+	})
+	p.Intercept(appendTo("2"))
+
+	for payload, want := range map[string]error{"noise": nil, "bad": errRejected, "old": nil, "new": nil} {
+		if err := p.Publish("jobs", payload); !errors.Is(err, want) {
+			t.Errorf("Publish(%s) = %v, want %v", payload, err, want)
+		}
+	}
+	got := map[string]string{}
+	for _, msg := range receiveTopics(t, s, 2) {
+		got[msg.Topic] = string(msg.Payload)
+	}
+	if len(got) != 2 || got["jobs"] != "new12" || got["archive"] != "old12" {
+		t.Errorf("received %v, want jobs new12 and archive old12", got)
+	}
+	waitFlow(t, p, s) // no dropped message comes after the two
+}
@@ -0,0 +1,273 @@
+// Package minisub is a tiny, dependency-free SUB client for the
+// scalability protocols (SP) spoken by nanomsg and Mangos.
+//
+// It implements just enough of the SP wire protocol over TCP to receive
+// messages from a Mangos (or nanomsg) PUB socket: the SP protocol header
+// handshake, the 64-bit length-prefixed message framing, and the prefix
+// filtering that a regular SUB socket would do. Everything else--other
+// transports, other protocols, socket options--is left out on purpose.
+//
+// A Conn reconnects by itself if the publisher goes away, so a typical
+// consumer looks like this:
+//
+//	c, err := minisub.Dial("tcp://localhost:56565")
+//	...
+//	c.Subscribe("Weather")
+//	for {
+//		msg, err := c.Recv()
+//		...
+//	}
+package minisub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The SP protocol numbers of the PUB and SUB protocols.
+// See the nanomsg RFC "sp-protocol-ids-01".
+const (
+	protoPub = 2 * 16
+	protoSub = 2*16 + 1
+)
+
+// DefaultMaxRecvSize is the largest message Recv accepts unless changed
+// with SetMaxRecvSize. It matches the Mangos default.
+const DefaultMaxRecvSize = 1024 * 1024
+
+// Errors returned by Dial and Recv.
+var (
+	ErrClosed    = errors.New("minisub: connection closed")
+	ErrBadScheme = errors.New("minisub: only tcp:// URLs are supported")
+	ErrBadHeader = errors.New("minisub: invalid SP protocol header")
+	ErrBadProto  = errors.New("minisub: peer is not a PUB socket")
+	ErrTooLong   = errors.New("minisub: message exceeds maximum receive size")
+)
+
+// Reconnect backoff bounds and the handshake timeout.
+const (
+	reconnectMin  = 100 * time.Millisecond
+	reconnectMax  = 30 * time.Second
+	handshakeTime = 5 * time.Second
+)
+
+// Conn is a SUB connection to a single publisher.
+// Subscribe and Close may be called concurrently with Recv.
+type Conn struct {
+	addr string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	topics  [][]byte
+	maxRecv int64
+	closed  bool
+	done    chan struct{}
+}
+
+// Dial connects to the publisher at url, which must have the form
+// "tcp://host:port". The first connection attempt must succeed; after
+// that, Recv reconnects transparently whenever the connection drops.
+func Dial(url string) (*Conn, error) {
+	if !strings.HasPrefix(url, "tcp://") {
+		return nil, ErrBadScheme
+	}
+	c := &Conn{
+		addr:    strings.TrimPrefix(url, "tcp://"),
+		maxRecv: DefaultMaxRecvSize,
+		done:    make(chan struct{}),
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// Subscribe adds a topic. Like with a Mangos SUB socket, a message matches
+// if it starts with the topic bytes, and the empty topic matches everything.
+// Until the first call to Subscribe, Recv delivers nothing.
+func (c *Conn) Subscribe(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.topics {
+		if string(t) == topic {
+			return
+		}
+	}
+	c.topics = append(c.topics, []byte(topic))
+}
+
+// SetMaxRecvSize changes the largest message Recv accepts.
+// Zero or a negative value means no limit.
+func (c *Conn) SetMaxRecvSize(n int) {
+	c.mu.Lock()
+	c.maxRecv = int64(n)
+	c.mu.Unlock()
+}
+
+// Recv blocks until a message matching one of the subscribed topics
+// arrives, and returns the complete message, topic prefix included.
+// If the connection breaks, Recv redials with exponential backoff until it
+// succeeds or Close is called, in which case it returns ErrClosed.
+// A message exceeding the maximum receive size also drops the connection,
+// just as Mangos does, but Recv reports ErrTooLong once before reconnecting.
+func (c *Conn) Recv() ([]byte, error) {
+	for {
+		conn, err := c.current()
+		if err != nil {
+			return nil, err
+		}
+		msg, err := c.readMsg(conn)
+		if err != nil {
+			c.drop(conn)
+			if err == ErrTooLong {
+				return nil, err
+			}
+			continue
+		}
+		if c.matches(msg) {
+			return msg, nil
+		}
+	}
+}
+
+// Close closes the connection and makes any pending and future Recv
+// calls return ErrClosed.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	close(c.done)
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// current returns the live connection, redialing if there is none.
+func (c *Conn) current() (net.Conn, error) {
+	backoff := reconnectMin
+	for {
+		c.mu.Lock()
+		conn, closed := c.conn, c.closed
+		c.mu.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		if conn != nil {
+			return conn, nil
+		}
+
+		conn, err := c.connect()
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.Close()
+				return nil, ErrClosed
+			}
+			c.conn = conn
+			c.mu.Unlock()
+			return conn, nil
+		}
+
+		select {
+		case <-c.done:
+			return nil, ErrClosed
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > reconnectMax {
+			backoff = reconnectMax
+		}
+	}
+}
+
+// drop discards a broken connection so that the next call to current
+// redials. Another goroutine may have replaced it already; leave that alone.
+func (c *Conn) drop(conn net.Conn) {
+	conn.Close()
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+}
+
+// connect dials the publisher and performs the SP handshake:
+// both sides send an 8-byte header ("\x00SP\x00", the 16-bit protocol
+// number, two reserved zero bytes) and check the peer's header.
+func (c *Conn) connect() (net.Conn, error) {
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTime))
+
+	var hdr [8]byte
+	copy(hdr[:4], "\x00SP\x00")
+	binary.BigEndian.PutUint16(hdr[4:6], protoSub)
+	if _, err := conn.Write(hdr[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:4], []byte("\x00SP\x00")) || hdr[6] != 0 || hdr[7] != 0 {
+		conn.Close()
+		return nil, ErrBadHeader
+	}
+	if binary.BigEndian.Uint16(hdr[4:6]) != protoPub {
+		conn.Close()
+		return nil, ErrBadProto
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// readMsg reads one frame: a 64-bit big-endian length, then the message.
+func (c *Conn) readMsg(conn net.Conn) ([]byte, error) {
+	var sz [8]byte
+	if _, err := io.ReadFull(conn, sz[:]); err != nil {
+		return nil, err
+	}
+	n := int64(binary.BigEndian.Uint64(sz[:]))
+
+	c.mu.Lock()
+	max := c.maxRecv
+	c.mu.Unlock()
+	if n < 0 || (max > 0 && n > max) {
+		return nil, ErrTooLong
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// matches does the prefix filtering a SUB socket would do.
+func (c *Conn) matches(msg []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.topics {
+		if bytes.HasPrefix(msg, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package minisub_test
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mangos/mangos"
+	"github.com/go-mangos/mangos/protocol/pub"
+	"github.com/go-mangos/mangos/protocol/rep"
+	"github.com/go-mangos/mangos/transport/tcp"
+
+	"github.com/appliedgo/pubsub/minisub"
+)
+
+// listen returns a Mangos socket of the given kind that listens on addr,
+// which may have port 0, and the address it listens on.
+func listen(t *testing.T, newSocket func() (mangos.Socket, error), addr string) (mangos.Socket, string) {
+	t.Helper()
+	if addr == "" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = l.Addr().String()
+		l.Close()
+	}
+	sock, err := newSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock.AddTransport(tcp.NewTransport())
+	if err := sock.Listen("tcp://" + addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sock.Close() })
+	return sock, addr
+}
+
+// result is what Recv returned.
+type result struct {
+	msg []byte
+	err error
+}
+
+// receive calls Recv until c is closed and passes on what it returns.
+func receive(c *minisub.Conn) <-chan result {
+	ch := make(chan result, 100)
+	go func() {
+		defer close(ch)
+		for {
+			msg, err := c.Recv()
+			ch <- result{msg, err}
+			if err == minisub.ErrClosed {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// waitFlow sends "ping|" until it arrives, as Mangos starts sending on a
+// connection a moment after the handshake. c must be subscribed to
+// "ping".
+func waitFlow(t *testing.T, p mangos.Socket, ch <-chan result) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	tick := time.NewTicker(20 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case r := <-ch:
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			if bytes.HasPrefix(r.msg, []byte("ping|")) {
+				return
+			}
+		case <-tick.C:
+			if err := p.Send([]byte("ping|")); err != nil {
+				t.Fatal(err)
+			}
+		case <-deadline:
+			t.Fatal("no message got through")
+		}
+	}
+}
+
+// next returns the next result that is not a ping.
+func next(t *testing.T, ch <-chan result) result {
+	t.Helper()
+	for {
+		select {
+		case r := <-ch:
+			if !bytes.HasPrefix(r.msg, []byte("ping|")) {
+				return r
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("nothing received")
+		}
+	}
+}
+
+func dial(t *testing.T, addr string, topics ...string) (*minisub.Conn, <-chan result) {
+	t.Helper()
+	c, err := minisub.Dial("tcp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range append(topics, "ping") {
+		c.Subscribe(topic)
+	}
+	ch := receive(c)
+	t.Cleanup(func() {
+		c.Close()
+		for range ch {
+		}
+	})
+	return c, ch
+}
+
+func TestHandshake(t *testing.T) {
+	_, addr := listen(t, pub.NewSocket, "")
+	c, err := minisub.Dial("tcp://" + addr)
+	if err != nil {
+		t.Fatalf("Dial to a PUB socket: %v", err)
+	}
+	c.Close()
+	if err := c.Close(); err != minisub.ErrClosed {
+		t.Errorf("second Close: %v, want ErrClosed", err)
+	}
+	if _, err := c.Recv(); err != minisub.ErrClosed {
+		t.Errorf("Recv after Close: %v, want ErrClosed", err)
+	}
+
+	_, addr = listen(t, rep.NewSocket, "")
+	if _, err := minisub.Dial("tcp://" + addr); err != minisub.ErrBadProto {
+		t.Errorf("Dial to a REP socket: %v, want ErrBadProto", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Write([]byte("HTTP/1.1"))
+			conn.Close()
+		}
+	}()
+	if _, err := minisub.Dial("tcp://" + l.Addr().String()); err != minisub.ErrBadHeader {
+		t.Errorf("Dial to an HTTP server: %v, want ErrBadHeader", err)
+	}
+
+	if _, err := minisub.Dial("ipc:///tmp/pubsub.ipc"); err != minisub.ErrBadScheme {
+		t.Errorf("Dial to ipc://: %v, want ErrBadScheme", err)
+	}
+}
+
+func TestPrefixFiltering(t *testing.T) {
+	p, addr := listen(t, pub.NewSocket, "")
+	_, ch := dial(t, addr, "Weather", "Stocks.eu")
+	waitFlow(t, p, ch)
+	for _, msg := range []string{"Sports|Goal", "Weather|Sunny", "Stocks.us|Up", "Stocks.eu|Down", "Weather\x00\x01binary"} {
+		if err := p.Send([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"Weather|Sunny", "Stocks.eu|Down", "Weather\x00\x01binary"} {
+		if r := next(t, ch); r.err != nil || string(r.msg) != want {
+			t.Errorf("received %q, %v; want %q", r.msg, r.err, want)
+		}
+	}
+}
+
+func TestReconnectAfterPublisherRestart(t *testing.T) {
+	p, addr := listen(t, pub.NewSocket, "")
+	_, ch := dial(t, addr, "Weather")
+	waitFlow(t, p, ch)
+	if err := p.Send([]byte("Weather|before")); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(t, ch); string(r.msg) != "Weather|before" {
+		t.Fatalf("received %q, %v", r.msg, r.err)
+	}
+
+	p.Close()
+	p, _ = listen(t, pub.NewSocket, addr)
+	waitFlow(t, p, ch)
+	if err := p.Send([]byte("Weather|after")); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(t, ch); string(r.msg) != "Weather|after" {
+		t.Errorf("received %q, %v after the restart", r.msg, r.err)
+	}
+}
+
+func TestTooLong(t *testing.T) {
+	p, addr := listen(t, pub.NewSocket, "")
+	c, ch := dial(t, addr, "Weather")
+	c.SetMaxRecvSize(32)
+	waitFlow(t, p, ch)
+	if err := p.Send([]byte("Weather|" + strings.Repeat("x", 100))); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(t, ch); r.err != minisub.ErrTooLong {
+		t.Fatalf("received %q, %v; want ErrTooLong", r.msg, r.err)
+	}
+	// The connection is dropped, and Recv reconnects.
+	waitFlow(t, p, ch)
+	if err := p.Send([]byte("Weather|short")); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(t, ch); r.err != nil || string(r.msg) != "Weather|short" {
+		t.Errorf("received %q, %v after ErrTooLong", r.msg, r.err)
+	}
+}
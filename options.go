@@ -0,0 +1,214 @@
+package pubsub
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-mangos/mangos"
+)
+
+// Some settings must be in place before a socket listens or dials. They go
+// into an Options value that NewPublisherWithOptions and
+// NewSubscriberWithOptions take.
+
+// Options configure a Publisher or Subscriber beyond the URL.
+type Options struct {
+	// TLSConfig is required for "tls+tcp://" and "wss://" URLs and not
+	// allowed if none of the URLs is one of them. Other URLs ignore it.
+	//
+	// A publisher needs a certificate in it. To require client
+	// certificates (mutual TLS), set ClientAuth to
+	// tls.RequireAndVerifyClientCert and ClientCAs to the CAs that sign
+	// them.
+	//
+	// A subscriber verifies the publisher's certificate against RootCAs
+	// (or the system roots) and, for mutual TLS, presents the certificate
+	// in Certificates. If ServerName is empty, the host of the URL is
+	// used.
+	TLSConfig *tls.Config
+
+	// ReconnectTime is how long a subscriber waits before it dials again
+	// after a failed dial or a lost connection. The wait doubles with
+	// every failed attempt, up to MaxReconnectTime. Zero means
+	// DefaultReconnectTime and DefaultMaxReconnectTime, respectively.
+	// Publishers do not dial and ignore both.
+	ReconnectTime    time.Duration
+	MaxReconnectTime time.Duration
+
+	// ListenAttempts is how often a publisher tries to listen while the
+	// address is still in use, for example by a process that is just
+	// shutting down. ListenBackoff is the wait after the first attempt;
+	// it doubles after each further attempt. Zero means
+	// DefaultListenAttempts and DefaultListenBackoff, respectively.
+	// Subscribers do not listen and ignore both.
+	ListenAttempts int
+	ListenBackoff  time.Duration
+
+	// StrictDial makes NewSubscriberURLs fail if any of its URLs cannot be
+	// dialed. By default, it only fails if none can. Publishers ignore it.
+	StrictDial bool
+
+	// LastValueCache makes a publisher remember the latest message of
+	// each topic and send all of them again shortly after a subscriber
+	// connects, so that late subscribers start with the current state.
+	// The other subscribers receive these messages again, too, but skip
+	// them, as they are marked as repeats and carry sequence numbers that
+	// the subscribers have seen. The text framing carries neither. (See
+	// lvc.go.) Subscribers and forwarders ignore it.
+	LastValueCache bool
+
+	// ReplayURL enables the replay of missed messages (see replay.go). A
+	// publisher listens on it for replay requests and keeps the latest
+	// Retention messages of each topic; zero means DefaultRetention. A
+	// subscriber sends its requests there. TLSConfig applies to it, too.
+	ReplayURL string
+	Retention int
+
+	// AutoReplay makes a subscriber with a ReplayURL request the missing
+	// messages as soon as it notices a gap. It receives them in order,
+	// before the message that revealed the gap. Publishers ignore it.
+	AutoReplay bool
+
+	// ReorderWindow is the number of messages per topic that a
+	// subscriber holds back when they overtake earlier ones, to pass them
+	// on in order (see reorder.go). Zero passes messages on as they
+	// arrive. Publishers and forwarders ignore it.
+	ReorderWindow int
+
+	// PayloadKey is an AES key of 16, 24, or 32 bytes. A publisher
+	// encrypts the payloads with it, and a subscriber decrypts them (see
+	// crypt.go). If it is nil, the hex-encoded key in the environment
+	// variable PUBSUB_PAYLOAD_KEY is used, if there is one. A subscriber
+	// with a key refuses messages in the clear. Forwarders ignore it and
+	// pass the payloads on as they are; a forwarder that rewrites topics
+	// makes them impossible to decrypt.
+	PayloadKey []byte
+
+	// AuthKey is a secret that a publisher signs each frame with and a
+	// subscriber checks the signature against, to reject forged and
+	// corrupted messages (see auth.go). Forwarders ignore it; a forwarder
+	// that rewrites topics breaks the signatures.
+	AuthKey []byte
+
+	// ASCIITopics percent-encodes topics on the wire, so that they
+	// consist of printable ASCII bytes only (see internal/wire). Received
+	// topics are decoded again. All publishers and subscribers of a topic
+	// must agree on it. Forwarders pass frames on as they are, so their
+	// rules see topics as their upstream publishers send them; the
+	// setting of upOpts must match those.
+	ASCIITopics bool
+
+	// Journal is a directory in which a publisher records every message
+	// before sending it (see journal.go). A segment of the journal ends
+	// when it reaches JournalSegmentSize bytes; zero means
+	// DefaultJournalSegmentSize. Subscribers and forwarders ignore both.
+	Journal            string
+	JournalSegmentSize int64
+
+	// RateLimit limits a publisher to that many messages per second, with
+	// bursts of up to RateBurst messages; RateLimitMode selects whether
+	// publishing waits or fails when a message is not allowed yet (see
+	// ratelimit.go and SetRateLimit). Zero means no limit. Subscribers
+	// and forwarders ignore all three.
+	RateLimit     float64
+	RateBurst     int
+	RateLimitMode RateLimitMode
+}
+
+// WithRateLimit returns a copy of o with a rate limit of msgsPerSec
+// messages per second and bursts of up to burst messages.
+func (o Options) WithRateLimit(msgsPerSec float64, burst int) Options {
+	o.RateLimit, o.RateBurst = msgsPerSec, burst
+	return o
+}
+
+// Defaults for Options.ReconnectTime and Options.MaxReconnectTime.
+const (
+	DefaultReconnectTime    = 100 * time.Millisecond
+	DefaultMaxReconnectTime = 30 * time.Second
+)
+
+// Defaults for Options.ListenAttempts and Options.ListenBackoff. With
+// these, a publisher gives up after about 1.5 seconds.
+const (
+	DefaultListenAttempts = 5
+	DefaultListenBackoff  = 100 * time.Millisecond
+)
+
+// reconnectTimes returns the first and the longest wait before a redial.
+// Mangos doubles the wait by itself but adds no jitter, so subscribers
+// that lost the same publisher would all redial at the same moments. To
+// spread them out, the first wait varies between half and one and a half
+// times ReconnectTime; the doubling keeps them apart afterwards.
+func (o Options) reconnectTimes() (first, max time.Duration) {
+	first, max = o.ReconnectTime, o.MaxReconnectTime
+	if first <= 0 {
+		first = DefaultReconnectTime
+	}
+	if max <= 0 {
+		max = DefaultMaxReconnectTime
+	}
+	if max < first {
+		max = first
+	}
+	jitter := time.Duration(time.Now().UnixNano() % int64(first))
+	return first/2 + jitter, max
+}
+
+// scheme returns the scheme of a URL like "tls+tcp://localhost:56565".
+func scheme(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return addr[:i]
+	}
+	return ""
+}
+
+// isTLS tells whether addr is a TLS URL.
+func isTLS(addr string) bool {
+	return scheme(addr) == "tls+tcp" || scheme(addr) == "wss"
+}
+
+// checkTLS rejects a TLS configuration that none of the URLs would use,
+// as this is most likely a mistake.
+func checkTLS(addrs []string, opts Options) error {
+	if opts.TLSConfig == nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if isTLS(addr) {
+			return nil
+		}
+	}
+	if len(addrs) == 1 {
+		return fmt.Errorf("%s is not a TLS URL, but a TLS configuration was given", addrs[0])
+	}
+	return fmt.Errorf("none of %s is a TLS URL, but a TLS configuration was given", strings.Join(addrs, ", "))
+}
+
+// transportOptions returns the options for listening on or dialing addr.
+// Unlike socket options, these go to the transport, which is the only
+// place where the TLS configuration has an effect.
+func transportOptions(addr string, opts Options, dial bool) (map[string]interface{}, error) {
+	switch {
+	case isTLS(addr) && opts.TLSConfig == nil:
+		return nil, fmt.Errorf("%s needs a TLS configuration", addr)
+	case !isTLS(addr):
+		return nil, nil
+	}
+
+	config := opts.TLSConfig
+	if dial && config.ServerName == "" {
+		// Mangos does not set the server name for tls+tcp, and without
+		// one, the publisher's certificate cannot be verified.
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = u.Hostname()
+	}
+	return map[string]interface{}{mangos.OptionTLSConfig: config}, nil
+}
@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/appliedgo/pubsub/internal/topicindex"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Mangos only filters by byte prefix. For a pattern like "sports.*.scores",
+// the subscriber subscribes the socket to the literal part before the first
+// wildcard ("sports.") and checks the complete pattern itself when a message
+// arrives.
+
+// subPattern is a pattern subscription.
+type subPattern struct {
+	prefix string // socket subscription, in wire form
+	id     topicindex.ID
+}
+
+// compilePattern splits a pattern into its literal prefix and a regular
+// expression for the whole pattern.
+func compilePattern(pattern string) (prefix string, re *regexp.Regexp) {
+	pattern = norm.NFC.String(pattern)
+	parts := strings.Split(pattern, "*")
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = regexp.QuoteMeta(part)
+	}
+	wildcard := "[^" + regexp.QuoteMeta(topicSeparators) + "]*"
+	return parts[0], regexp.MustCompile("^" + strings.Join(quoted, wildcard) + "$")
+}
+
+// SubscribePattern subscribes to all topics that match pattern. In a
+// pattern, "*" stands for any number of characters within one segment,
+// that is, anything but the separators "/" and ".". So "sports.*.scores"
+// matches "sports.tennis.scores" but not "sports.tennis.live.scores", and
+// "sports.*" matches "sports.tennis" but not "sports.tennis.scores".
+// A pattern without "*" matches only the exact topic.
+//
+// The publisher sends everything that starts with the literal part of the
+// pattern before the first "*"; the subscriber drops messages that do not
+// match the whole pattern (see Filtered). A pattern that starts with "*"
+// therefore receives all messages before filtering.
+func (s *Subscriber) SubscribePattern(pattern string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.patterns[pattern]; ok {
+		return nil
+	}
+	literal, re := compilePattern(pattern)
+	prefix := s.wireTopic(literal)
+	err := s.addPrefixes(prefix)
+	if err != nil {
+		return fmt.Errorf("cannot subscribe to pattern %s: %w", pattern, err)
+	}
+	id := s.index.Insert(literal, re.MatchString, s.use(pattern))
+	s.patterns[pattern] = subPattern{prefix: prefix, id: id}
+	return nil
+}
+
+// UnsubscribePattern removes a pattern subscription. Like Unsubscribe, it
+// does nothing and returns nil if the subscriber is not subscribed to the
+// pattern.
+func (s *Subscriber) UnsubscribePattern(pattern string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.patterns[pattern]
+	if !ok {
+		return nil
+	}
+	delete(s.patterns, pattern)
+	s.index.Remove(p.id)
+	s.unuse(pattern)
+	s.forgetUnwanted()
+	err := s.removePrefixes(p.prefix)
+	if err != nil {
+		return fmt.Errorf("cannot unsubscribe from pattern %s: %w", pattern, err)
+	}
+	return nil
+}
+
+// Filtered returns the number of messages that the subscriber dropped
+// because they matched neither a topic nor a pattern, or because the
+// filter rejected them (see SetFilter).
+func (s *Subscriber) Filtered() uint64 {
+	return atomic.LoadUint64(&s.filtered)
+}
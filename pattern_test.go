@@ -0,0 +1,66 @@
+package pubsub
+
+import "testing"
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern, prefix string
+		match, noMatch  []string
+	}{
+		{"sports.*.scores", "sports.",
+			[]string{"sports.tennis.scores", "sports..scores"},
+			[]string{"sports.tennis.live.scores", "sports.tennis", "sports.tennis.scores.eu", "sports/tennis/scores"}},
+		{"sports.*", "sports.",
+			[]string{"sports.tennis", "sports."},
+			[]string{"sports.tennis.scores", "sports", "sportsX"}},
+		{"*.scores", "",
+			[]string{"tennis.scores", ".scores"},
+			[]string{"sports.tennis.scores", "scores", "tennis/scores"}},
+		{"*", "",
+			[]string{"", "tennis"},
+			[]string{"sports.tennis", "sports/tennis"}},
+		{"sp*s/*", "sp",
+			[]string{"sports/tennis", "sps/"},
+			[]string{"spa/tennis", "sp.rts/tennis"}},
+		{"a+b.(c)", "a+b.(c)",
+			[]string{"a+b.(c)"},
+			[]string{"aab.(c)", "a+b.c", "a+b.(c).d"}},
+	}
+	for _, tt := range tests {
+		prefix, re := compilePattern(tt.pattern)
+		if prefix != tt.prefix {
+			t.Errorf("prefix of %q = %q, want %q", tt.pattern, prefix, tt.prefix)
+		}
+		for _, topic := range tt.match {
+			if !re.MatchString(topic) {
+				t.Errorf("%q does not match %q", tt.pattern, topic)
+			}
+		}
+		for _, topic := range tt.noMatch {
+			if re.MatchString(topic) {
+				t.Errorf("%q matches %q", tt.pattern, topic)
+			}
+		}
+	}
+}
+
+// A pattern with an empty literal prefix receives all messages and drops
+// those that do not match.
+func TestSubscribePatternEmptyPrefix(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "ping")
+	if err := s.SubscribePattern("*.scores"); err != nil {
+		t.Fatal(err)
+	}
+	waitFlow(t, p, s)
+	filtered := s.Filtered()
+
+	publishAll(t, p, "sports.tennis.scores", "news", "tennis.scores")
+	if msg := receiveTopics(t, s, 1)[0]; msg.Topic != "tennis.scores" {
+		t.Errorf("received %s, want tennis.scores", msg.Topic)
+	}
+	if n := s.Filtered() - filtered; n != 2 {
+		t.Errorf("filtered %d messages, want 2", n)
+	}
+}
@@ -0,0 +1,176 @@
+package pubsub
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/go-mangos/mangos"
+)
+
+// With several publishers, it can be useful to know which connection a
+// message physically arrived on. Mangos tracks this per message, so we can
+// pass it on. The same information is available when a connection comes
+// or goes, so the two can be correlated.
+
+// PeerInfo describes the connection a message arrived on.
+type PeerInfo struct {
+	// Transport is the URL scheme of the connection, like "tcp" or "ipc".
+	Transport string
+
+	// RemoteAddr and LocalAddr are the addresses of both ends of the
+	// connection. They are nil if the transport does not report them
+	// (ipc, for example).
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+}
+
+// PeerEvent tells whether a connection was established or lost.
+type PeerEvent int
+
+const (
+	// PeerConnected means that the subscriber has connected to a
+	// publisher, for the first time or again.
+	PeerConnected PeerEvent = iota
+
+	// PeerDisconnected means that a connection to a publisher was
+	// closed or lost.
+	PeerDisconnected
+)
+
+// ConnState is the state of a subscriber's connection to its publisher.
+type ConnState int
+
+const (
+	// Connecting means that the subscriber has not been connected yet.
+	Connecting ConnState = iota
+
+	// Connected means that the subscriber is connected.
+	Connected
+
+	// Reconnecting means that the connection was lost and the subscriber
+	// dials again, with increasing delays (see Options.ReconnectTime).
+	// Receiving is not affected: Receive times out as during any other
+	// quiet period, and messages resume once the connection is back.
+	Reconnecting
+)
+
+func (c ConnState) String() string {
+	switch c {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+// State returns the current state of the subscriber's connection.
+func (s *Subscriber) State() ConnState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// OnStateChange sets a function that is called whenever the connection
+// state changes. Like the function set with OnPeerEvent, it is called from
+// the receiving goroutines of Mangos and must return quickly.
+func (s *Subscriber) OnStateChange(fn func(state ConnState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onState = fn
+}
+
+// OnPeerEvent sets a function that is called whenever the subscriber
+// connects to or loses a publisher. It is called from the receiving
+// goroutines of Mangos, so it must return quickly. Connections made before
+// OnPeerEvent is called are not reported.
+func (s *Subscriber) OnPeerEvent(fn func(event PeerEvent, peer *PeerInfo)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onPeer = fn
+}
+
+// WaitConnected waits until the subscriber is connected to a publisher, or
+// until ctx is done. NewSubscriber returns before the connection is made,
+// and a publisher does not keep messages for subscribers that are not
+// connected yet. A subscriber that must not miss the first messages can
+// call WaitConnected and then tell the publisher that it is ready.
+func (s *Subscriber) WaitConnected(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		connected, ch := s.peers > 0, s.peersCh
+		s.mu.Unlock()
+		if connected {
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// portHook reports connection changes to reportDisconnect and to the
+// functions set with OnPeerEvent and OnStateChange.
+func (s *Subscriber) portHook(action mangos.PortAction, port mangos.Port) bool {
+	s.mu.Lock()
+	reportDisconnect(action, port, s.closed)
+	fn := s.onPeer
+	switch action {
+	case mangos.PortActionAdd:
+		s.peers++
+		s.forgetPublisher(port.Address())
+	case mangos.PortActionRemove:
+		s.peers--
+	}
+	close(s.peersCh)
+	s.peersCh = make(chan struct{})
+	state := Reconnecting
+	if s.peers > 0 {
+		state = Connected
+	}
+	if state == Connected && s.state == Reconnecting {
+		s.recv.metrics.reconnected()
+	}
+	changed := state != s.state && !s.closed
+	s.state = state
+	onState := s.onState
+	s.mu.Unlock()
+
+	if changed && onState != nil {
+		onState(state)
+	}
+	if fn == nil {
+		return true
+	}
+	switch action {
+	case mangos.PortActionAdd:
+		fn(PeerConnected, peerInfo(port))
+	case mangos.PortActionRemove:
+		fn(PeerDisconnected, peerInfo(port))
+	}
+	return true
+}
+
+// peerInfo collects what the port knows about its connection. It returns
+// nil if there is no port.
+func peerInfo(port mangos.Port) *PeerInfo {
+	if port == nil {
+		return nil
+	}
+	p := &PeerInfo{}
+	if i := strings.Index(port.Address(), "://"); i >= 0 {
+		p.Transport = port.Address()[:i]
+	}
+	if a, err := port.GetProp(mangos.PropRemoteAddr); err == nil {
+		p.RemoteAddr, _ = a.(net.Addr)
+	}
+	if a, err := port.GetProp(mangos.PropLocalAddr); err == nil {
+		p.LocalAddr, _ = a.(net.Addr)
+	}
+	return p
+}
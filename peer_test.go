@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+// peerEvent is an event that OnPeerEvent reported.
+type peerEvent struct {
+	event PeerEvent
+	peer  *PeerInfo
+}
+
+// nextPeerEvent returns the next event from events.
+func nextPeerEvent(t *testing.T, events <-chan peerEvent) peerEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no peer event")
+		return peerEvent{}
+	}
+}
+
+// Messages and peer events describe the same TCP connection, which ends
+// at the address that the publisher listens on.
+func TestPeerTCP(t *testing.T) {
+	p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+	url := p.Addr()
+	s := newTestSubscriber(t, url, Options{}, "ping")
+	events := make(chan peerEvent, 10)
+	s.OnPeerEvent(func(event PeerEvent, peer *PeerInfo) {
+		events <- peerEvent{event, peer}
+	})
+	waitFlow(t, p, s)
+	publishAll(t, p, "ping")
+	msg := receiveN(t, s, 1)[0]
+	if msg.Peer == nil || msg.Peer.Transport != "tcp" || msg.Peer.RemoteAddr == nil || msg.Peer.LocalAddr == nil {
+		t.Fatalf("message peer %+v, want a TCP connection", msg.Peer)
+	}
+	if want := "tcp://" + msg.Peer.RemoteAddr.String(); want != url {
+		t.Errorf("message from %s, want %s", want, url)
+	}
+
+	p.Close()
+	e := nextPeerEvent(t, events)
+	if e.event != PeerDisconnected || e.peer.RemoteAddr.String() != msg.Peer.RemoteAddr.String() ||
+		e.peer.LocalAddr.String() != msg.Peer.LocalAddr.String() {
+		t.Errorf("event %v for %+v, want PeerDisconnected for %+v", e.event, e.peer, msg.Peer)
+	}
+
+	p = newTestPublisher(t, url, Options{})
+	e = nextPeerEvent(t, events)
+	if e.event != PeerConnected || e.peer.Transport != "tcp" || "tcp://"+e.peer.RemoteAddr.String() != url {
+		t.Fatalf("event %v for %+v, want PeerConnected to %s", e.event, e.peer, url)
+	}
+	waitFlow(t, p, s)
+	publishAll(t, p, "ping")
+	msg = receiveN(t, s, 1)[0]
+	if msg.Peer.LocalAddr.String() != e.peer.LocalAddr.String() || msg.Peer.RemoteAddr.String() != e.peer.RemoteAddr.String() {
+		t.Errorf("message over %v to %v, want the new connection %v to %v",
+			msg.Peer.LocalAddr, msg.Peer.RemoteAddr, e.peer.LocalAddr, e.peer.RemoteAddr)
+	}
+}
+
+// After the publisher restarts, the subscriber dials again within
+// MaxReconnectTime and receives from the new publisher.
+func TestReconnect(t *testing.T) {
+	p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+	url := p.Addr()
+	s := newTestSubscriber(t, url, Options{ReconnectTime: 10 * time.Millisecond, MaxReconnectTime: 40 * time.Millisecond}, "a", "ping")
+	states := make(chan ConnState, 10)
+	s.OnStateChange(func(state ConnState) { states <- state })
+	waitFlow(t, p, s)
+
+	nextState := func(want ConnState) {
+		t.Helper()
+		select {
+		case state := <-states:
+			if state != want || s.State() != want {
+				t.Fatalf("state %v, State() = %v; want %v", state, s.State(), want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no change to %v", want)
+		}
+	}
+	p.Close()
+	nextState(Reconnecting)
+	time.Sleep(200 * time.Millisecond) // several failed dials
+	restart := time.Now()
+	p = newTestPublisher(t, url, Options{})
+	nextState(Connected)
+	if d := time.Since(restart); d > 500*time.Millisecond {
+		t.Errorf("reconnected %v after the restart, want at most about MaxReconnectTime", d)
+	}
+	waitFlow(t, p, s)
+	publishAll(t, p, "a")
+	if msg := receiveTopics(t, s, 1)[0]; msg.Topic != "a" {
+		t.Errorf("received %s after reconnecting, want a", msg.Topic)
+	}
+	if n := s.Metrics().Reconnects; n != 1 {
+		t.Errorf("Metrics().Reconnects = %d, want 1", n)
+	}
+}
@@ -0,0 +1,45 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// For high-volume feeds, protocol buffers are more compact than JSON.
+// The binary framing passes the marshaled bytes through untouched, and the
+// topic stays a plain prefix, so subscriptions work as usual.
+
+// ProtoCodec marshals protocol buffer messages. Values must implement
+// proto.Message.
+var ProtoCodec Codec = protoCodec{}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "proto" }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// PublishProto marshals m and sends it to all subscribers of topic.
+func (p *Publisher) PublishProto(topic string, m proto.Message) error {
+	return p.publishWith(ProtoCodec, topic, m)
+}
+
+// ReceiveProto is like ReceiveValue with ProtoCodec.
+func (s *Subscriber) ReceiveProto(m proto.Message) (topic string, err error) {
+	return s.receiveWith(ProtoCodec, m)
+}
@@ -0,0 +1,90 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Protocol buffer messages arrive as they were sent, well-known types as
+// well as nested structures.
+func TestProtoRoundTrip(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "orders", "ping")
+	waitFlow(t, p, s)
+
+	order, err := structpb.NewStruct(map[string]interface{}{
+		"id":    "A-17",
+		"items": []interface{}{"tea", "cups"},
+		"total": map[string]interface{}{"amount": 12.5, "currency": "EUR"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := []proto.Message{wrapperspb.String("first order"), order, wrapperspb.Int64(-42)}
+	for _, m := range sent {
+		if err := p.PublishProto("orders", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range sent {
+		got := want.ProtoReflect().New().Interface()
+		topic, err := receiveProto(s, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if topic != "orders" || !proto.Equal(got, want) {
+			t.Errorf("received %s %v, want orders %v", topic, got, want)
+		}
+	}
+}
+
+// A payload that is not a valid protocol buffer message is a DecodeError,
+// and the subscriber goes on receiving.
+func TestProtoMalformed(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "orders", "ping")
+	waitFlow(t, p, s)
+
+	// Field 1, length 5, but only one byte follows.
+	bad := []byte{0x0a, 0x05, 'x'}
+	if err := p.PublishMessage(Message{Topic: "orders", Payload: bad, Codec: "proto"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishProto("orders", wrapperspb.String("next")); err != nil {
+		t.Fatal(err)
+	}
+
+	var v wrapperspb.StringValue
+	_, err := receiveProto(s, &v)
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("ReceiveProto = %v, want a DecodeError", err)
+	}
+	if derr.Topic != "orders" || string(derr.Excerpt) != string(bad) {
+		t.Errorf("DecodeError has topic %s and excerpt %q, want orders and %q", derr.Topic, derr.Excerpt, bad)
+	}
+	if errors.Is(err, ErrCodecMismatch) {
+		t.Error("malformed payload reported as a codec mismatch")
+	}
+	if _, err := receiveProto(s, &v); err != nil || v.GetValue() != "next" {
+		t.Errorf("after the malformed payload: %q, %v; want next", v.GetValue(), err)
+	}
+}
+
+// receiveProto is ReceiveProto that skips pings that were still underway
+// after waitFlow.
+func receiveProto(s *Subscriber, m proto.Message) (string, error) {
+	for {
+		topic, err := s.ReceiveProto(m)
+		if topic != "ping" {
+			return topic, err
+		}
+	}
+}
@@ -87,207 +87,1206 @@ Now you can import Mangos into your .go file.
 ## The code
 */
 
-// ### Globals and imports
-package main
+// Package pubsub implements a small publisher/subscriber library on top of
+// the PUB and SUB protocols of Mangos.
+//
+// A Publisher listens on a URL and sends messages by topic; a Subscriber
+// dials into a publisher and receives the messages of the topics it has
+// subscribed to:
+//
+//	pub, err := pubsub.NewPublisher("tcp://localhost:56565")
+//	...
+//	sub, err := pubsub.NewSubscriber("tcp://localhost:56565", "Weather")
+//	...
+//	err = pub.Publish("Weather", "Sunny")
+//	...
+//	topic, msg, err := sub.Receive()
+//
+// The demo from the article lives in cmd/pubsub.
+//
+// The exported identifiers of this package are its whole public API; the
+// wire encoding and other machinery live in internal packages. The API is
+// versioned (see Version) and recorded in api.txt.
+package pubsub
 
+// ### Globals and imports
 import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"os/exec"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	// For this example, we need the PUBSUB protocol as well as the ipc and tcp transports.
+	// For this example, we need the PUBSUB protocol as well as a couple of transports.
 	// Unlike the PAIR protocol, PUBSUB actually consists of two protocols, PUB and SUB.
 	"github.com/go-mangos/mangos"
 	"github.com/go-mangos/mangos/protocol/pub"
 	"github.com/go-mangos/mangos/protocol/sub"
+	"github.com/go-mangos/mangos/transport/inproc"
 	"github.com/go-mangos/mangos/transport/ipc"
 	"github.com/go-mangos/mangos/transport/tcp"
+	"github.com/go-mangos/mangos/transport/tlstcp"
+	"github.com/go-mangos/mangos/transport/ws"
+	"github.com/go-mangos/mangos/transport/wss"
+
+	"github.com/appliedgo/pubsub/internal/topicindex"
+	"github.com/appliedgo/pubsub/internal/wire"
 )
 
-// newPublisherSocket creates a new pub socket from the passed-in URL, and starts
-// listening on this socket.
-func newPublisherSocket(url string) (mangos.Socket, error) {
+// newPublisherSocket creates a new pub socket from the passed-in URLs, and starts
+// listening on this socket at each of them. It also returns the addresses it
+// listens on, which differ from the URLs that have port 0. If hook is not
+// nil, it is called whenever a subscriber connects or disconnects.
+func newPublisherSocket(urls []string, opts Options, hook mangos.PortHook) (mangos.Socket, []string, error) {
+	if len(urls) == 0 {
+		return nil, nil, errors.New("no URL to listen on")
+	}
+	if err := checkTLS(urls, opts); err != nil {
+		return nil, nil, err
+	}
 	socket, err := pub.NewSocket()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	addTransports(socket)
+	if hook != nil {
+		socket.SetPortHook(hook)
 	}
-	// Allow the use of either TCP or IPC.
-	socket.AddTransport(ipc.NewTransport())
-	socket.AddTransport(tcp.NewTransport())
 
-	// Start listening.
-	err = socket.Listen(url)
-	if err != nil {
-		return nil, err
+	// Start listening. The TLS configuration, if any, goes to the transport
+	// (see options.go). If the address is in use, listen tries again (see
+	// listen.go). As it is one socket, each message goes out to the
+	// subscribers of all endpoints.
+	var addrs []string
+	var errs endpointErrors
+	for _, url := range urls {
+		topts, err := transportOptions(url, opts, false)
+		var addr string
+		if err == nil {
+			addr, err = listen(socket, url, topts, opts)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot listen on %s: %w", url, err))
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if err := errs.errorOf(); err != nil {
+		socket.Close()
+		return nil, nil, err
 	}
 
-	return socket, nil
+	return socket, addrs, nil
 }
 
-// newSubscriberSocket creates a new sub socket from the passed-in URL, and dials
-// into this socket.
-func newSubscriberSocket(url string) (mangos.Socket, error) {
+// addTransports allows the use of TCP, TCP with TLS, WebSocket (plain or
+// secure), IPC, or inproc, which connects sockets within the same process.
+func addTransports(socket mangos.Socket) {
+	for _, t := range transports() {
+		socket.AddTransport(t)
+	}
+}
+
+func transports() []mangos.Transport {
+	return []mangos.Transport{
+		inproc.NewTransport(),
+		ipc.NewTransport(),
+		tcp.NewTransport(),
+		tlstcp.NewTransport(),
+		ws.NewTransport(),
+		wss.NewTransport(),
+	}
+}
+
+// newSubscriberSocket creates a new sub socket from the passed-in URLs, and dials
+// into each of them. hook is called whenever a connection comes or goes.
+func newSubscriberSocket(urls []string, opts Options, hook mangos.PortHook) (mangos.Socket, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no URL to dial into")
+	}
+	if err := checkTLS(urls, opts); err != nil {
+		return nil, err
+	}
 	socket, err := sub.NewSocket()
 	if err != nil {
 		return nil, err
 	}
-	socket.AddTransport(ipc.NewTransport())
-	socket.AddTransport(tcp.NewTransport())
-	err = socket.Dial(url)
+	for _, t := range transports() {
+		socket.AddTransport(limitTransport{t})
+	}
+
+	// Mangos silently drops the connection when a peer sends a message larger
+	// than OptionMaxRecvSize, and then redials. From the outside, this looks
+	// like a random reconnect. To make such cases easier to diagnose, we set
+	// the limit explicitly, and the transports note an oversized message,
+	// so that the hook can name the limit when it reports the lost
+	// connection (see recvlimit.go).
+	err = socket.SetOption(mangos.OptionMaxRecvSize, maxRecvSize)
 	if err != nil {
 		return nil, err
 	}
+	socket.SetPortHook(hook)
+
+	// If the connection is lost, Mangos redials with an increasing delay
+	// (see options.go).
+	first, max := opts.reconnectTimes()
+	err = socket.SetOption(mangos.OptionReconnectTime, first)
+	if err == nil {
+		err = socket.SetOption(mangos.OptionMaxReconnectTime, max)
+	}
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+
+	// All connections share the socket, and with it the subscriptions.
+	// Mangos dials in the background and retries until it succeeds, so
+	// only a URL that cannot work at all fails here. Unless opts.StrictDial
+	// is set, such a URL is logged and skipped, as long as another one
+	// works.
+	var errs endpointErrors
+	for _, url := range urls {
+		topts, err := transportOptions(url, opts, true)
+		if err == nil {
+			err = socket.DialOptions(url, topts)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot dial into %s: %w", url, err))
+		}
+	}
+	if len(errs) > 0 && (opts.StrictDial || len(errs) == len(urls)) {
+		socket.Close()
+		return nil, errs.errorOf()
+	}
+	for _, err := range errs {
+		logger().Warn("Skipping URL", "error", err)
+	}
 	return socket, nil
 }
 
+// maxRecvSize is the largest message a subscriber accepts. (This is also the
+// Mangos default.)
+const maxRecvSize = 1024 * 1024
+
+// reportDisconnect logs when a connection to a publisher goes away,
+// unless closing is true because the socket is being closed. If the
+// publisher sent a message larger than maxRecvSize, the log names the
+// limit.
+func reportDisconnect(action mangos.PortAction, port mangos.Port, closing bool) {
+	if action != mangos.PortActionRemove || closing {
+		return
+	}
+	remote := port.Address()
+	if addr, err := port.GetProp(mangos.PropRemoteAddr); err == nil {
+		remote = fmt.Sprint(addr)
+	}
+	if tooLong(port) {
+		logger().Warn("Lost connection after a message that is too long", "remote", remote, "max_recv_size", maxRecvSize)
+		return
+	}
+	logger().Warn("Lost connection", "remote", remote)
+}
+
 // Subscribing in nanomsg/Mangos is as simple as setting a socket option.
-// The topic is a simple, plain string.
+// The socket then passes on only those messages that start with the given prefix.
 // (For a list of available socket options, see the [Mangos API documentation](https://godoc.org/github.com/go-mangos/mangos#pkg-constants).)
-func subscribe(socket mangos.Socket, topic string) error {
-	err := socket.SetOption(mangos.OptionSubscribe, []byte(topic))
-	if err == nil {
-		// A second socket option avoids that clients wait forever when they receive no messages.
-		err = socket.SetOption(mangos.OptionRecvDeadline, 10*time.Second)
+func subscribe(socket mangos.Socket, prefix string) error {
+	return socket.SetOption(mangos.OptionSubscribe, []byte(prefix))
+}
+
+// Unsubscribing works the same way, through another socket option. Mangos
+// returns an error if the socket is not subscribed to the prefix.
+func unsubscribe(socket mangos.Socket, prefix string) error {
+	return socket.SetOption(mangos.OptionUnsubscribe, []byte(prefix))
+}
+
+// Topics are hierarchical: "finance.eu" has children like "finance.eu.bonds".
+// A subscriber to "finance.eu" gets the topic itself and all of its children,
+// but not "finance.europe", which a plain prefix would match, too. Therefore,
+// a topic is turned into several prefixes: the topic followed by each
+// character that can end a segment. These are the separators "." and "/",
+// and the NUL or "|" that ends the topic in a frame.
+// The empty topic is the exception: it matches all messages.
+// The topic and the prefixes are in wire form; see internal/wire for why
+// non-ASCII topics need normalizing.
+func topicPrefixes(t string) []string {
+	if t == "" {
+		return []string{""}
+	}
+	return []string{t + ".", t + "/", t + "\x00", t + "|"}
+}
+
+// matchesTopic tells whether a frame belongs to a topic in wire form or to
+// one of its children. It is the same check that the prefixes from
+// topicPrefixes make Mangos do, except that a "|" only ends the topic of a
+// text frame: a binary frame of the topic "a|b" starts with the prefix
+// "a|", but does not belong to "a".
+func matchesTopic(raw []byte, t string) bool {
+	if t == "" {
+		return true
+	}
+	if len(raw) <= len(t) || !bytes.HasPrefix(raw, []byte(t)) {
+		return false
+	}
+	switch raw[len(t)] {
+	case '.', '/', 0:
+		return true
+	case '|':
+		return !wire.IsBinary(raw)
+	}
+	return false
+}
+
+// topicKey returns the topic of a frame in wire form, followed by a NUL
+// if the frame ends the topic, which a text frame does with "|". A text
+// frame without a "|" has no topic; its key is the whole frame. Looking up
+// the key in an index of belowTopic registrations gives the same result
+// as matchesTopic for each topic.
+func topicKey(raw []byte) string {
+	var end int
+	if wire.IsBinary(raw) {
+		end = bytes.IndexByte(raw, 0)
+	} else {
+		end = bytes.IndexAny(raw, "|\x00")
+	}
+	if end < 0 {
+		return string(raw)
+	}
+	return string(raw[:end]) + "\x00"
+}
+
+// belowTopic returns the function that tells whether a topic key that
+// starts with t belongs to t or to one of its children. It returns nil,
+// which matches everything, for the empty topic.
+func belowTopic(t string) func(string) bool {
+	if t == "" {
+		return nil
+	}
+	return func(key string) bool {
+		if len(key) == len(t) {
+			return false
+		}
+		switch key[len(t)] {
+		case '.', '/', 0:
+			return true
+		}
+		return false
 	}
-	return err
 }
 
 // To publish to subscribers of a specific topic, simply prepend the topic to the message.
-// A pipe character (`|`) separates the topic from the message. This is only done for better
-// readability. In 'real' scenarios, the receiver would just strip away the topic prefix and
-// pass the rest of the message over to the next processing stage.
-func publish(socket mangos.Socket, topic, message string) error {
-	err := socket.Send([]byte(fmt.Sprintf("%s|%s", topic, message)))
-	return err
+// Originally, a pipe character (`|`) separated the topic from the message, but that
+// breaks as soon as the topic could be confused with the message content. Today, a NUL
+// byte and the length of the topic end it by default, and the topic may contain "|".
+// (See internal/wire for the details of both formats.)
+// The receiver then strips away the topic prefix and passes the rest of the message
+// over to the next processing stage.
+// As far as opts asks for it, the payload is compressed (see compress.go) and
+// encrypted (see crypt.go), and the frame is signed (see auth.go).
+func encodeMessage(format wire.Format, msg Message, opts *sendOptions) ([]byte, error) {
+	compressAbove := opts.compressAbove
+	if format == wire.Text {
+		if opts.aead != nil || opts.authKey != nil {
+			return nil, fmt.Errorf("%w: text frames cannot carry encrypted or signed payloads", ErrBadEnvelope)
+		}
+		// Old subscribers would not understand a header anyway.
+		msg.Codec, msg.Seq = "", 0
+		compressAbove = 0
+	}
+	var encoding string
+	if compressAbove > 0 && len(msg.Payload) > compressAbove {
+		if z := compress(msg.Payload); z != nil {
+			msg.Payload, encoding = z, encodingGzip
+		}
+	}
+	f := wire.Frame{
+		Format:  format,
+		Topic:   wire.Topic(msg.Topic, opts.asciiTopics),
+		Header:  wire.Header{Codec: msg.Codec, Seq: msg.Seq, Encoding: encoding},
+		Payload: msg.Payload,
+	}
+	if opts.aead != nil {
+		var err error
+		f, err = seal(opts.aead, f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.authKey != nil {
+		mac, err := sign(opts.authKey, f)
+		if err != nil {
+			return nil, err
+		}
+		f.Header.MAC = string(mac)
+	}
+	return wire.Encode(f)
+}
+
+// sendOptions control how publish turns messages into frames.
+type sendOptions struct {
+	// Payloads of more than compressAbove bytes are compressed; see
+	// compress.go. Zero disables compression.
+	compressAbove int
+
+	// aead encrypts the payloads; see crypt.go. It is nil without a
+	// payload key.
+	aead cipher.AEAD
+
+	// authKey signs the frames; see auth.go. It is nil without an
+	// authentication key.
+	authKey []byte
+
+	// asciiTopics percent-encodes the topics; see Options.ASCIITopics.
+	asciiTopics bool
 }
 
 // Receiving is nothing more than calling the socket's Recv() method. The magic happens
 // through the socket option "OptionSubscribe" we set earlier. This option makes the
 // socket ignore any message that does not start with the desired topic(s).
-func receive(socket mangos.Socket) (string, error) {
-	message, err := socket.Recv()
-	return string(message), err
+// What arrives is the raw frame, which parseMessage takes apart again.
+// We use RecvMsg() rather than Recv() as it also tells which connection the
+// message arrived on.
+// Receive timeouts are left to the callers to count, as receiveContext
+// times out on purpose all the time.
+func receiveFrame(socket mangos.Socket, opts *receiveOptions) (Message, error) {
+	for {
+		if opts.halted != nil {
+			if err := opts.halted(); err != nil {
+				return Message{}, err
+			}
+		}
+		if opts.queued != nil {
+			if msg, ok := opts.queued(); ok {
+				opts.metrics.received(msg.Topic, 0)
+				if opts.arrived != nil {
+					opts.arrived(msg)
+				}
+				if opts.recorded != nil {
+					opts.recorded(msg)
+				}
+				return msg, nil
+			}
+		}
+		raw, err := socket.RecvMsg()
+		if err != nil {
+			err = socketError(err)
+			if err == ErrTimeout && opts.flush != nil && opts.flush() {
+				continue
+			}
+			if err != ErrTimeout {
+				opts.metrics.receiveFailed(err)
+			}
+			return Message{}, err
+		}
+		msg, err := parseMessage(raw.Body, opts)
+		if err == errAuth {
+			msg.Peer = peerInfo(raw.Port)
+			if opts.authFailed != nil {
+				opts.authFailed(msg)
+			}
+			continue
+		}
+		if err != nil {
+			opts.metrics.receiveFailed(err)
+			return Message{}, err
+		}
+		msg.Peer = peerInfo(raw.Port)
+		if opts.wanted != nil && !opts.wanted(raw.Body, msg) {
+			continue
+		}
+		if opts.sequence != nil && !opts.sequence(raw, msg) {
+			continue
+		}
+		opts.metrics.received(msg.Topic, len(raw.Body))
+		logger().Debug("Received", "topic", msg.Topic, "seq", msg.Seq, "bytes", len(raw.Body))
+		if opts.arrived != nil {
+			opts.arrived(msg)
+		}
+		if opts.recorded != nil {
+			opts.recorded(msg)
+		}
+		return msg, nil
+	}
 }
 
-// Now it is time to set up the server. Besides the socket URL we also pass a list of
-// topics that the server will use for sending messages.
-func runServer(url string, topics []string) {
-	// Create the publisher socket.
-	socket, err := newPublisherSocket(url)
-	if err != nil {
-		log.Fatalf("Cannot listen on %s: %s\n", url, err.Error())
+// receive is receiveFrame with the socket's receive deadline.
+func receive(socket mangos.Socket, opts *receiveOptions) (Message, error) {
+	msg, err := receiveFrame(socket, opts)
+	if err == ErrTimeout {
+		opts.metrics.receiveFailed(err)
 	}
+	return msg, err
+}
 
-	// Loop through the topics and send a message for each one. Repeat a couple of times.
-	for i := 0; i < 5; i++ {
-		for _, topic := range topics {
-			time.Sleep(1 * time.Second)
-			fmt.Printf("Publishing a message for topic %s\n", topic)
-			err = publish(socket, topic, fmt.Sprintf("Message for %s", topic))
-			if err != nil {
-				log.Fatalf("Cannot publish message for topic %s: %s\n", topic, err.Error())
+// receiveOptions control how receive turns frames into messages.
+type receiveOptions struct {
+	// textOnly disables framing detection; see parseMessage.
+	textOnly bool
+
+	// asciiTopics decodes percent-encoded topics; see
+	// Options.ASCIITopics.
+	asciiTopics bool
+
+	// aead decrypts encrypted payloads; see crypt.go. It is nil without
+	// a payload key.
+	aead cipher.AEAD
+
+	// authKey verifies the MACs of the frames, and receive skips frames
+	// that fail, after passing them to authFailed; see auth.go. It is nil
+	// without an authentication key.
+	authKey    []byte
+	authFailed func(msg Message)
+
+	// If wanted is set, receive skips messages for which it returns
+	// false. It gets the raw frame and the parsed message. Mangos filters
+	// incoming frames when they arrive, but frames that are already
+	// queued when a subscription ends still come through, and Mangos
+	// knows nothing about patterns.
+	wanted func(raw []byte, msg Message) bool
+
+	// If sequence is set, receive passes it each frame and its message,
+	// and skips the message if it returns false. See seq.go.
+	sequence func(raw *mangos.Message, msg Message) bool
+
+	// If halted is set, receive returns the error it returns, if any.
+	// See strict.go.
+	halted func() error
+
+	// If queued is set, receive returns the messages it has before it
+	// receives new ones. See replay.go.
+	queued func() (Message, bool)
+
+	// If flush is set, receive calls it when the receive deadline
+	// passes, and takes the messages it queues if it returns true. See
+	// reorder.go.
+	flush func() bool
+
+	// If arrived is set, receive passes it each message that it
+	// returns. See stale.go.
+	arrived func(msg Message)
+
+	// If recorded is set, receive passes it each message that it
+	// returns. See record.go.
+	recorded func(msg Message)
+
+	// metrics counts the received messages; see metrics.go. It may be
+	// nil.
+	metrics *metrics
+}
+
+// receivePollInterval bounds how long receiveContext takes to notice that
+// its context is done.
+const receivePollInterval = 100 * time.Millisecond
+
+// Recv() cannot be interrupted, except by the receive deadline. To make a
+// receive cancelable, receiveContext waits in short slices of at most
+// receivePollInterval and checks the context in between. The socket's own
+// receive deadline still applies: if it passes first, the result is
+// ErrTimeout, whereas a done context returns ctx.Err().
+// The deadline option is restored before returning.
+func receiveContext(ctx context.Context, socket mangos.Socket, opts *receiveOptions) (Message, error) {
+	var deadline time.Time
+	if v, err := socket.GetOption(mangos.OptionRecvDeadline); err == nil {
+		if d, ok := v.(time.Duration); ok && d > 0 {
+			deadline = time.Now().Add(d)
+			defer socket.SetOption(mangos.OptionRecvDeadline, d)
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return Message{}, err
+		}
+		slice := receivePollInterval
+		if d, ok := ctx.Deadline(); ok && time.Until(d) < slice {
+			slice = time.Until(d)
+		}
+		if !deadline.IsZero() && time.Until(deadline) < slice {
+			slice = time.Until(deadline)
+		}
+		if slice <= 0 {
+			// The context's timer may not have fired yet.
+			if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+				return Message{}, context.DeadlineExceeded
 			}
+			opts.metrics.receiveFailed(ErrTimeout)
+			return Message{}, ErrTimeout
+		}
+
+		err := socket.SetOption(mangos.OptionRecvDeadline, slice)
+		if err != nil {
+			return Message{}, err
+		}
+		msg, err := receiveFrame(socket, opts)
+		if err != ErrTimeout {
+			return msg, err
 		}
 	}
 }
 
-// Client setup is also easy.
-func runClient(name, url string, topics []string) {
-	// First, we create a subscriber socket.
-	socket, err := newSubscriberSocket(url)
-	if err != nil {
-		log.Fatalf("Cannot dial into %s: %s\n", url, err.Error())
+// parseMessage separates the topic from the payload and, as far as opts
+// asks for it, verifies the frame and decrypts and decompresses the
+// payload. Frames of both formats are accepted, unless opts.textOnly is
+// set. A message without a delimiter has no topic.
+//
+// For a frame that fails verification, parseMessage returns errAuth and
+// the message as it arrived.
+func parseMessage(raw []byte, opts *receiveOptions) (Message, error) {
+	f := wire.DecodeText(raw)
+	if !opts.textOnly {
+		var err error
+		f, err = wire.Decode(raw)
+		if err != nil {
+			return Message{}, err
+		}
 	}
-	// Then, we subscribe to the topics that were passed in as a parameter.
-	for _, topic := range topics {
-		err := subscribe(socket, topic)
+	authentic := opts.authKey == nil || verify(opts.authKey, f)
+	msg := Message{
+		Topic:   f.Topic,
+		Payload: f.Payload,
+		Codec:   f.Header.Codec,
+		Seq:     f.Header.Seq,
+		Repeat:  f.Header.Repeat,
+		Legacy:  f.Format == wire.Text,
+	}
+	if opts.asciiTopics {
+		// Percent-encoded topics are turned back into readable ones.
+		msg.Topic = wire.DecodeTopic(f.Topic)
+	}
+	if f.Header.Unsequenced {
+		// The number does not tell anything about this topic's stream.
+		msg.Seq = 0
+	}
+	if !authentic {
+		return msg, errAuth
+	}
+	if opts.aead != nil || f.Header.Nonce != "" {
+		var err error
+		msg.Payload, err = open(opts.aead, f)
 		if err != nil {
-			log.Fatalf("Cannot subscribe to topic %s: %s\n", topic, err.Error())
+			return Message{}, err
 		}
 	}
-	// Finally, we listen for new message and print out any that matches
-	// one of the topics we subscribed to.
-	for i := 0; i < 5*len(topics); i++ {
-		message, err := receive(socket)
+	if f.Header.Encoding != "" {
+		var err error
+		msg.Payload, err = decompress(f.Header.Encoding, msg.Payload)
 		if err != nil {
-			log.Fatalf("Error receiving message: %s\n", err.Error())
+			return Message{}, err
 		}
-		fmt.Printf("Client %s received: %s\n", name, message)
 	}
+	return msg, nil
 }
 
-// Putting it all together...
-func main() {
+// ### The library API
+//
+// The functions above are all we need for talking PubSub. To make them usable
+// from other packages, we wrap them into two small types.
+
+// Message is a message together with its topic.
+type Message struct {
+	Topic   string
+	Payload []byte
+
+	// Codec is the name of the codec that encoded the payload, or ""
+	// if unknown. The text framing cannot carry it. (See codec.go.)
+	Codec string
 
-	// The socket URL.
-	url := "tcp://localhost:56565"
+	// Seq is the number of a received message within its topic, counted
+	// by the publisher from 1, or 0 if the message has none. It is
+	// ignored when publishing. (See seq.go.)
+	Seq uint64
 
-	// Without parameters, the process starts as the server.
-	if len(os.Args) == 1 {
+	// Repeat is true if the message is a repetition of an earlier one by
+	// a last-value cache (see Options.LastValueCache). It is ignored when
+	// publishing.
+	Repeat bool
 
-		// First, spawn the clients.
-		// We use the `Cmd` type from the `os.exec` package to spawn the clients
-		// as subprocesses in a convenient way.
-		client1 := exec.Command("./pubsub", "C1", "Technology")
-		client1.Stdout = os.Stdout // Default is nil but we want to see what the clients say.
-		client1.Stderr = os.Stderr // Same here.
-		client2 := exec.Command("./pubsub", "C2", "Technology", "Weather")
-		client2.Stdout = os.Stdout
-		client2.Stderr = os.Stderr
-		client3 := exec.Command("./pubsub", "C3", "Finance")
-		client3.Stdout = os.Stdout
-		client3.Stderr = os.Stderr
-		fmt.Println("Starting client 1")
-		err := client1.Start() // Start the command and continue without waiting for the command to finish.
+	// Peer is the connection a received message arrived on, or nil if
+	// unknown. It is ignored when publishing.
+	Peer *PeerInfo
+
+	// Legacy is true if the message arrived in the text framing, the
+	// only one that v0.1.0 knew. It is ignored when publishing; see
+	// Publisher.SetFraming.
+	Legacy bool
+
+	// Vars holds the placeholder values of the topic template whose
+	// handler got the message, or nil (see Subscriber.HandleTemplate).
+	// It is ignored when publishing.
+	Vars map[string]string
+}
+
+// Publisher sends messages by topic to all connected subscribers.
+type Publisher struct {
+	socket  mangos.Socket
+	addrs   []string
+	format  wire.Format
+	codec   Codec       // see codec.go
+	lvc     *lastValues // see lvc.go; nil if disabled
+	archive *archive    // see replay.go; nil if disabled
+
+	// mu serializes publishing, so that the sequence numbers of a topic
+	// go out in order.
+	mu     sync.Mutex
+	seqs   map[string]uint64 // last sequence number per topic in wire form
+	closed bool              // set by Close
+
+	interceptors []PublishInterceptor // see middleware.go
+	metrics      *metrics             // see metrics.go
+	limiter      rateLimiter          // see ratelimit.go
+
+	send    sendOptions // see encodeMessage
+	journal *journal    // see journal.go; nil if disabled
+}
+
+// Framing selects how a Publisher separates the topic from the payload.
+// Subscribers understand both framings.
+type Framing int
+
+const (
+	// BinaryFraming ends the topic with a NUL byte and its length. The
+	// topic may contain "|", and the payload arbitrary bytes. This is
+	// the default.
+	BinaryFraming Framing = iota
+
+	// TextFraming separates topic and payload with "|", like v0.1.0
+	// did, so topics with "|" cannot be published. Use it while
+	// subscribers built from v0.1.0 are still around.
+	TextFraming
+)
+
+// SetFraming sets the framing of all subsequent messages. It must not be
+// called concurrently with Publish or PublishMessage.
+func (p *Publisher) SetFraming(f Framing) {
+	p.format = wire.Binary
+	if f == TextFraming {
+		p.format = wire.Text
+	}
+}
+
+// NewPublisher creates a publisher that listens on url, for example
+// "tcp://localhost:56565", "ws://localhost:56565/pubsub", or
+// "ipc:///tmp/pubsub.ipc". Within a single process, "inproc://demo" works,
+// too. TLS URLs ("tls+tcp://" and "wss://") need NewPublisherWithOptions.
+func NewPublisher(url string) (*Publisher, error) {
+	return NewPublisherWithOptions(url, Options{})
+}
+
+// NewPublisherWithOptions is like NewPublisher with additional options.
+func NewPublisherWithOptions(url string, opts Options) (*Publisher, error) {
+	return NewPublisherURLs([]string{url}, opts)
+}
+
+// NewPublisherURLs creates a publisher that listens on several URLs at
+// once, for example on "ipc:///tmp/pubsub.ipc" for local subscribers and on
+// "tcp://:56565" for remote ones. Every message goes out to the
+// subscribers of all URLs. If listening fails for any of the URLs, the
+// error names each of them, and no publisher is created.
+func NewPublisherURLs(urls []string, opts Options) (*Publisher, error) {
+	p := &Publisher{seqs: make(map[string]uint64), metrics: newMetrics()}
+	var err error
+	p.send.aead, err = newAEAD(opts)
+	if err != nil {
+		return nil, err
+	}
+	p.send.authKey = opts.AuthKey
+	p.send.asciiTopics = opts.ASCIITopics
+	p.limiter.set(opts.RateLimit, opts.RateBurst, opts.RateLimitMode)
+	var hook mangos.PortHook
+	if opts.LastValueCache {
+		p.lvc = newLastValues()
+		hook = p.lvc.portHook
+	}
+	socket, addrs, err := newPublisherSocket(urls, opts, hook)
+	if err != nil {
+		return nil, err
+	}
+	p.socket, p.addrs = socket, addrs
+	if p.lvc != nil {
+		p.lvc.setSocket(socket)
+	}
+	if opts.ReplayURL != "" {
+		p.archive, err = newArchive(opts.ReplayURL, opts)
+		if err != nil {
+			socket.Close()
+			return nil, err
+		}
+	}
+	if opts.Journal != "" {
+		p.journal, err = openJournal(opts.Journal, opts)
 		if err != nil {
-			log.Fatalf("Failed starting client1: %s", err.Error())
+			p.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Addr returns the URL that the publisher listens on, for subscribers to
+// dial into. For a URL with port 0, like "tcp://127.0.0.1:0", it has the
+// port that was picked. For other URLs, Addr returns the URL that was
+// passed to NewPublisher, or an equivalent one. For a publisher with
+// several URLs, Addr returns the first; see Addrs.
+func (p *Publisher) Addr() string {
+	return p.addrs[0]
+}
+
+// Addrs returns the URLs that the publisher listens on, in the order
+// passed to NewPublisherURLs, like Addr does for a single one.
+func (p *Publisher) Addrs() []string {
+	return append([]string(nil), p.addrs...)
+}
+
+// Publish sends message to all subscribers of topic.
+func (p *Publisher) Publish(topic, message string) error {
+	return p.publish(Message{Topic: topic, Payload: []byte(message)})
+}
+
+// PublishMessage sends msg.Payload to all subscribers of msg.Topic.
+// The topic must not contain a NUL byte. With TextFraming, it must not
+// contain a pipe character either.
+func (p *Publisher) PublishMessage(msg Message) error {
+	return p.publish(msg)
+}
+
+// publish passes msg through the interceptors, waits for the rate limit,
+// numbers it, records it in the journal, sends it, and, with a last-value
+// cache or a replay archive, remembers it. A message that cannot be
+// recorded is not sent.
+func (p *Publisher) publish(msg Message) error {
+	p.mu.Lock()
+	msg, err := p.intercept(msg)
+	p.mu.Unlock()
+	if errors.Is(err, ErrDropMessage) {
+		return nil
+	}
+	if err == nil {
+		err = p.limiter.take()
+	}
+	if err != nil {
+		p.metrics.published(msg.Topic, 0, err)
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		// Mangos may still take a message after Close.
+		p.metrics.published(msg.Topic, 0, ErrClosed)
+		return ErrClosed
+	}
+	t := wire.Topic(msg.Topic, p.send.asciiTopics)
+	msg.Seq = 0
+	if p.format == wire.Binary {
+		p.seqs[t]++
+		msg.Seq = p.seqs[t]
+	}
+	raw, err := encodeMessage(p.format, msg, &p.send)
+	if err == nil && p.journal != nil {
+		err = p.journal.add(time.Now(), msg)
+	}
+	if err != nil {
+		p.metrics.published(msg.Topic, 0, err)
+		return err
+	}
+	if p.archive != nil && msg.Seq != 0 {
+		p.archive.add(t, msg.Seq, raw)
+	}
+	if p.lvc != nil {
+		err = p.lvc.publish(t, raw)
+	} else {
+		err = socketError(p.socket.Send(raw))
+	}
+	p.metrics.published(msg.Topic, len(raw), err)
+	if err == nil {
+		logger().Debug("Published", "topic", msg.Topic, "seq", msg.Seq, "bytes", len(raw))
+	}
+	return err
+}
+
+// Close closes the publisher's socket. It first tries to send messages that
+// are still queued, for up to the socket's linger time of one second. For an
+// "ipc://" URL, closing removes the socket file. A journal is synced and
+// closed, too.
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	if p.lvc != nil {
+		p.lvc.close()
+	}
+	if p.archive != nil {
+		p.archive.close()
+	}
+	err := p.socket.Close()
+	if p.journal != nil {
+		if jerr := p.journal.close(); err == nil {
+			err = jerr
 		}
-		fmt.Println("Starting client 2")
-		err = client2.Start()
+	}
+	return err
+}
+
+// Subscriber receives messages for the topics it has subscribed to.
+type Subscriber struct {
+	// Accessed atomically; first fields for 64-bit alignment.
+	dropped       uint64
+	filtered      uint64
+	missed        uint64
+	authFailures  uint64
+	recordDropped uint64
+	late          uint64
+	violations    uint64
+
+	socket mangos.Socket
+	recv   receiveOptions
+	codec  Codec         // see codec.go
+	done   chan struct{} // closed by Close
+
+	// recordMu serializes RecordTo, see record.go.
+	recordMu sync.Mutex
+
+	// Replay of missed messages, see replay.go.
+	replay     mangos.Socket // REQ; nil without a replay URL
+	replayMu   sync.Mutex
+	autoReplay bool
+
+	// mu guards all fields below.
+	mu             sync.Mutex
+	prefixes       map[string]int                // socket subscriptions and their users
+	topics         map[string]topicindex.ID      // in wire form
+	topicIndex     *topicindex.Index             // of topics, see wanted
+	patterns       map[string]subPattern         // see pattern.go
+	index          *topicindex.Index             // of patterns
+	filter         func(Message) bool            // see filter.go
+	onPeer         func(PeerEvent, *PeerInfo)    // see peer.go
+	peers          int                           // number of connections
+	peersCh        chan struct{}                 // closed when peers changes
+	state          ConnState                     // see peer.go
+	onState        func(ConnState)               // see peer.go
+	seqs           map[seqKey]*seqState          // see seq.go
+	reorderWindow  int                           // see reorder.go
+	strictOrder    bool                          // see strict.go
+	orderAction    OrderAction                   // see strict.go
+	onViolation    func(Message, uint64)         // see strict.go
+	halted         bool                          // see strict.go
+	expect         map[string]*expectation       // see stale.go
+	critical       map[string]bool               // see stale.go
+	usage          map[string]*subUsage          // see usage.go
+	onStale        func(string, bool, time.Time) // see stale.go
+	onGap          func(string, uint64, uint64)  // see seq.go
+	backlog        []Message                     // replayed messages, see replay.go
+	handlers       []handler                     // see handler.go
+	templates      []templateHandler             // see handler.go
+	fallback       HandlerFunc                   // see handler.go
+	onHandlerError func(Message, error)          // see handler.go
+	middleware     []Middleware                  // see middleware.go
+	onAuthFailure  func(Message)                 // see auth.go
+	recorder       *recorder                     // see record.go
+
+	// State of the Messages() channel, see messages.go.
+	bufferSize int
+	overflow   OverflowPolicy
+	messages   chan Message
+	err        error
+	closed     bool
+}
+
+// NewSubscriber creates a subscriber that dials into the publisher at url
+// and subscribes to the given topics. The empty topic matches all messages,
+// including those that have no topic at all. TLS URLs ("tls+tcp://" and
+// "wss://") need NewSubscriberWithOptions.
+func NewSubscriber(url string, topics ...string) (*Subscriber, error) {
+	return NewSubscriberWithOptions(url, Options{}, topics...)
+}
+
+// NewSubscriberWithOptions is like NewSubscriber with additional options.
+func NewSubscriberWithOptions(url string, opts Options, topics ...string) (*Subscriber, error) {
+	return NewSubscriberURLs([]string{url}, opts, topics...)
+}
+
+// NewSubscriberURLs creates a subscriber that dials into several
+// publishers at once and merges their messages. For example, if several
+// producers publish the same topics from different hosts, a single
+// subscriber receives them all. The topics apply to all publishers.
+//
+// If some of the URLs cannot be dialed at all (an unknown scheme, for
+// example), they are logged and skipped, unless opts.StrictDial is set.
+// If none can be dialed, NewSubscriberURLs fails.
+func NewSubscriberURLs(urls []string, opts Options, topics ...string) (*Subscriber, error) {
+	s := &Subscriber{
+		prefixes:   make(map[string]int),
+		topics:     make(map[string]topicindex.ID),
+		topicIndex: topicindex.New(),
+		patterns:   make(map[string]subPattern),
+		index:      topicindex.New(),
+		bufferSize: DefaultBufferSize,
+		peersCh:    make(chan struct{}),
+		seqs:       make(map[seqKey]*seqState),
+		expect:     make(map[string]*expectation),
+		critical:   make(map[string]bool),
+		usage:      make(map[string]*subUsage),
+		done:       make(chan struct{}),
+	}
+	s.recv.wanted = s.wanted
+	s.recv.sequence = s.checkSeq
+	s.recv.queued = s.nextQueued
+	s.recv.flush = s.flushHeld
+	s.recv.halted = s.checkHalted
+	s.recv.recorded = s.record
+	s.recv.arrived = s.arrived
+	s.recv.metrics = newMetrics()
+	var err error
+	s.recv.aead, err = newAEAD(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.recv.authKey = opts.AuthKey
+	s.recv.authFailed = s.authFailed
+	s.recv.asciiTopics = opts.ASCIITopics
+	socket, err := newSubscriberSocket(urls, opts, s.portHook)
+	if err != nil {
+		return nil, err
+	}
+	s.socket = socket
+	s.reorderWindow = opts.ReorderWindow
+	if opts.ReplayURL != "" {
+		s.replay, err = newReplaySocket(opts.ReplayURL, opts)
 		if err != nil {
-			log.Fatalf("Failed starting client2: %s", err.Error())
+			socket.Close()
+			return nil, err
 		}
-		fmt.Println("Starting client 3")
-		err = client3.Start()
+		s.autoReplay = opts.AutoReplay
+	}
+	// A receive deadline keeps clients from waiting forever when no
+	// messages arrive.
+	err = s.SetRecvDeadline(DefaultRecvDeadline)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	for _, topic := range topics {
+		err := s.Subscribe(topic)
 		if err != nil {
-			log.Fatalf("Failed starting client3: %s", err.Error())
+			s.Close()
+			return nil, err
 		}
+	}
+	return s, nil
+}
 
-		// Start publishing.
-		fmt.Println("Starting the server")
-		runServer(url, []string{"Technology", "Weather", "Finance"})
+// Subscribe adds topic to the subscriber's topics. The subscriber then
+// receives messages for topic and for all topics below it, where "." and
+// "/" separate the levels: "finance.eu" receives "finance.eu" and
+// "finance.eu.bonds", but not "finance.europe". (Use SubscribePattern for
+// that.) Flat topics without separators simply match exactly.
+// Subscribing to a topic twice has no effect.
+func (s *Subscriber) Subscribe(topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.wireTopic(topic)
+	if _, ok := s.topics[t]; ok {
+		return nil
+	}
+	err := s.addPrefixes(topicPrefixes(t)...)
+	if err != nil {
+		return fmt.Errorf("cannot subscribe to topic %s: %w", topic, err)
+	}
+	s.topics[t] = s.topicIndex.Insert(t, belowTopic(t), s.use(topic))
+	return nil
+}
 
-		// Wait for all commands started with Start() to finish.
-		time.Sleep(1 * time.Second) // to ensure all clients have consumed the messages.
-		fmt.Println("Waiting for the clients to exit")
-		client1.Wait()
-		client2.Wait()
-		client2.Wait()
-		fmt.Println("Server ends.")
-	} else {
+// SubscribeAll subscribes to the empty topic, which matches every message.
+// Received messages still carry their topics. To stop receiving everything,
+// unsubscribe from the empty topic; other topics remain subscribed.
+func (s *Subscriber) SubscribeAll() error {
+	return s.Subscribe("")
+}
 
-		// One or more parameters means this process is a client.
-		fmt.Println(os.Args[1], "is starting.")
-		runClient(os.Args[1], url, os.Args[2:])
-		fmt.Println("Client", os.Args[1], "ends.")
+// Unsubscribe stops receiving messages for topic. Messages for topic that
+// were received but not yet read are discarded, unless another topic
+// matches them, too.
+// Only messages that are already in the Messages channel still arrive.
+//
+// Unsubscribing from a topic that the subscriber is not subscribed to does
+// nothing and returns nil. After the last topic is gone, the subscriber
+// stays connected but receives nothing.
+func (s *Subscriber) Unsubscribe(topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.wireTopic(topic)
+	id, ok := s.topics[t]
+	if !ok {
+		return nil
 	}
+	delete(s.topics, t)
+	s.topicIndex.Remove(id)
+	s.unuse(topic)
+	s.forgetUnwanted()
+	err := s.removePrefixes(topicPrefixes(t)...)
+	if err != nil {
+		return fmt.Errorf("cannot unsubscribe from topic %s: %w", topic, err)
+	}
+	return nil
 }
 
-/*
-Get this code from github:
+// wireTopic returns topic in wire form.
+func (s *Subscriber) wireTopic(topic string) string {
+	return wire.Topic(topic, s.recv.asciiTopics)
+}
 
-	go get -d github.com/appliedgo/pubsub
-	cd $GOPATH/src/github.com/appliedgo/pubsub
-	go build
-	./pubsub
+// Topics and patterns can share socket subscriptions: the pattern
+// "finance.eu.*" needs the prefix "finance.eu.", and so does the topic
+// "finance.eu". addPrefixes and removePrefixes count the users of each
+// prefix and only change the socket subscriptions when the first user comes
+// or the last one goes. s.mu must be held.
+func (s *Subscriber) addPrefixes(prefixes ...string) error {
+	for i, p := range prefixes {
+		if s.prefixes[p] == 0 {
+			err := subscribe(s.socket, p)
+			if err != nil {
+				s.removePrefixes(prefixes[:i]...)
+				return err
+			}
+		}
+		s.prefixes[p]++
+	}
+	return nil
+}
 
-(`go get -d` gets the code but does not install it into `$GOPATH/bin`. go build then builds the executable locally so that it would not end up between your other executables, especially if $GOPATH/bin is part of your $PATH.)
+func (s *Subscriber) removePrefixes(prefixes ...string) error {
+	var first error
+	for _, p := range prefixes {
+		s.prefixes[p]--
+		if s.prefixes[p] > 0 {
+			continue
+		}
+		delete(s.prefixes, p)
+		err := unsubscribe(s.socket, p)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
 
-As you have seen in the code for main(), the program spawns three child processes that take over the role of the clients. If everything works fine, you should then see the publisher send 15 messages to the clients, and the clients should then grab only the messages that they have subscribed to.
+// wanted tells whether a frame belongs to one of the subscribed topics,
+// or whether its topic matches one of the patterns, and whether it passes
+// the filter. Other messages are counted as filtered.
+//
+// Both the topics and the patterns are looked up in an index, so the cost
+// does not grow with the number of subscriptions. The topics are indexed
+// in wire form and looked up by the frame's topic key (see topicKey), the
+// patterns by the decoded topic.
+func (s *Subscriber) wanted(raw []byte, msg Message) bool {
+	s.mu.Lock()
+	filter := s.filter
+	s.mu.Unlock()
+	subscribed := s.topicIndex.Matches(topicKey(raw)) || s.index.Matches(msg.Topic)
+	if !subscribed || (filter != nil && !applyFilter(filter, msg)) {
+		atomic.AddUint64(&s.filtered, 1)
+		return false
+	}
+	return true
+}
 
-For additional fun, try tweaking some parameters. For example, comment out the last `time.Sleep()` statement in main(). Or have the clients expect more messages than the server sends, and see what happens!
+// arrived counts msg, which receive returns, for the subscriptions that
+// match it (see usage.go) and for the topics that it keeps fresh (see
+// stale.go).
+func (s *Subscriber) arrived(msg Message) {
+	now := time.Now()
+	s.mu.Lock()
+	s.countMatches(msg, now)
+	fresh := s.restartWatches(msg, now)
+	s.mu.Unlock()
+	s.reportFresh(fresh, now)
+}
 
-Have fun!
-*/
+// Receive waits for the next message and returns its topic and the message
+// text. A message without a topic delimiter is returned with an empty topic.
+// A malformed message is reported as ErrBadEnvelope, and one that cannot be
+// decrypted as ErrDecrypt; the next call continues with the next message.
+// If no message arrives within the receive deadline (see SetRecvDeadline),
+// Receive returns ErrTimeout, and the subscriber can simply call Receive
+// again.
+func (s *Subscriber) Receive() (topic, message string, err error) {
+	msg, err := receive(s.socket, &s.recv)
+	if err != nil {
+		return "", "", err
+	}
+	return msg.Topic, string(msg.Payload), nil
+}
+
+// ReceiveMessage waits for the next message and returns it.
+// A message without a topic delimiter is returned with an empty topic.
+func (s *Subscriber) ReceiveMessage() (Message, error) {
+	return receive(s.socket, &s.recv)
+}
+
+// DefaultRecvDeadline is the receive deadline of a new subscriber.
+const DefaultRecvDeadline = 10 * time.Second
+
+// SetRecvDeadline sets how long Receive, ReceiveMessage, and the other
+// receiving methods wait for a message before they return ErrTimeout. Zero
+// means to wait forever. The default is DefaultRecvDeadline.
+//
+// The new deadline applies from the next receive call on; a call that
+// already waits keeps its deadline. Do not call SetRecvDeadline while
+// ReceiveContext runs, which restores the old deadline when it returns.
+func (s *Subscriber) SetRecvDeadline(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("negative receive deadline %v", d)
+	}
+	return s.socket.SetOption(mangos.OptionRecvDeadline, d)
+}
+
+// SetTextFramingOnly makes the subscriber treat every message as text
+// framed and split it at the first "|", even if a NUL byte comes earlier.
+// By default, the subscriber detects the framing per message, which works
+// for mixed streams from old and new publishers. Only if an old publisher
+// sends topics that contain NUL bytes, detection goes wrong.
+//
+// SetTextFramingOnly must be called before receiving the first message.
+func (s *Subscriber) SetTextFramingOnly(on bool) {
+	s.recv.textOnly = on
+}
+
+// ReceiveContext is like Receive but returns ctx.Err() as soon as ctx is
+// canceled or its deadline passes, within about 100 milliseconds.
+// If the subscriber's own receive deadline passes first, the error is
+// ErrTimeout, as with Receive.
+//
+// ReceiveContext temporarily changes the socket's receive deadline, so it
+// must not run concurrently with other Receive or ReceiveContext calls on
+// the same Subscriber.
+func (s *Subscriber) ReceiveContext(ctx context.Context) (topic, message string, err error) {
+	msg, err := receiveContext(ctx, s.socket, &s.recv)
+	if err != nil {
+		return "", "", err
+	}
+	return msg.Topic, string(msg.Payload), nil
+}
+
+// Close closes the subscriber's socket. This also closes the channel
+// returned by Messages. If the subscriber records messages (see
+// RecordTo), Close writes the queued records and flushes them. It returns
+// the first error of closing the socket or of writing the records.
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+		s.stopExpectations()
+	}
+	s.mu.Unlock()
+	if s.replay != nil {
+		s.replay.Close()
+	}
+	err := s.socket.Close()
+	if rerr := s.RecordTo(nil); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package pubsub
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// A publisher sends as fast as it is called, and a PUB socket drops what
+// the queue of a slow subscriber cannot take. A rate limit smooths bursts
+// out. It works like a token bucket: the bucket holds up to burst tokens
+// and refills at the given rate, and each message takes one token. After
+// a quiet period, burst messages can thus go out at once; after that, the
+// messages go out at the rate. Messages that an interceptor drops (see
+// middleware.go) take no token.
+//
+// The limit is set with Options.RateLimit or Options.WithRateLimit when
+// the publisher is created, and can be changed with SetRateLimit.
+
+// ErrRateLimited is returned by Publish and the other publishing methods
+// if the rate limit does not allow the message and the mode is
+// RateLimitFail.
+var ErrRateLimited = errors.New("publish rate limit exceeded")
+
+// RateLimitMode selects what publishing does when the rate limit does not
+// allow a message yet.
+type RateLimitMode int
+
+const (
+	// RateLimitWait makes publishing wait until the message is allowed.
+	// This is the default.
+	RateLimitWait RateLimitMode = iota
+
+	// RateLimitFail makes publishing return ErrRateLimited at once. The
+	// message is not sent.
+	RateLimitFail
+)
+
+// SetRateLimit limits the publisher to perSec messages per second, with
+// bursts of up to burst messages. A burst below 1 counts as 1. A perSec of
+// zero or less removes the limit, which is the default. The limit applies
+// to all publishing methods together and may be changed while other
+// goroutines publish.
+func (p *Publisher) SetRateLimit(perSec float64, burst int, mode RateLimitMode) {
+	p.limiter.set(perSec, burst, mode)
+}
+
+// rateLimiter is the token bucket of a publisher.
+type rateLimiter struct {
+	mu     sync.Mutex
+	perSec float64 // zero if there is no limit
+	burst  float64
+	mode   RateLimitMode
+	tokens float64 // below zero if waiting callers have taken tokens in advance
+	last   time.Time
+}
+
+func (l *rateLimiter) set(perSec float64, burst int, mode RateLimitMode) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if perSec < 0 {
+		perSec = 0
+	}
+	l.perSec = perSec
+	l.burst = math.Max(float64(burst), 1)
+	l.mode = mode
+	l.tokens = l.burst
+	l.last = time.Now()
+}
+
+// take takes a token for a message. In RateLimitWait mode, it waits until
+// there is one; in RateLimitFail mode, it returns ErrRateLimited instead.
+func (l *rateLimiter) take() error {
+	l.mu.Lock()
+	if l.perSec == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.perSec)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		l.mu.Unlock()
+		return nil
+	}
+	if l.mode == RateLimitFail {
+		l.mu.Unlock()
+		return ErrRateLimited
+	}
+	// The token is taken now and paid for by waiting, so that waiting
+	// callers line up behind each other instead of all waking at once.
+	wait := time.Duration((1 - l.tokens) / l.perSec * float64(time.Second))
+	l.tokens--
+	l.mu.Unlock()
+	time.Sleep(wait)
+	return nil
+}
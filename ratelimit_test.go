@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Concurrent publishers together achieve the rate of the limit, after
+// the burst.
+func TestRateLimitAchievedRate(t *testing.T) {
+	const rate, burst, n = 200, 10, 110
+	p := newTestPublisher(t, testURL(t), Options{}.WithRateLimit(rate, burst))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n/4+1; i++ {
+				if err := p.Publish("a", ""); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// 112 messages, the first 10 at once, the other 102 at 200 a second.
+	want := time.Duration(float64(4*(n/4+1)-burst) / rate * float64(time.Second))
+	if elapsed < want*9/10 || elapsed > want*3/2 {
+		t.Errorf("took %v, want about %v", elapsed, want)
+	}
+}
+
+// In RateLimitFail mode, the messages beyond the burst fail at once.
+func TestRateLimitFail(t *testing.T) {
+	p := newTestPublisher(t, testURL(t), Options{RateLimit: 1, RateBurst: 3, RateLimitMode: RateLimitFail})
+	var sent, limited int
+	for i := 0; i < 10; i++ {
+		switch err := p.Publish("a", ""); {
+		case err == nil:
+			sent++
+		case errors.Is(err, ErrRateLimited):
+			limited++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if sent != 3 || limited != 7 {
+		t.Errorf("sent %d and limited %d, want 3 and 7", sent, limited)
+	}
+
+	p.SetRateLimit(0, 0, RateLimitFail)
+	if err := p.Publish("a", ""); err != nil {
+		t.Errorf("Publish without a limit: %v", err)
+	}
+}
+
+// Messages that an interceptor drops take no token.
+func TestRateLimitAfterIntercept(t *testing.T) {
+	p := newTestPublisher(t, testURL(t), Options{RateLimit: 1, RateBurst: 2, RateLimitMode: RateLimitFail})
+	p.Intercept(func(msg Message) (Message, error) {
+		if msg.Topic == "noise" {
+			return msg, ErrDropMessage
+		}
+		return msg, nil
+	})
+	for i := 0; i < 10; i++ {
+		if err := p.Publish("noise", ""); err != nil {
+			t.Fatalf("dropped message: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.Publish("a", ""); err != nil {
+			t.Errorf("message %d after the dropped ones: %v", i+1, err)
+		}
+	}
+}
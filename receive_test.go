@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-mangos/mangos"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// ReceiveContext returns ctx.Err() within about a poll interval after ctx
+// is done, long before the receive deadline, and restores the deadline.
+func TestReceiveContextCancel(t *testing.T) {
+	url := testURL(t)
+	newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "quiet")
+	s.SetRecvDeadline(time.Minute)
+	const slack = 100 * time.Millisecond
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, _, err := s.ReceiveContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ReceiveContext: %v, want context.Canceled", err)
+		}
+		if d := time.Since(start); d > 50*time.Millisecond+receivePollInterval+slack {
+			t.Errorf("ReceiveContext returned %v after the start, too late for a cancel after 50ms", d)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, _, err := s.ReceiveContext(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ReceiveContext: %v, want context.DeadlineExceeded", err)
+		}
+		if d := time.Since(start); d > 30*time.Millisecond+slack {
+			t.Errorf("ReceiveContext returned after %v, want about 30ms", d)
+		}
+	})
+
+	t.Run("canceled before", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, _, err := s.ReceiveContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("ReceiveContext: %v, want context.Canceled", err)
+		}
+	})
+
+	v, err := s.socket.GetOption(mangos.OptionRecvDeadline)
+	if err != nil || v != time.Minute {
+		t.Errorf("receive deadline %v, %v after ReceiveContext, want 1m0s", v, err)
+	}
+}
+
+// The receive deadline applies from the next call on, and zero waits
+// until a message arrives.
+func TestRecvDeadline(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s, err := NewSubscriberWithOptions(url, Options{}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, err := s.socket.GetOption(mangos.OptionRecvDeadline); err != nil || v != DefaultRecvDeadline {
+		t.Errorf("receive deadline of a new subscriber %v, %v; want %v", v, err, DefaultRecvDeadline)
+	}
+	if err := s.SetRecvDeadline(-time.Second); err == nil {
+		t.Error("SetRecvDeadline accepts a negative deadline")
+	}
+
+	for _, d := range []time.Duration{30 * time.Millisecond, 150 * time.Millisecond} {
+		if err := s.SetRecvDeadline(d); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		_, _, err := s.Receive()
+		if elapsed := time.Since(start); !errors.Is(err, ErrTimeout) || elapsed < d || elapsed > d+100*time.Millisecond {
+			t.Errorf("deadline %v: Receive returned %v after %v, want ErrTimeout", d, err, elapsed)
+		}
+	}
+
+	if err := s.SetRecvDeadline(0); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(300*time.Millisecond, func() { p.Publish("a", "late") })
+	if _, msg, err := s.Receive(); err != nil || msg != "late" {
+		t.Errorf("without a deadline, Receive = %q, %v; want late", msg, err)
+	}
+}
+
+// Timeouts, closed sockets, and malformed frames can be told apart with
+// errors.Is.
+func TestSentinelErrors(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "a", "ping")
+	waitFlow(t, p, s)
+
+	s.SetRecvDeadline(20 * time.Millisecond)
+	if _, _, err := s.Receive(); !errors.Is(err, ErrTimeout) || errors.Is(err, ErrClosed) {
+		t.Errorf("Receive after the deadline = %v, want ErrTimeout", err)
+	}
+	s.SetRecvDeadline(2 * time.Second)
+
+	if err := p.Publish("a\x00b", ""); !errors.Is(err, ErrBadEnvelope) {
+		t.Errorf("publishing a topic with a NUL byte = %v, want ErrBadEnvelope", err)
+	}
+	p.SetFraming(TextFraming)
+	if err := p.Publish("a|b", ""); !errors.Is(err, ErrBadEnvelope) {
+		t.Errorf("publishing a topic with | in the text framing = %v, want ErrBadEnvelope", err)
+	}
+	p.SetFraming(BinaryFraming)
+	raw, err := encodeMessage(wire.Binary, Message{Topic: "a", Payload: []byte("payload")}, &sendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.socket.Send(raw[:len(raw)-len("payload")-1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish("a", "fine"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Receive(); !errors.Is(err, ErrBadEnvelope) {
+		t.Errorf("Receive of a truncated frame = %v, want ErrBadEnvelope", err)
+	}
+	if _, msg, err := s.Receive(); err != nil || msg != "fine" {
+		t.Errorf("Receive after the truncated frame = %q, %v; want fine", msg, err)
+	}
+
+	// A receive that waits when Close is called, and any after it, fail
+	// with ErrClosed.
+	time.AfterFunc(50*time.Millisecond, func() { s.Close() })
+	if _, _, err := s.Receive(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Receive during Close = %v, want ErrClosed", err)
+	}
+	if _, _, err := s.Receive(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Receive after Close = %v, want ErrClosed", err)
+	}
+	p.Close()
+	if err := p.Publish("a", "gone"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish after Close = %v, want ErrClosed", err)
+	}
+}
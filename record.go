@@ -0,0 +1,148 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// As the publisher can keep a journal of what it sends (see journal.go), a
+// subscriber can record what it receives: with RecordTo, each message that
+// a receiving method returns is written to an io.Writer as one line of
+// JSON, for example
+//
+//	{"time":"2026-10-16T09:41:07.123456789+02:00","topic":"Weather","payload":"Sunny"}
+//
+// The time is when the message arrived. A payload that is not valid UTF-8
+// is written in base64, and the record says so with "encoding":"base64".
+//
+// Writing must not hold up receiving, so the records go through a queue of
+// recordQueueSize records to a goroutine that writes them and flushes its
+// buffer whenever the queue runs empty. If the writer cannot keep up and
+// the queue is full, records are dropped and counted (see RecordDropped).
+
+// recordQueueSize is the number of records that can wait for the writer.
+const recordQueueSize = 1024
+
+// record is a received message as RecordTo writes it.
+type record struct {
+	Time     time.Time `json:"time"`
+	Topic    string    `json:"topic"`
+	Payload  string    `json:"payload"`
+	Encoding string    `json:"encoding,omitempty"`
+}
+
+func newRecord(t time.Time, msg Message) record {
+	r := record{Time: t, Topic: msg.Topic}
+	if utf8.Valid(msg.Payload) {
+		r.Payload = string(msg.Payload)
+	} else {
+		r.Payload = base64.StdEncoding.EncodeToString(msg.Payload)
+		r.Encoding = "base64"
+	}
+	return r
+}
+
+// recorder writes records to a writer in the background.
+type recorder struct {
+	queue   chan record
+	done    chan struct{}
+	dropped *uint64 // counts records that are not written
+	err     error   // the first write error; read it after done is closed
+}
+
+func newRecorder(w io.Writer, dropped *uint64) *recorder {
+	r := &recorder{
+		queue:   make(chan record, recordQueueSize),
+		done:    make(chan struct{}),
+		dropped: dropped,
+	}
+	go r.run(w)
+	return r
+}
+
+// run writes the queued records until the queue is closed. After a write
+// error, it drops the remaining ones.
+func (r *recorder) run(w io.Writer) {
+	defer close(r.done)
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	for rec := range r.queue {
+		if r.err != nil {
+			atomic.AddUint64(r.dropped, 1)
+			continue
+		}
+		r.err = enc.Encode(rec)
+		if r.err == nil && len(r.queue) == 0 {
+			r.err = buf.Flush()
+		}
+		if r.err != nil {
+			atomic.AddUint64(r.dropped, 1)
+			logger().Error("Cannot record messages", "error", r.err)
+		}
+	}
+	if r.err == nil {
+		r.err = buf.Flush()
+	}
+}
+
+// add queues rec, or drops it if the queue is full. The caller must make
+// sure that add and stop do not run concurrently.
+func (r *recorder) add(rec record) {
+	select {
+	case r.queue <- rec:
+	default:
+		atomic.AddUint64(r.dropped, 1)
+	}
+}
+
+// stop writes the queued records, flushes them, and returns the first
+// write error.
+func (r *recorder) stop() error {
+	close(r.queue)
+	<-r.done
+	return r.err
+}
+
+// RecordTo makes the subscriber write each message it receives from now
+// on to w, as described above. It does not close w. Passing nil stops
+// recording. Either way, the records of an earlier call are written and
+// flushed first, and the first error in writing them is returned. Close
+// stops recording, too.
+func (s *Subscriber) RecordTo(w io.Writer) error {
+	// recordMu makes a second call wait until the first has flushed.
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+	s.mu.Lock()
+	old := s.recorder
+	s.recorder = nil
+	if w != nil {
+		s.recorder = newRecorder(w, &s.recordDropped)
+	}
+	s.mu.Unlock()
+	if old == nil {
+		return nil
+	}
+	return old.stop()
+}
+
+// RecordDropped returns the number of received messages that were not
+// recorded, because the writer could not keep up or failed.
+func (s *Subscriber) RecordDropped() uint64 {
+	return atomic.LoadUint64(&s.recordDropped)
+}
+
+// record queues msg for the recorder, if there is one.
+func (s *Subscriber) record(msg Message) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recorder != nil {
+		s.recorder.add(newRecord(now, msg))
+	}
+}
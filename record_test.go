@@ -0,0 +1,140 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readRecords decodes the lines that RecordTo wrote.
+func readRecords(t *testing.T, b []byte) []record {
+	t.Helper()
+	var recs []record
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		var r record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatalf("line %d: %v: %s", len(recs)+1, err, sc.Bytes())
+		}
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+func TestRecordMatchesReceived(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "")
+	var out bytes.Buffer
+	if err := s.RecordTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	sent := []Message{
+		{Topic: "Weather", Payload: []byte("Sunny")},
+		{Topic: "quotes", Payload: []byte(`"<b>Ah</b>" & more, ünïcode`)},
+		{Topic: "lines", Payload: []byte("one\ntwo\n")},
+		{Topic: "binary", Payload: []byte{0xff, 0x00, 0xfe, '|'}},
+		{Topic: "", Payload: []byte("")},
+	}
+	start := time.Now()
+	for _, msg := range sent {
+		if err := p.PublishMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := receiveN(t, s, len(sent))
+	end := time.Now()
+	if err := s.RecordTo(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := readRecords(t, out.Bytes())
+	if len(recs) != len(got) {
+		t.Fatalf("%d records for %d messages:\n%s", len(recs), len(got), out.Bytes())
+	}
+	for i, r := range recs {
+		payload := []byte(r.Payload)
+		if r.Encoding == "base64" {
+			var err error
+			if payload, err = base64.StdEncoding.DecodeString(r.Payload); err != nil {
+				t.Fatal(err)
+			}
+		} else if r.Encoding != "" {
+			t.Errorf("record %d has encoding %q", i+1, r.Encoding)
+		}
+		if r.Topic != got[i].Topic || !bytes.Equal(payload, got[i].Payload) {
+			t.Errorf("record %d is %q %q, received %q %q", i+1, r.Topic, payload, got[i].Topic, got[i].Payload)
+		}
+		if r.Time.Before(start) || r.Time.After(end) {
+			t.Errorf("record %d has time %v, outside of %v to %v", i+1, r.Time, start, end)
+		}
+	}
+	if s.RecordDropped() != 0 {
+		t.Errorf("RecordDropped() = %d, want 0", s.RecordDropped())
+	}
+}
+
+// blockingWriter blocks all writes until it is released.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+// A writer that does not keep up costs records, not messages.
+func TestRecordBlockingWriter(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "")
+	w := &blockingWriter{release: make(chan struct{})}
+	if err := s.RecordTo(w); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 2 * recordQueueSize
+	for i := 0; i < n; i++ {
+		if err := p.Publish("counter", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+		msg := receiveN(t, s, 1)[0]
+		if string(msg.Payload) != strconv.Itoa(i) {
+			t.Fatalf("received %q, want %d", msg.Payload, i)
+		}
+	}
+	dropped := s.RecordDropped()
+	if dropped < n-recordQueueSize-100 {
+		t.Errorf("RecordDropped() = %d with a blocked writer, want most of the %d messages beyond the queue", dropped, n-recordQueueSize)
+	}
+
+	close(w.release)
+	if err := s.RecordTo(nil); err != nil {
+		t.Fatal(err)
+	}
+	recs := readRecords(t, w.buf.Bytes())
+	if uint64(len(recs))+s.RecordDropped() != n {
+		t.Errorf("%d records and %d dropped, want %d in all", len(recs), s.RecordDropped(), n)
+	}
+	// The records that made it start with the first message and keep the
+	// order. The writer may have taken some records off the queue before
+	// it blocked, so later ones can follow a gap.
+	last := -1
+	for i, r := range recs {
+		n, err := strconv.Atoi(r.Payload)
+		if err != nil || n <= last || (i == 0 && n != 0) {
+			t.Fatalf("record %d is %q after %d", i, r.Payload, last)
+		}
+		last = n
+	}
+}
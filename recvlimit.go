@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"sync/atomic"
+
+	"github.com/go-mangos/mangos"
+	"github.com/gorilla/websocket"
+)
+
+// When a peer sends a message larger than OptionMaxRecvSize, Mangos closes
+// the connection and throws the error away. To tell such a disconnect from
+// others, the transports of a subscriber socket are wrapped, and their
+// connections remember whether a message was too long. The port hook then
+// asks for the propTooLong property.
+
+// propTooLong is true for a connection that received a message larger
+// than maxRecvSize.
+const propTooLong = "pubsub.tooLong"
+
+// tooLong reports whether port was closed because of an oversized message.
+func tooLong(port mangos.Port) bool {
+	v, err := port.GetProp(propTooLong)
+	return err == nil && v == true
+}
+
+// limitTransport wraps the connections of a transport in limitPipes.
+type limitTransport struct {
+	mangos.Transport
+}
+
+func (t limitTransport) NewDialer(url string, sock mangos.Socket) (mangos.PipeDialer, error) {
+	d, err := t.Transport.NewDialer(url, sock)
+	if err != nil {
+		return nil, err
+	}
+	return limitDialer{d}, nil
+}
+
+func (t limitTransport) NewListener(url string, sock mangos.Socket) (mangos.PipeListener, error) {
+	l, err := t.Transport.NewListener(url, sock)
+	if err != nil {
+		return nil, err
+	}
+	return limitListener{l}, nil
+}
+
+type limitDialer struct {
+	mangos.PipeDialer
+}
+
+func (d limitDialer) Dial() (mangos.Pipe, error) {
+	p, err := d.PipeDialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return &limitPipe{Pipe: p}, nil
+}
+
+type limitListener struct {
+	mangos.PipeListener
+}
+
+func (l limitListener) Accept() (mangos.Pipe, error) {
+	p, err := l.PipeListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &limitPipe{Pipe: p}, nil
+}
+
+// limitPipe is a connection that remembers whether it received a message
+// that was too long.
+type limitPipe struct {
+	mangos.Pipe
+	tooLong int32 // accessed atomically
+}
+
+func (p *limitPipe) Recv() (*mangos.Message, error) {
+	msg, err := p.Pipe.Recv()
+	// TCP, TLS, and IPC share Mangos' framing; WebSocket has a limit
+	// of its own.
+	if err == mangos.ErrTooLong || err == websocket.ErrReadLimit {
+		atomic.StoreInt32(&p.tooLong, 1)
+	}
+	return msg, err
+}
+
+func (p *limitPipe) GetProp(name string) (interface{}, error) {
+	if name == propTooLong {
+		return atomic.LoadInt32(&p.tooLong) == 1, nil
+	}
+	return p.Pipe.GetProp(name)
+}
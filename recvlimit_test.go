@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects log output for a test.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the lines that contain s.
+func (b *logBuffer) lines(s string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		if strings.Contains(line, s) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// captureLog sends the log of the package to a buffer until the test ends.
+func captureLog(t *testing.T) *logBuffer {
+	b := &logBuffer{}
+	SetLogger(slog.New(slog.NewTextHandler(b, nil)))
+	t.Cleanup(func() { SetLogger(nil) })
+	return b
+}
+
+// waitLog waits until the log has a line with s and returns it.
+func waitLog(t *testing.T, b *logBuffer, s string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if lines := b.lines(s); len(lines) > 0 {
+			return lines[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no log line with %q", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDisconnectReports(t *testing.T) {
+	log := captureLog(t)
+
+	t.Run("local close", func(t *testing.T) {
+		p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+		s := newTestSubscriber(t, p.Addr(), Options{}, "")
+		s.Close()
+		time.Sleep(100 * time.Millisecond)
+		if lines := log.lines(strings.TrimPrefix(p.Addr(), "tcp://")); len(lines) > 0 {
+			t.Errorf("closing the subscriber logged %q", lines)
+		}
+	})
+
+	t.Run("publisher closes", func(t *testing.T) {
+		p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+		newTestSubscriber(t, p.Addr(), Options{}, "")
+		p.Close()
+		line := waitLog(t, log, strings.TrimPrefix(p.Addr(), "tcp://"))
+		if !strings.Contains(line, "Lost connection") || strings.Contains(line, "max_recv_size") {
+			t.Errorf("logged %q, want a lost connection without the size limit", line)
+		}
+	})
+
+	t.Run("message too long", func(t *testing.T) {
+		p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+		newTestSubscriber(t, p.Addr(), Options{}, "")
+		if err := p.Publish("big", strings.Repeat("x", maxRecvSize+1)); err != nil {
+			t.Fatal(err)
+		}
+		line := waitLog(t, log, strings.TrimPrefix(p.Addr(), "tcp://"))
+		if !strings.Contains(line, "too long") || !strings.Contains(line, "max_recv_size=1048576") {
+			t.Errorf("logged %q, want the size limit", line)
+		}
+	})
+}
+
+func TestForwarderCloseIsQuiet(t *testing.T) {
+	log := captureLog(t)
+	p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+	down := testURL(t) + "-down"
+	f, err := NewForwarder([]string{p.Addr()}, Options{}, []string{down}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go f.Run(context.Background())
+	waitFlow(t, p, newTestSubscriber(t, down, Options{}, ""))
+	f.Close()
+	time.Sleep(100 * time.Millisecond)
+	if lines := log.lines(strings.TrimPrefix(p.Addr(), "tcp://")); len(lines) > 0 {
+		t.Errorf("closing the forwarder logged %q", lines)
+	}
+}
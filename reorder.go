@@ -0,0 +1,197 @@
+package pubsub
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// A publisher sends the messages of a topic in order, but they do not
+// always arrive in order: a forwarder that passes frames on from several
+// goroutines, or a reconnect in between, can swap them. Without help, the
+// subscriber takes a message that overtakes another one for a gap, and the
+// overtaken one for a restart of the publisher (see seq.go).
+//
+// With Options.ReorderWindow set, the subscriber holds back a message
+// that comes too early, until the messages before it arrive, and then
+// passes them on in order. It holds at most ReorderWindow messages per
+// topic and publisher URL; when the window overflows, or when nothing
+// arrives for a while, it gives up on the missing messages, reports them
+// as a gap, and passes on what it holds. A while is the receive deadline,
+// or about 100 milliseconds for ReceiveContext and Run, which wait in
+// slices.
+//
+// A missing message that arrives after the subscriber gave up on it is
+// late. The subscriber passes it on, out of order, and counts it; see
+// Late. With AutoReplay, the gap was replayed already, and the late
+// message is skipped as a duplicate. A message below the last one that
+// falls into no gap is still taken for a restart.
+//
+// The order of messages of different topics or publishers is not
+// restored.
+
+// maxHoles is the number of gaps per sequence that the subscriber
+// remembers for late messages.
+const maxHoles = 16
+
+// heldMsg is a message that the subscriber holds back, or releases after
+// the gap before it, if any.
+type heldMsg struct {
+	raw []byte
+	msg Message
+	gap seqRange // missing before msg; from is 0 if there is no gap
+}
+
+// seqRange is a range of sequence numbers, from and to inclusive.
+type seqRange struct {
+	from, to uint64
+	replayed bool // for holes: the messages were requested again
+}
+
+// seqOutcome is what checkSeq decided about a message.
+type seqOutcome struct {
+	pass     bool      // pass the message on now
+	skip     bool      // skip it as a duplicate
+	late     bool      // it is late, see above
+	violated bool      // it violates the order, see strict.go
+	gap      seqRange  // missing before the message
+	release  []heldMsg // held messages to pass on after it
+}
+
+// Late returns the number of messages that arrived after the subscriber
+// had reported them missing. See Options.ReorderWindow.
+func (s *Subscriber) Late() uint64 {
+	return atomic.LoadUint64(&s.late)
+}
+
+// releaseNext removes the held messages that follow st.last without a
+// gap and returns them.
+func (st *seqState) releaseNext() []heldMsg {
+	n := 0
+	for n < len(st.held) && st.held[n].msg.Seq == st.last+1 {
+		st.last++
+		n++
+	}
+	release := st.held[:n:n]
+	st.held = st.held[n:]
+	return release
+}
+
+// releaseAll gives up on the gaps between the held messages and removes
+// and returns all of them.
+func (st *seqState) releaseAll(autoReplay bool) []heldMsg {
+	var release []heldMsg
+	for len(st.held) > 0 {
+		release = append(release, st.giveUp(autoReplay)...)
+	}
+	return release
+}
+
+// giveUp gives up on the gap before the first held message and returns
+// that message, with the gap, and the ones that follow it without a gap.
+func (st *seqState) giveUp(autoReplay bool) []heldMsg {
+	h := st.held[0]
+	st.held = st.held[1:]
+	h.gap = seqRange{from: st.last + 1, to: h.msg.Seq - 1}
+	st.addHole(h.gap.from, h.gap.to, autoReplay)
+	st.last = h.msg.Seq
+	return append([]heldMsg{h}, st.releaseNext()...)
+}
+
+// hold holds back msg, which came too early, and gives up on gaps while
+// more than window messages are held.
+func (st *seqState) hold(raw []byte, msg Message, window int, autoReplay bool) seqOutcome {
+	i := sort.Search(len(st.held), func(i int) bool { return st.held[i].msg.Seq >= msg.Seq })
+	if i < len(st.held) && st.held[i].msg.Seq == msg.Seq {
+		return seqOutcome{skip: true}
+	}
+	st.held = append(st.held, heldMsg{})
+	copy(st.held[i+1:], st.held[i:])
+	st.held[i] = heldMsg{raw: raw, msg: msg}
+	var out seqOutcome
+	for len(st.held) > window {
+		out.release = append(out.release, st.giveUp(autoReplay)...)
+	}
+	return out
+}
+
+// early handles msg, which has a number below the next one expected: it
+// is late, a duplicate of a replayed message, or the first message after
+// a restart of the publisher.
+func (st *seqState) early(raw []byte, msg Message, autoReplay bool) seqOutcome {
+	for i, h := range st.holes {
+		if msg.Seq < h.from || msg.Seq > h.to {
+			continue
+		}
+		if h.replayed {
+			return seqOutcome{skip: true}
+		}
+		st.holes = append(st.holes[:i:i], st.holes[i+1:]...)
+		if h.from < msg.Seq {
+			st.holes = append(st.holes, seqRange{from: h.from, to: msg.Seq - 1})
+		}
+		if msg.Seq < h.to {
+			st.holes = append(st.holes, seqRange{from: msg.Seq + 1, to: h.to})
+		}
+		return seqOutcome{pass: true, late: true}
+	}
+	// A restart: what the subscriber holds is from before it, so it goes
+	// first.
+	release := st.releaseAll(autoReplay)
+	st.last = msg.Seq
+	st.holes = nil
+	if len(release) == 0 {
+		return seqOutcome{pass: true}
+	}
+	return seqOutcome{release: append(release, heldMsg{raw: raw, msg: msg})}
+}
+
+// replayed reports whether seq is in a gap that was replayed.
+func (st *seqState) replayed(seq uint64) bool {
+	for _, h := range st.holes {
+		if h.replayed && h.from <= seq && seq <= h.to {
+			return true
+		}
+	}
+	return false
+}
+
+// addHole remembers the gap from and to for late messages.
+func (st *seqState) addHole(from, to uint64, replayed bool) {
+	if len(st.holes) == maxHoles {
+		st.holes = st.holes[1:]
+	}
+	st.holes = append(st.holes, seqRange{from: from, to: to, replayed: replayed})
+}
+
+// queueReleased reports the gaps of the released messages and queues the
+// messages for receive, or the replayed messages of the gaps first.
+func (s *Subscriber) queueReleased(release []heldMsg) {
+	for _, h := range release {
+		if h.gap.from != 0 {
+			s.reportGap(h.msg.Topic, h.gap)
+			if s.autoReplay {
+				s.replayGap(h.raw, h.msg, h.gap.from, h.gap.to)
+				continue
+			}
+		}
+		s.mu.Lock()
+		s.backlog = append(s.backlog, h.msg)
+		s.mu.Unlock()
+	}
+}
+
+// flushHeld gives up on the gaps of all sequences and queues the messages
+// that the subscriber holds. It reports whether there were any.
+func (s *Subscriber) flushHeld() bool {
+	if s.reorderWindow == 0 {
+		return false
+	}
+	var release []heldMsg
+	s.mu.Lock()
+	for _, st := range s.seqs {
+		release = append(release, st.releaseAll(s.autoReplay)...)
+	}
+	s.mu.Unlock()
+	s.queueReleased(release)
+	return len(release) > 0
+}
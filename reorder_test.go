@@ -0,0 +1,175 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// delaySeqs returns an edit function for startRelay that swaps the frames
+// of topic: it holds back the frame with a number in delays until the
+// frame with the number it maps to has passed.
+func delaySeqs(topic string, delays map[uint64]uint64) func(wire.Frame) []wire.Frame {
+	held := make(map[uint64]wire.Frame)
+	return func(f wire.Frame) []wire.Frame {
+		if f.Topic != topic {
+			return []wire.Frame{f}
+		}
+		if _, ok := delays[f.Header.Seq]; ok {
+			held[f.Header.Seq] = f
+			return nil
+		}
+		out := []wire.Frame{f}
+		for seq, after := range delays {
+			if after == f.Header.Seq {
+				out = append(out, held[seq])
+			}
+		}
+		return out
+	}
+}
+
+// newReorderSubscriber returns a subscriber of the relay at url with the
+// given window and the gaps that it reports.
+func newReorderSubscriber(t *testing.T, url string, window int, topics ...string) (*Subscriber, *[]gap) {
+	s := newTestSubscriber(t, url, Options{ReorderWindow: window}, append(topics, "ping")...)
+	gaps := new([]gap)
+	s.OnGap(func(topic string, from, to uint64) {
+		if topic != "ping" {
+			*gaps = append(*gaps, gap{topic, from, to})
+		}
+	})
+	return s, gaps
+}
+
+// Inversions within the window reach the handlers in the order of each
+// publisher, even if two publishers send on the same topic.
+func TestReorderPerPublisher(t *testing.T) {
+	var downs []string
+	var publishers []*Publisher
+	delays := []map[uint64]uint64{{2: 4}, {3: 5, 4: 5}}
+	for i, delay := range delays {
+		up, down := fmt.Sprintf("%s-up%d", testURL(t), i), fmt.Sprintf("%s-down%d", testURL(t), i)
+		publishers = append(publishers, newTestPublisher(t, up, Options{}))
+		startRelay(t, up, down, delaySeqs("a", delay))
+		downs = append(downs, down)
+	}
+	s, err := NewSubscriberURLs(downs, Options{ReorderWindow: 3}, "a", "ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetRecvDeadline(2 * time.Second)
+	for _, p := range publishers {
+		waitFlow(t, p, s)
+	}
+
+	var mu sync.Mutex
+	got := make(map[string][]uint64)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	s.Handle("a", func(msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[string(msg.Payload)] = append(got[string(msg.Payload)], msg.Seq)
+		if n++; n == 12 {
+			cancel()
+		}
+		return nil
+	})
+	s.Handle("ping", func(Message) error { return nil })
+	for i := 0; i < 6; i++ {
+		for j, p := range publishers {
+			if err := p.Publish("a", fmt.Sprint("p", j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	go func() {
+		time.Sleep(5 * time.Second)
+		cancel()
+	}()
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string][]uint64{"p0": {1, 2, 3, 4, 5, 6}, "p1": {1, 2, 3, 4, 5, 6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handler got %v, want %v", got, want)
+	}
+	if s.Missed() != 0 || s.Late() != 0 {
+		t.Errorf("Missed() = %d, Late() = %d, want 0, 0", s.Missed(), s.Late())
+	}
+}
+
+// An inversion beyond the window is a gap first, and the message that
+// fills it later is late.
+func TestReorderLate(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	startRelay(t, up, down, delaySeqs("a", map[uint64]uint64{2: 5}))
+	s, gaps := newReorderSubscriber(t, down, 1, "a")
+	waitFlow(t, p, s)
+
+	publishAll(t, p, "a", "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 5))
+	if want := []string{"a1", "a3", "a4", "a5", "a2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if want := []gap{{"a", 2, 2}}; !reflect.DeepEqual(*gaps, want) {
+		t.Errorf("gaps %v, want %v", *gaps, want)
+	}
+	if s.Missed() != 1 || s.Late() != 1 {
+		t.Errorf("Missed() = %d, Late() = %d, want 1, 1", s.Missed(), s.Late())
+	}
+}
+
+// Without a window, a message that overtook another one causes a gap, and
+// the other one is late instead of a restart.
+func TestReorderNoWindow(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	startRelay(t, up, down, delaySeqs("a", map[uint64]uint64{2: 3}))
+	s, gaps := newReorderSubscriber(t, down, 0, "a")
+	waitFlow(t, p, s)
+
+	publishAll(t, p, "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 4))
+	if want := []string{"a1", "a3", "a2", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if want := []gap{{"a", 2, 2}}; !reflect.DeepEqual(*gaps, want) {
+		t.Errorf("gaps %v, want %v", *gaps, want)
+	}
+	if s.Late() != 1 {
+		t.Errorf("Late() = %d, want 1", s.Late())
+	}
+}
+
+// When nothing arrives until the receive deadline, the subscriber gives
+// up on the missing message and passes on what it holds.
+func TestReorderFlushOnTimeout(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	startRelay(t, up, down, dropSeqs("a", 2))
+	s, gaps := newReorderSubscriber(t, down, 5, "a")
+	waitFlow(t, p, s)
+	s.SetRecvDeadline(200 * time.Millisecond)
+
+	publishAll(t, p, "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 3))
+	if want := []string{"a1", "a3", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if want := []gap{{"a", 2, 2}}; !reflect.DeepEqual(*gaps, want) {
+		t.Errorf("gaps %v, want %v", *gaps, want)
+	}
+}
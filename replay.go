@@ -0,0 +1,270 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-mangos/mangos"
+	"github.com/go-mangos/mangos/protocol/rep"
+	"github.com/go-mangos/mangos/protocol/req"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// Sequence numbers tell a subscriber what it missed (see seq.go); replay
+// gets the missed messages back. A publisher with a replay URL (see
+// Options.ReplayURL) keeps the latest messages of each topic in memory
+// and answers requests for them on a separate REP socket. A PUB socket
+// only sends, so the requests cannot go through it.
+//
+// A subscriber asks with RequestReplay, or automatically whenever it
+// notices a gap (see Options.AutoReplay). The publisher answers with
+// what it still has. If the start of the range is gone already, the
+// answer says so (see Replay.EvictedBefore).
+
+// DefaultRetention is the number of messages per topic that a publisher
+// keeps for replay if Options.Retention is zero.
+const DefaultRetention = 1000
+
+// replayTimeout is how long a subscriber waits for the answer to a
+// replay request.
+const replayTimeout = 2 * time.Second
+
+// ErrNoReplay is returned by RequestReplay for a subscriber without a
+// replay URL.
+var ErrNoReplay = errors.New("no replay URL")
+
+// Replay is the answer to a replay request.
+type Replay struct {
+	// Messages are the requested messages that the publisher still
+	// had, in order.
+	Messages []Message
+
+	// EvictedBefore is set if the publisher no longer had the start of
+	// the requested range: the messages before EvictedBefore are lost.
+	// It is 0 if nothing is missing.
+	EvictedBefore uint64
+}
+
+// archived is a message that a publisher keeps for replay.
+type archived struct {
+	seq uint64
+	raw []byte
+}
+
+// archive keeps the latest frames of each topic and answers replay
+// requests for them.
+type archive struct {
+	socket mangos.Socket // REP
+	addr   string
+	size   int // frames per topic
+
+	mu     sync.Mutex
+	topics map[string][]archived // per topic in wire form, oldest first
+}
+
+// newArchive creates an archive that listens for replay requests on url.
+func newArchive(url string, opts Options) (*archive, error) {
+	size := opts.Retention
+	if size <= 0 {
+		size = DefaultRetention
+	}
+	socket, err := rep.NewSocket()
+	if err != nil {
+		return nil, err
+	}
+	addTransports(socket)
+	topts, err := transportOptions(url, opts, false)
+	var addr string
+	if err == nil {
+		addr, err = listen(socket, url, topts, opts)
+	}
+	if err != nil {
+		socket.Close()
+		return nil, fmt.Errorf("cannot listen for replay requests on %s: %w", url, err)
+	}
+	a := &archive{socket: socket, addr: addr, size: size, topics: make(map[string][]archived)}
+	go a.serve()
+	return a, nil
+}
+
+// add keeps raw, the frame with number seq of topic, and forgets the
+// oldest frame of topic if there are too many. raw must not be modified
+// afterwards.
+func (a *archive) add(topic string, seq uint64, raw []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	frames := append(a.topics[topic], archived{seq: seq, raw: raw})
+	if len(frames) > a.size {
+		frames = frames[len(frames)-a.size:]
+	}
+	a.topics[topic] = frames
+}
+
+// lookup collects the frames that r asks for.
+func (a *archive) lookup(r wire.ReplayRequest) wire.ReplayReply {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	frames := a.topics[r.Topic]
+	var reply wire.ReplayReply
+	switch {
+	case len(frames) == 0:
+		reply.EvictedBefore = r.To + 1
+	case r.From < frames[0].seq:
+		reply.EvictedBefore = frames[0].seq
+	}
+	for _, f := range frames {
+		if f.seq >= r.From && f.seq <= r.To {
+			reply.Frames = append(reply.Frames, f.raw)
+		}
+	}
+	return reply
+}
+
+// serve answers replay requests until the socket is closed. A malformed
+// request gets an empty answer, which the subscriber cannot decode.
+func (a *archive) serve() {
+	for {
+		raw, err := a.socket.Recv()
+		if err != nil {
+			return
+		}
+		var reply []byte
+		if r, err := wire.DecodeReplayRequest(raw); err == nil {
+			reply = wire.EncodeReplayReply(a.lookup(r))
+		}
+		if a.socket.Send(reply) != nil {
+			return
+		}
+	}
+}
+
+// close stops answering replay requests.
+func (a *archive) close() error {
+	return a.socket.Close()
+}
+
+// ReplayAddr returns the URL that the publisher listens on for replay
+// requests, like Addr, or "" if it has no replay URL.
+func (p *Publisher) ReplayAddr() string {
+	if p.archive == nil {
+		return ""
+	}
+	return p.archive.addr
+}
+
+// newReplaySocket creates the REQ socket of a subscriber and dials into
+// url.
+func newReplaySocket(url string, opts Options) (mangos.Socket, error) {
+	socket, err := req.NewSocket()
+	if err != nil {
+		return nil, err
+	}
+	addTransports(socket)
+	err = socket.SetOption(mangos.OptionSendDeadline, replayTimeout)
+	if err == nil {
+		err = socket.SetOption(mangos.OptionRecvDeadline, replayTimeout)
+	}
+	var topts map[string]interface{}
+	if err == nil {
+		topts, err = transportOptions(url, opts, true)
+	}
+	if err == nil {
+		err = socket.DialOptions(url, topts)
+	}
+	if err != nil {
+		socket.Close()
+		return nil, fmt.Errorf("cannot dial into %s for replay: %w", url, err)
+	}
+	return socket, nil
+}
+
+// RequestReplay asks the publisher for the messages from and to
+// (inclusive) of topic. It needs Options.ReplayURL. The answer holds the
+// messages that the publisher still has; see Replay.EvictedBefore.
+// If the answer does not arrive within two seconds, RequestReplay returns
+// ErrTimeout.
+func (s *Subscriber) RequestReplay(topic string, from, to uint64) (Replay, error) {
+	reply, err := s.requestReplay(s.wireTopic(topic), from, to)
+	if err != nil {
+		return Replay{}, err
+	}
+	r := Replay{EvictedBefore: reply.EvictedBefore}
+	for _, f := range reply.Frames {
+		msg, err := parseMessage(f, &s.recv)
+		if err == errAuth {
+			s.authFailed(msg)
+			continue
+		}
+		if err != nil {
+			return Replay{}, err
+		}
+		r.Messages = append(r.Messages, msg)
+	}
+	return r, nil
+}
+
+// requestReplay sends a replay request for a topic in wire form. A REQ
+// socket handles one request at a time, so requests are serialized.
+func (s *Subscriber) requestReplay(topic string, from, to uint64) (wire.ReplayReply, error) {
+	if s.replay == nil {
+		return wire.ReplayReply{}, ErrNoReplay
+	}
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	err := s.replay.Send(wire.EncodeReplayRequest(wire.ReplayRequest{Topic: topic, From: from, To: to}))
+	var raw []byte
+	if err == nil {
+		raw, err = s.replay.Recv()
+	}
+	if err != nil {
+		return wire.ReplayReply{}, fmt.Errorf("cannot replay messages %d to %d: %w", from, to, socketError(err))
+	}
+	return wire.DecodeReplayReply(raw)
+}
+
+// replayGap fetches the messages from and to of the topic of msg, which
+// has just revealed the gap, and queues them for receive, followed by
+// msg. raw is the frame of msg. The messages go through the same checks as
+// any received message. If the replay fails, the gap stays.
+func (s *Subscriber) replayGap(raw []byte, msg Message, from, to uint64) {
+	reply, err := s.requestReplay(s.wireTopic(msg.Topic), from, to)
+	if err != nil {
+		logger().Warn("Replay failed", "topic", msg.Topic, "from", from, "to", to, "error", err)
+	}
+	var queue []Message
+	for _, f := range reply.Frames {
+		m, err := parseMessage(f, &s.recv)
+		if err == errAuth {
+			m.Peer = msg.Peer
+			s.authFailed(m)
+		}
+		if err != nil {
+			continue
+		}
+		m.Peer = msg.Peer
+		if s.wanted(f, m) {
+			queue = append(queue, m)
+		}
+	}
+	if s.wanted(raw, msg) {
+		queue = append(queue, msg)
+	}
+	s.mu.Lock()
+	s.backlog = append(s.backlog, queue...)
+	s.mu.Unlock()
+}
+
+// nextQueued returns the next message that replayGap queued, if any.
+func (s *Subscriber) nextQueued() (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.backlog) == 0 {
+		return Message{}, false
+	}
+	msg := s.backlog[0]
+	s.backlog = s.backlog[1:]
+	return msg, true
+}
@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// A replay returns what the publisher still has of the range, and tells
+// where the range was cut off by eviction.
+func TestReplayEvicted(t *testing.T) {
+	url := testURL(t)
+	opts := Options{ReplayURL: url + "-replay", Retention: 5}
+	p := newTestPublisher(t, url, opts)
+	s := newTestSubscriber(t, url, Options{ReplayURL: opts.ReplayURL}, "a")
+	for i := 1; i <= 8; i++ {
+		if err := p.Publish("a", fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		if err := p.Publish("b", fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		topic    string
+		from, to uint64
+		want     []string // payloads
+		evicted  uint64
+	}{
+		{"a", 1, 8, []string{"4", "5", "6", "7", "8"}, 4},
+		{"a", 2, 5, []string{"4", "5"}, 4},
+		{"a", 1, 2, nil, 4},
+		{"a", 4, 4, []string{"4"}, 0},
+		{"a", 5, 7, []string{"5", "6", "7"}, 0},
+		{"a", 7, 20, []string{"7", "8"}, 0},
+		{"b", 1, 3, []string{"1", "2", "3"}, 0},
+		{"c", 1, 2, nil, 3},
+	}
+	for _, tt := range tests {
+		r, err := s.RequestReplay(tt.topic, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("RequestReplay(%s, %d, %d): %v", tt.topic, tt.from, tt.to, err)
+		}
+		var got []string
+		for _, msg := range r.Messages {
+			if msg.Topic != tt.topic || msg.Payload[0]-'0' != byte(msg.Seq) {
+				t.Errorf("replayed %s %s with seq %d", msg.Topic, msg.Payload, msg.Seq)
+			}
+			got = append(got, string(msg.Payload))
+		}
+		if !reflect.DeepEqual(got, tt.want) || r.EvictedBefore != tt.evicted {
+			t.Errorf("RequestReplay(%s, %d, %d) = %v, evicted before %d; want %v, evicted before %d",
+				tt.topic, tt.from, tt.to, got, r.EvictedBefore, tt.want, tt.evicted)
+		}
+	}
+}
+
+func TestReplayNoURL(t *testing.T) {
+	url := testURL(t)
+	newTestPublisher(t, url, Options{ReplayURL: url + "-replay"})
+	s := newTestSubscriber(t, url, Options{}, "a")
+	if _, err := s.RequestReplay("a", 1, 2); !errors.Is(err, ErrNoReplay) {
+		t.Errorf("RequestReplay without a replay URL = %v, want ErrNoReplay", err)
+	}
+}
@@ -0,0 +1,182 @@
+package pubsub
+
+import (
+	"sync/atomic"
+
+	"github.com/go-mangos/mangos"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// A subscriber can miss messages: when it reads too slowly, Mangos drops
+// what does not fit into its queues, and while it reconnects, the
+// publisher sends into the void. To make such losses visible, a publisher
+// numbers the messages of each topic, starting at 1, and the subscriber
+// checks that the numbers of each topic follow each other without holes.
+//
+// The numbers travel in the header of the binary framing; the text framing
+// has no room for them. Messages without a number are not checked.
+//
+// The numbers are tracked per topic and per publisher URL, so that
+// messages of several publishers (see NewSubscriberURLs) do not get mixed
+// up. A number that goes backwards means that the publisher has
+// restarted, unless it fills a gap (see reorder.go); the subscriber starts
+// over with it and reports no gap.
+//
+// A last-value cache (see Options.LastValueCache) sends messages again
+// and marks them as repeats in the header. A repeat with a number that
+// the subscriber has reached already is skipped and counted as filtered.
+// Only repeats are skipped: an unmarked message with the same number as
+// the last one starts over like a restart, because it may come from
+// another publisher.
+//
+// A forwarder that merges several upstream publishers, or that passes on
+// only a sample of a topic, marks the frames as unsequenced, and the
+// subscriber checks neither gaps nor repeats for them. Frames that a
+// forwarder drops by a rule do not cause gaps, as the rules apply to whole
+// topics; changing the rules while it runs can cause some, though.
+//
+// Unsubscribing from a topic or a pattern forgets the numbers of the
+// topics that no subscription matches anymore, so that subscribing again
+// later does not report the messages in between as missed.
+
+// seqKey identifies a sequence: a topic of the publisher at a URL.
+type seqKey struct {
+	url   string
+	topic string
+}
+
+// OnGap sets a function that is called when the subscriber notices that
+// it missed the messages from and to (inclusive) of topic. Like the
+// function set with OnPeerEvent, it is called from a receiving goroutine
+// and must return quickly.
+func (s *Subscriber) OnGap(fn func(topic string, from, to uint64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onGap = fn
+}
+
+// Missed returns the number of messages that the subscriber noticed
+// missing, including those that were replayed later.
+func (s *Subscriber) Missed() uint64 {
+	return atomic.LoadUint64(&s.missed)
+}
+
+// seqState is what a subscriber knows about a sequence.
+type seqState struct {
+	last  uint64     // number of the last message passed on
+	held  []heldMsg  // messages that came too early, see reorder.go
+	holes []seqRange // numbers given up on, see reorder.go
+}
+
+// checkSeq records the sequence number of msg, which arrived in raw, and
+// reports a gap if there is one. It returns false for a repeat that the
+// subscriber has seen already, for a message that it holds back until its
+// predecessors arrive (see reorder.go), for a message that violates the
+// order in strict mode (see strict.go), and for a message that it has
+// queued after the replayed messages of a gap.
+func (s *Subscriber) checkSeq(raw *mangos.Message, msg Message) bool {
+	if msg.Seq == 0 {
+		return true
+	}
+	key := seqKey{topic: msg.Topic}
+	if raw.Port != nil {
+		key.url = raw.Port.Address()
+	}
+	s.mu.Lock()
+	st := s.seqs[key]
+	if st == nil {
+		st = &seqState{}
+		s.seqs[key] = st
+	}
+	last := st.last
+	var out seqOutcome
+	switch {
+	case msg.Repeat && msg.Seq <= last:
+		out.skip = true
+	case s.strictOrder && last != 0 && msg.Seq <= last:
+		out.skip = st.replayed(msg.Seq)
+		out.violated = !out.skip
+	case last == 0 || msg.Seq == last+1:
+		st.last = msg.Seq
+		out.pass = true
+		out.release = st.releaseNext()
+	case msg.Seq <= last:
+		out = st.early(raw.Body, msg, s.autoReplay)
+	case s.reorderWindow == 0:
+		st.addHole(last+1, msg.Seq-1, s.autoReplay)
+		st.last = msg.Seq
+		out.pass = true
+		out.gap = seqRange{from: last + 1, to: msg.Seq - 1}
+	default:
+		out = st.hold(raw.Body, msg, s.reorderWindow, s.autoReplay)
+	}
+	s.mu.Unlock()
+
+	switch {
+	case out.skip:
+		atomic.AddUint64(&s.filtered, 1)
+		return false
+	case out.violated:
+		s.violated(msg, last)
+		return false
+	case out.late:
+		atomic.AddUint64(&s.late, 1)
+	}
+	if out.gap.from != 0 {
+		s.reportGap(msg.Topic, out.gap)
+		if s.autoReplay {
+			s.replayGap(raw.Body, msg, out.gap.from, out.gap.to)
+			out.pass = false
+		}
+	}
+	s.queueReleased(out.release)
+	return out.pass
+}
+
+// reportGap counts the missing messages of a gap and passes the gap to
+// the function set with OnGap.
+func (s *Subscriber) reportGap(topic string, gap seqRange) {
+	atomic.AddUint64(&s.missed, gap.to-gap.from+1)
+	s.mu.Lock()
+	onGap := s.onGap
+	s.mu.Unlock()
+	if onGap != nil {
+		onGap(topic, gap.from, gap.to)
+	}
+}
+
+// forgetUnwanted forgets the sequence numbers of the topics that neither
+// a topic nor a pattern matches anymore. s.mu must be held.
+func (s *Subscriber) forgetUnwanted() {
+	for key := range s.seqs {
+		if !s.topicIndex.Matches(s.wireTopic(key.topic)+"\x00") && !s.index.Matches(key.topic) {
+			delete(s.seqs, key)
+		}
+	}
+}
+
+// markFrame returns raw with the flags that mark sets in its header. It
+// returns raw itself if there is nothing to mark: if raw is not a binary
+// frame with a sequence number, or if the flags are set already.
+func markFrame(raw []byte, mark func(h *wire.Header)) []byte {
+	f, err := wire.Decode(raw)
+	if err != nil || f.Format != wire.Binary || f.Header.Seq == 0 {
+		return raw
+	}
+	h := f.Header
+	mark(&f.Header)
+	if f.Header == h {
+		return raw
+	}
+	out, err := wire.Encode(f)
+	if err != nil {
+		return raw
+	}
+	return out
+}
+
+// markUnsequenced marks raw as unsequenced, see markFrame.
+func markUnsequenced(raw []byte) []byte {
+	return markFrame(raw, func(h *wire.Header) { h.Unsequenced = true })
+}
@@ -0,0 +1,385 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-mangos/mangos"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// gap is a call of the function set with OnGap.
+type gap struct {
+	topic    string
+	from, to uint64
+}
+
+// startRelay passes the frames of the publisher at up on to the
+// subscribers at down, after edit has had its say: it returns the frames
+// to send instead of f, none to drop it.
+func startRelay(t *testing.T, up, down string, edit func(f wire.Frame) []wire.Frame) {
+	t.Helper()
+	out, _, err := newPublisherSocket([]string{down}, Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := newSubscriberSocket([]string{up}, Options{ReconnectTime: 10 * time.Millisecond}, nil)
+	if err == nil {
+		err = subscribe(in, "")
+	}
+	if err == nil {
+		err = in.SetOption(mangos.OptionRecvDeadline, receivePollInterval)
+	}
+	if err != nil {
+		out.Close()
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			raw, err := in.Recv()
+			switch socketError(err) {
+			case nil:
+			case ErrTimeout:
+				continue
+			default:
+				return
+			}
+			f, err := wire.Decode(raw)
+			if err != nil {
+				t.Errorf("relay: %v", err)
+				continue
+			}
+			for _, f := range edit(f) {
+				raw, err := wire.Encode(f)
+				if err != nil {
+					t.Errorf("relay: %v", err)
+					continue
+				}
+				out.Send(raw)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		in.Close()
+		out.Close()
+		<-done
+	})
+}
+
+// dropSeqs returns an edit function for startRelay that drops the frames
+// of topic with the given sequence numbers.
+func dropSeqs(topic string, seqs ...uint64) func(wire.Frame) []wire.Frame {
+	return func(f wire.Frame) []wire.Frame {
+		for _, seq := range seqs {
+			if f.Topic == topic && f.Header.Seq == seq {
+				return nil
+			}
+		}
+		return []wire.Frame{f}
+	}
+}
+
+// waitFlow publishes on the topic "ping" until s receives one, so that
+// messages get through all the connections between p and s. s must be
+// subscribed to "ping". Messages of other topics are not expected yet.
+func waitFlow(t *testing.T, p *Publisher, s *Subscriber) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := p.Publish("ping", ""); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		topic, _, err := s.ReceiveContext(ctx)
+		cancel()
+		if err == nil && topic == "ping" {
+			return
+		}
+	}
+	t.Fatal("no message got through")
+}
+
+// receiveTopics receives n messages from s, skipping pings that were
+// still underway after waitFlow.
+func receiveTopics(t *testing.T, s *Subscriber, n int) []Message {
+	t.Helper()
+	var msgs []Message
+	for len(msgs) < n {
+		msg := receiveN(t, s, 1)[0]
+		if msg.Topic != "ping" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// seqsOf returns the topics and sequence numbers of msgs, like "a1".
+func seqsOf(msgs []Message) []string {
+	var s []string
+	for _, msg := range msgs {
+		s = append(s, msg.Topic+string(rune('0'+msg.Seq)))
+	}
+	return s
+}
+
+// newGapSubscriber returns a subscriber of the relay at url and the gaps
+// that it reports.
+func newGapSubscriber(t *testing.T, url string, topics ...string) (*Subscriber, *[]gap) {
+	s := newTestSubscriber(t, url, Options{}, append(topics, "ping")...)
+	gaps := new([]gap)
+	s.OnGap(func(topic string, from, to uint64) {
+		if topic != "ping" {
+			*gaps = append(*gaps, gap{topic, from, to})
+		}
+	})
+	return s, gaps
+}
+
+func publishAll(t *testing.T, p *Publisher, topics ...string) {
+	t.Helper()
+	for _, topic := range topics {
+		if err := p.Publish(topic, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGapThroughRelay(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	startRelay(t, up, down, dropSeqs("a", 3, 4, 7))
+	s, gaps := newGapSubscriber(t, down, "a")
+	waitFlow(t, p, s)
+
+	publishAll(t, p, "a", "a", "a", "a", "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 5))
+	if want := []string{"a1", "a2", "a5", "a6", "a8"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if want := []gap{{"a", 3, 4}, {"a", 7, 7}}; !reflect.DeepEqual(*gaps, want) {
+		t.Errorf("gaps %v, want %v", *gaps, want)
+	}
+	if s.Missed() != 3 {
+		t.Errorf("Missed() = %d, want 3", s.Missed())
+	}
+}
+
+func TestGapInterleavedTopics(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	dropA, dropB := dropSeqs("a", 2), dropSeqs("b", 1, 3)
+	startRelay(t, up, down, func(f wire.Frame) []wire.Frame {
+		if f.Topic == "a" {
+			return dropA(f)
+		}
+		return dropB(f)
+	})
+	s, gaps := newGapSubscriber(t, down, "a", "b")
+	waitFlow(t, p, s)
+
+	publishAll(t, p, "a", "b", "a", "b", "a", "b", "b", "a")
+	got := seqsOf(receiveTopics(t, s, 5))
+	if want := []string{"a1", "b2", "a3", "b4", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	// The first message of a topic starts the sequence, so b1 is not
+	// known to be missing.
+	if want := []gap{{"a", 2, 2}, {"b", 3, 3}}; !reflect.DeepEqual(*gaps, want) {
+		t.Errorf("gaps %v, want %v", *gaps, want)
+	}
+	if s.Missed() != 2 {
+		t.Errorf("Missed() = %d, want 2", s.Missed())
+	}
+}
+
+func TestGapAfterPublisherRestart(t *testing.T) {
+	// Over inproc, the relay does not redial a publisher that comes back
+	// at the same address, so this test uses TCP.
+	p := newTestPublisher(t, "tcp://127.0.0.1:0", Options{})
+	up, down := p.Addr(), testURL(t)+"-down"
+	var restarted atomic.Bool
+	startRelay(t, up, down, func(f wire.Frame) []wire.Frame {
+		if restarted.Load() && f.Topic == "a" && f.Header.Seq == 3 {
+			return nil
+		}
+		return []wire.Frame{f}
+	})
+	s, gaps := newGapSubscriber(t, down, "a")
+	waitFlow(t, p, s)
+	publishAll(t, p, "a", "a", "a", "a", "a")
+	receiveTopics(t, s, 5)
+
+	p.Close()
+	restarted.Store(true)
+	p = newTestPublisher(t, up, Options{})
+	waitFlow(t, p, s)
+	publishAll(t, p, "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 3))
+	if want := []string{"a1", "a2", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v after the restart, want %v", got, want)
+	}
+	if want := []gap{{"a", 3, 3}}; !reflect.DeepEqual(*gaps, want) {
+		t.Errorf("gaps %v, want %v", *gaps, want)
+	}
+	if s.Missed() != 1 {
+		t.Errorf("Missed() = %d, want 1", s.Missed())
+	}
+}
+
+// Only repeats of the last-value cache are skipped. The same number again
+// without the flag is a message of its own, as when a forwarder merges
+// several publishers.
+func TestSeqRepeats(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	startRelay(t, up, down, func(f wire.Frame) []wire.Frame {
+		if f.Topic != "a" {
+			return []wire.Frame{f}
+		}
+		repeat := f
+		repeat.Header.Repeat = true
+		again := f
+		again.Payload = []byte("again")
+		return []wire.Frame{f, repeat, again}
+	})
+	s, gaps := newGapSubscriber(t, down, "a")
+	waitFlow(t, p, s)
+	filtered := s.Filtered()
+
+	publishAll(t, p, "a", "a")
+	msgs := receiveTopics(t, s, 4)
+	var got []string
+	for _, msg := range msgs {
+		got = append(got, string(rune('0'+msg.Seq))+string(msg.Payload))
+	}
+	if want := []string{"1", "1again", "2", "2again"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if n := s.Filtered() - filtered; n != 2 {
+		t.Errorf("%d messages filtered, want the 2 repeats", n)
+	}
+	if len(*gaps) != 0 {
+		t.Errorf("gaps %v, want none", *gaps)
+	}
+}
+
+func TestSeqLastValueCache(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{LastValueCache: true})
+	early := newTestSubscriber(t, url, Options{}, "a")
+	publishAll(t, p, "a")
+	if msg := receiveN(t, early, 1)[0]; msg.Seq != 1 || msg.Repeat {
+		t.Fatalf("got seq %d, repeat %v; want 1, false", msg.Seq, msg.Repeat)
+	}
+
+	late := newTestSubscriber(t, url, Options{}, "a")
+	if msg := receiveN(t, late, 1)[0]; msg.Seq != 1 || !msg.Repeat {
+		t.Errorf("late subscriber got seq %d, repeat %v; want 1, true", msg.Seq, msg.Repeat)
+	}
+	publishAll(t, p, "a")
+	if msg := receiveN(t, early, 1)[0]; msg.Seq != 2 {
+		t.Errorf("early subscriber got seq %d, want 2 after skipping the repeat", msg.Seq)
+	}
+	if early.Filtered() != 1 {
+		t.Errorf("early subscriber filtered %d, want 1", early.Filtered())
+	}
+}
+
+func TestSeqForgottenOnUnsubscribe(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s, gaps := newGapSubscriber(t, url, "a")
+	waitFlow(t, p, s)
+	publishAll(t, p, "a")
+	receiveTopics(t, s, 1)
+
+	if err := s.Unsubscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	publishAll(t, p, "a", "a")
+	waitFlow(t, p, s) // until the messages have passed
+	if err := s.Subscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitFlow(t, p, s)
+	publishAll(t, p, "a")
+	if msg := receiveTopics(t, s, 1)[0]; msg.Seq != 4 {
+		t.Errorf("got seq %d, want 4", msg.Seq)
+	}
+	if len(*gaps) != 0 || s.Missed() != 0 {
+		t.Errorf("gaps %v, missed %d; want none", *gaps, s.Missed())
+	}
+}
+
+// A pattern forgets the numbers of the topics that it alone matched, and
+// a topic that another subscription still matches keeps them.
+func TestSeqForgottenOnUnsubscribePattern(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s, gaps := newGapSubscriber(t, url, "b.y")
+	for _, pattern := range []string{"a.*", "b.*"} {
+		if err := s.SubscribePattern(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFlow(t, p, s)
+	publishAll(t, p, "a.x", "b.y")
+	receiveTopics(t, s, 2)
+
+	for _, pattern := range []string{"a.*", "b.*"} {
+		if err := s.UnsubscribePattern(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishAll(t, p, "a.x", "a.x")
+	waitFlow(t, p, s) // until the messages have passed
+	if err := s.SubscribePattern("a.*"); err != nil {
+		t.Fatal(err)
+	}
+	waitFlow(t, p, s)
+	publishAll(t, p, "a.x", "b.y")
+	if got := seqsOf(receiveTopics(t, s, 2)); !reflect.DeepEqual(got, []string{"a.x4", "b.y2"}) {
+		t.Errorf("received %v, want [a.x4 b.y2]", got)
+	}
+	if len(*gaps) != 0 || s.Missed() != 0 {
+		t.Errorf("gaps %v, missed %d; want none", *gaps, s.Missed())
+	}
+}
+
+// Behind a forwarder that merges publishers or samples a topic, the
+// numbers do not count.
+func TestSeqForwarderUnsequenced(t *testing.T) {
+	up1, up2, down := testURL(t)+"-up1", testURL(t)+"-up2", testURL(t)+"-down"
+	p1 := newTestPublisher(t, up1, Options{})
+	p2 := newTestPublisher(t, up2, Options{})
+	f, err := NewForwarder([]string{up1, up2}, Options{}, []string{down}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.SetRules([]ForwardRule{{Match: "sampled", Sample: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	go f.Run(context.Background())
+	s, gaps := newGapSubscriber(t, down, "a", "sampled")
+	waitFlow(t, p1, s)
+	waitFlow(t, p2, s)
+
+	publishAll(t, p1, "a", "a", "sampled", "sampled", "sampled")
+	publishAll(t, p2, "a")
+	msgs := receiveTopics(t, s, 5)
+	for _, msg := range msgs {
+		if msg.Seq != 0 {
+			t.Errorf("%s arrived with seq %d, want 0", msg.Topic, msg.Seq)
+		}
+	}
+	if len(*gaps) != 0 || s.Missed() != 0 {
+		t.Errorf("gaps %v, missed %d; want none", *gaps, s.Missed())
+	}
+}
@@ -0,0 +1,225 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Topics have different paces: a heartbeat comes every second, invoices a
+// few times a day. The receive deadline (see SetRecvDeadline) applies to
+// all of them at once. With ExpectEvery, a subscriber watches the topics
+// one by one instead: if no message of a topic arrives within the
+// expected interval plus some slack, the topic is stale. The subscriber
+// then calls the function set with OnStale, and again once a message
+// arrives and the topic is fresh again.
+//
+// A message counts for the topic it was published to and for all topics
+// above it, like a subscription does. The watch starts with ExpectEvery,
+// not with the first message. It runs on timers of its own, but a message
+// counts only once it is received: when Receive returns it, or when it is
+// passed to a handler or the Messages channel.
+//
+// Health fails while a topic marked with SetCritical is stale, for
+// example to fail a health check of a service.
+//
+// For topics without a known pace, see ExpectAdaptive in idle.go.
+
+// ErrStale is returned by Health while a critical topic is stale.
+var ErrStale = errors.New("critical topic is stale")
+
+// expectation is the pace that ExpectEvery or ExpectAdaptive set for a
+// topic.
+type expectation struct {
+	every, slack time.Duration
+	idle         *idleEstimator // for ExpectAdaptive, see idle.go
+	last         time.Time      // of the last message, or of the Expect call
+	stale        bool
+	timer        *time.Timer
+}
+
+// limit returns how long the topic may be quiet after the last message.
+// It returns false if there is no limit yet.
+func (e *expectation) limit() (time.Duration, bool) {
+	if e.idle != nil {
+		return e.idle.limit()
+	}
+	return e.every + e.slack, true
+}
+
+// arm sets the timer of e to expire when the topic has been quiet for too
+// long, or stops it if there is no limit yet.
+func (e *expectation) arm() {
+	limit, ok := e.limit()
+	if !ok {
+		e.timer.Stop()
+		return
+	}
+	e.timer.Reset(time.Until(e.last.Add(limit)))
+}
+
+// ExpectEvery sets the pace of topic: a message every d, and the topic is
+// stale after d plus slack without a message. It can be called again to
+// change the pace, which then counts from the last message. It replaces a
+// pace set with ExpectAdaptive. A d of zero or less stops watching the
+// topic.
+func (s *Subscriber) ExpectEvery(topic string, d, slack time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d <= 0 {
+		if e := s.expect[topic]; e != nil {
+			e.timer.Stop()
+			delete(s.expect, topic)
+		}
+		return
+	}
+	e := s.expectation(topic)
+	e.every, e.slack, e.idle = d, slack, nil
+	e.arm()
+}
+
+// expectation returns the watch of topic, and starts one if there is
+// none. s.mu must be held.
+func (s *Subscriber) expectation(topic string) *expectation {
+	e := s.expect[topic]
+	if e == nil {
+		e = &expectation{last: time.Now()}
+		e.timer = time.AfterFunc(time.Hour, func() { s.checkStale(topic, e) })
+		e.timer.Stop()
+		s.expect[topic] = e
+	}
+	return e
+}
+
+// SetCritical marks topic as critical or not. Health fails while a
+// critical topic is stale. A topic can be marked before or after
+// ExpectEvery.
+func (s *Subscriber) SetCritical(topic string, critical bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if critical {
+		s.critical[topic] = true
+	} else {
+		delete(s.critical, topic)
+	}
+}
+
+// OnStale sets a function that is called with stale set to true when
+// topic becomes stale, and with false when a message of the topic arrives
+// after that. last is the time of the last message, or of ExpectEvery if
+// none has arrived. It is called from a timer goroutine or a receiving
+// goroutine and must return quickly.
+func (s *Subscriber) OnStale(fn func(topic string, stale bool, last time.Time)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStale = fn
+}
+
+// Stale returns the topics that are stale, sorted.
+func (s *Subscriber) Stale() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.staleTopics(false)
+}
+
+// Health returns an error that wraps ErrStale and names the critical
+// topics that are stale, or nil if there are none.
+func (s *Subscriber) Health() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if topics := s.staleTopics(true); len(topics) > 0 {
+		return fmt.Errorf("%w: %s", ErrStale, strings.Join(topics, ", "))
+	}
+	return nil
+}
+
+// staleTopics returns the stale topics, or only the critical ones, sorted.
+// s.mu must be held.
+func (s *Subscriber) staleTopics(critical bool) []string {
+	var topics []string
+	for topic, e := range s.expect {
+		if e.stale && (!critical || s.critical[topic]) {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// checkStale runs when the timer of e expires.
+func (s *Subscriber) checkStale(topic string, e *expectation) {
+	s.mu.Lock()
+	if s.closed || s.expect[topic] != e || e.stale {
+		s.mu.Unlock()
+		return
+	}
+	// A message may have arrived while the timer fired.
+	limit, ok := e.limit()
+	if wait := time.Until(e.last.Add(limit)); !ok || wait > 0 {
+		e.arm()
+		s.mu.Unlock()
+		return
+	}
+	e.stale = true
+	fn, last := s.onStale, e.last
+	s.mu.Unlock()
+	logger().Warn("Topic is stale", "topic", topic, "last", last)
+	if fn != nil {
+		fn(topic, true, last)
+	}
+}
+
+// restartWatches restarts the watch of the topics that msg, which arrived
+// at now, counts for, and returns those that are fresh again. s.mu must be
+// held.
+func (s *Subscriber) restartWatches(msg Message, now time.Time) (fresh []string) {
+	for topic, e := range s.expect {
+		if !countsFor(msg.Topic, topic) {
+			continue
+		}
+		e.last = now
+		if e.idle != nil {
+			e.idle.observe(now)
+		}
+		e.arm()
+		if e.stale {
+			e.stale = false
+			fresh = append(fresh, topic)
+		}
+	}
+	return fresh
+}
+
+// reportFresh reports the topics that are fresh again since now.
+func (s *Subscriber) reportFresh(fresh []string, now time.Time) {
+	if len(fresh) == 0 {
+		return
+	}
+	s.mu.Lock()
+	fn := s.onStale
+	s.mu.Unlock()
+	for _, topic := range fresh {
+		logger().Info("Topic is fresh again", "topic", topic)
+		if fn != nil {
+			fn(topic, false, now)
+		}
+	}
+}
+
+// countsFor reports whether a message of topic counts for the topic t,
+// which is t itself or above it.
+func countsFor(topic, t string) bool {
+	if t == "" || topic == t {
+		return true
+	}
+	return strings.HasPrefix(topic, t) && strings.ContainsRune("./", rune(topic[len(t)]))
+}
+
+// stopExpectations stops the timers of ExpectEvery. s.mu must be held.
+func (s *Subscriber) stopExpectations() {
+	for _, e := range s.expect {
+		e.timer.Stop()
+	}
+}
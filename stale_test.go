@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staleEvent is a call of the function set with OnStale.
+type staleEvent struct {
+	topic string
+	stale bool
+}
+
+// watchStale returns a channel of the calls of the function set with
+// OnStale.
+func watchStale(s *Subscriber) <-chan staleEvent {
+	ch := make(chan staleEvent, 10)
+	s.OnStale(func(topic string, stale bool, last time.Time) {
+		ch <- staleEvent{topic, stale}
+	})
+	return ch
+}
+
+func nextStale(t *testing.T, ch <-chan staleEvent) staleEvent {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnStale not called")
+	}
+	return staleEvent{}
+}
+
+// A critical topic fails the health check while it is stale, and a
+// message of a topic below it clears it.
+func TestStaleCritical(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "hb", "ping")
+	waitFlow(t, p, s)
+	events := watchStale(s)
+	s.SetCritical("hb", true)
+	s.ExpectEvery("hb", 50*time.Millisecond, 20*time.Millisecond)
+
+	if e := nextStale(t, events); e != (staleEvent{"hb", true}) {
+		t.Fatalf("OnStale got %v, want hb stale", e)
+	}
+	if err := s.Health(); !errors.Is(err, ErrStale) || !strings.Contains(err.Error(), "hb") {
+		t.Errorf("Health() = %v, want ErrStale for hb", err)
+	}
+	if got := s.Stale(); !reflect.DeepEqual(got, []string{"hb"}) {
+		t.Errorf("Stale() = %v, want [hb]", got)
+	}
+
+	publishAll(t, p, "hb.1")
+	receiveTopics(t, s, 1)
+	if e := nextStale(t, events); e != (staleEvent{"hb", false}) {
+		t.Fatalf("OnStale got %v, want hb fresh", e)
+	}
+	if err := s.Health(); err != nil {
+		t.Errorf("Health() = %v after a message, want nil", err)
+	}
+}
+
+// A stale topic that is not critical does not fail the health check, and
+// the watch is independent of the receive deadline.
+func TestStaleRelaxed(t *testing.T) {
+	url := testURL(t)
+	newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "invoices")
+	s.SetRecvDeadline(time.Hour)
+	events := watchStale(s)
+	s.ExpectEvery("invoices", 20*time.Millisecond, 0)
+
+	if e := nextStale(t, events); e != (staleEvent{"invoices", true}) {
+		t.Fatalf("OnStale got %v, want invoices stale", e)
+	}
+	if err := s.Health(); err != nil {
+		t.Errorf("Health() = %v, want nil for a relaxed topic", err)
+	}
+}
+
+// The pace can change at runtime, and counts from the last message.
+func TestStaleAdjust(t *testing.T) {
+	url := testURL(t)
+	newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "a")
+	events := watchStale(s)
+	s.ExpectEvery("a", time.Hour, 0)
+	time.Sleep(50 * time.Millisecond)
+	if got := s.Stale(); len(got) != 0 {
+		t.Fatalf("Stale() = %v with an hourly pace", got)
+	}
+
+	// The new pace has passed since ExpectEvery already.
+	s.ExpectEvery("a", 10*time.Millisecond, 10*time.Millisecond)
+	if e := nextStale(t, events); e != (staleEvent{"a", true}) {
+		t.Fatalf("OnStale got %v, want a stale", e)
+	}
+
+	s.ExpectEvery("a", 0, 0)
+	if got := s.Stale(); len(got) != 0 {
+		t.Errorf("Stale() = %v after ExpectEvery with 0", got)
+	}
+}
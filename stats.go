@@ -0,0 +1,78 @@
+package pubsub
+
+import "time"
+
+// Metrics count messages; stats also tell when they arrived. For each
+// topic, a subscriber notes when the first and the last message came in
+// and how far apart the messages were, which shows whether a publisher
+// keeps up its pace.
+
+// TopicStats describes the messages of one topic that a subscriber
+// received.
+type TopicStats struct {
+	// Messages and Bytes count the messages and their frames, like
+	// Metrics.Received and Metrics.BytesIn.
+	Messages uint64
+	Bytes    uint64
+
+	// First and Last are the times when the first and the last message
+	// arrived.
+	First time.Time
+	Last  time.Time
+
+	// MinInterval, MaxInterval, and MeanInterval describe the time
+	// between two consecutive messages. They are zero until the second
+	// message arrives.
+	MinInterval  time.Duration
+	MaxInterval  time.Duration
+	MeanInterval time.Duration
+
+	// Matched and LastMatch are set for the topics, patterns, and
+	// templates that the subscriber is subscribed to: the number of
+	// messages that the subscription matched, and when it matched the last
+	// one (see usage.go). The other fields count only the messages of the
+	// topic itself.
+	Matched   uint64
+	LastMatch time.Time
+}
+
+// add records a message of size bytes that arrived at now.
+func (t *TopicStats) add(size int, now time.Time) {
+	if t.Messages == 0 {
+		t.First = now
+	} else {
+		d := now.Sub(t.Last)
+		if t.Messages == 1 || d < t.MinInterval {
+			t.MinInterval = d
+		}
+		if d > t.MaxInterval {
+			t.MaxInterval = d
+		}
+		t.MeanInterval = now.Sub(t.First) / time.Duration(t.Messages)
+	}
+	t.Last = now
+	t.Messages++
+	t.Bytes += uint64(size)
+}
+
+// Stats returns the statistics of the subscriber per topic, and for each
+// subscription, even one that has not matched any message yet. The map is
+// a copy; Stats can be called while another goroutine receives.
+func (s *Subscriber) Stats() map[string]TopicStats {
+	m := s.recv.metrics
+	m.mu.Lock()
+	stats := make(map[string]TopicStats, len(m.topics))
+	for t, st := range m.topics {
+		stats[t] = *st
+	}
+	m.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, u := range s.usage {
+		st := stats[name]
+		st.Matched, st.LastMatch = u.matched, u.lastMatch
+		stats[name] = st
+	}
+	return stats
+}
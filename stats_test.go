@@ -0,0 +1,71 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopicStatsAdd(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var st TopicStats
+	for _, offset := range []time.Duration{0, 100 * time.Millisecond, 400 * time.Millisecond, 450 * time.Millisecond} {
+		st.add(10, start.Add(offset))
+	}
+	want := TopicStats{
+		Messages:     4,
+		Bytes:        40,
+		First:        start,
+		Last:         start.Add(450 * time.Millisecond),
+		MinInterval:  50 * time.Millisecond,
+		MaxInterval:  300 * time.Millisecond,
+		MeanInterval: 150 * time.Millisecond,
+	}
+	if st != want {
+		t.Errorf("stats %+v, want %+v", st, want)
+	}
+
+	var one TopicStats
+	one.add(5, start)
+	if one.MinInterval != 0 || one.MaxInterval != 0 || one.MeanInterval != 0 || one.First != one.Last {
+		t.Errorf("stats after one message %+v, want no intervals", one)
+	}
+}
+
+// Stats agree with the metrics of the subscriber, and list subscriptions
+// with the messages they matched.
+func TestStatsCount(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "a", "ping")
+	if err := s.SubscribePattern("b.*"); err != nil {
+		t.Fatal(err)
+	}
+	waitFlow(t, p, s)
+
+	start := time.Now()
+	publishPayloads(t, p, "a", "1", "22")
+	publishPayloads(t, p, "b.x", "333")
+	publishPayloads(t, p, "b.y", "4444")
+	receiveTopics(t, s, 4)
+
+	stats, m := s.Stats(), s.Metrics()
+	for _, topic := range []string{"a", "b.x", "b.y"} {
+		st := stats[topic]
+		if st.Messages != m.Received[topic] || st.Messages == 0 || st.First.Before(start) || st.Last.Before(st.First) {
+			t.Errorf("Stats()[%s] = %+v, want %d messages since the start", topic, st, m.Received[topic])
+		}
+	}
+	if a := stats["a"]; a.Messages != 2 || a.MinInterval > a.MaxInterval || a.Matched != 2 {
+		t.Errorf("Stats()[a] = %+v, want 2 messages, matched by the subscription", a)
+	}
+	if pat := stats["b.*"]; pat.Matched != 2 || pat.Messages != 0 {
+		t.Errorf("Stats()[b.*] = %+v, want 2 matches and no messages of its own", pat)
+	}
+	var bytes uint64
+	for _, st := range stats {
+		bytes += st.Bytes
+	}
+	if bytes != m.BytesIn {
+		t.Errorf("Stats() count %d bytes, Metrics() %d", bytes, m.BytesIn)
+	}
+}
@@ -0,0 +1,485 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// File layout
+//
+// Every namespace lives in its own file "<namespace>.log" inside the
+// storage directory. The file starts with a 16-byte header:
+//
+//	magic      8 bytes  "PSSTORE1"
+//	first      8 bytes  index of the first record in the file, big endian
+//
+// followed by the records, each framed as
+//
+//	length     4 bytes  length of data, big endian
+//	checksum   4 bytes  CRC-32C of data, big endian
+//	data       length bytes
+//
+// A crash in the middle of an append can leave a torn record at the end of
+// the file, and a crash right after creating a file can leave it with a
+// short header or none at all; such a file counts as empty. When a namespace is opened, the records are scanned, and
+// everything from the first incomplete or mismatching record on is cut off.
+// ReadFile scans the same way, but leaves the file alone.
+//
+// TruncateBefore writes the retained records to "<namespace>.log.tmp" and
+// renames it over the original, so a crash leaves either the old or the new
+// file in place. Leftover temporary files are removed on open.
+
+const (
+	fileMagic   = "PSSTORE1"
+	headerSize  = 16
+	frameSize   = 8
+	logSuffix   = ".log"
+	tmpSuffix   = ".log.tmp"
+	maxRecordSz = 1 << 30
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// file is the file-based Storage implementation.
+type file struct {
+	dir string
+
+	mu     sync.Mutex
+	logs   map[string]*fileLog
+	closed bool
+}
+
+// fileLog is one open namespace file.
+// offsets[i] is the file offset of the record with index first+i.
+type fileLog struct {
+	mu      sync.RWMutex
+	path    string
+	f       *os.File
+	first   uint64
+	offsets []int64
+	size    int64
+}
+
+// NewFile returns a Storage that keeps each namespace in a file in dir.
+// The directory is created if it does not exist.
+func NewFile(dir string) (Storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &file{dir: dir, logs: make(map[string]*fileLog)}, nil
+}
+
+// log returns the open namespace ns, opening or creating its file first
+// if necessary.
+func (s *file) log(ns string) (*fileLog, error) {
+	if err := checkNamespace(ns); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if l := s.logs[ns]; l != nil {
+		return l, nil
+	}
+	l, err := openLog(filepath.Join(s.dir, ns+logSuffix))
+	if err != nil {
+		return nil, err
+	}
+	s.logs[ns] = l
+	return l, nil
+}
+
+// openLog opens or creates a namespace file and scans its records.
+func openLog(path string) (*fileLog, error) {
+	os.Remove(path[:len(path)-len(logSuffix)] + tmpSuffix)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &fileLog{path: path, f: f}
+	if err := l.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// load reads the header (writing one to an empty file or over a torn
+// one), indexes all complete records, and cuts off a torn tail.
+func (l *fileLog) load() error {
+	var hdr [headerSize]byte
+	n, err := io.ReadFull(l.f, hdr[:])
+	switch {
+	case tornHeader(hdr[:n], err):
+		// A crash while the file was created.
+		if err := l.f.Truncate(0); err != nil {
+			return err
+		}
+		return l.writeHeader(1)
+	case err == io.ErrUnexpectedEOF:
+		return ErrCorrupt
+	case err != nil:
+		return err
+	case string(hdr[:8]) != fileMagic:
+		return ErrCorrupt
+	}
+	l.first = binary.BigEndian.Uint64(hdr[8:])
+	end, err := scanRecords(l.f, func(off int64, _ []byte) error {
+		l.offsets = append(l.offsets, off)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return l.cut(end)
+}
+
+// tornHeader tells whether hdr, which was read with err, is what a crash
+// can leave of a header: nothing at all, or a short start of one. The file
+// holds nothing else then.
+func tornHeader(hdr []byte, err error) bool {
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	magic := hdr
+	if len(magic) > len(fileMagic) {
+		magic = magic[:len(fileMagic)]
+	}
+	return strings.HasPrefix(fileMagic, string(magic))
+}
+
+// scanRecords calls fn with the offset and data of each complete record
+// in f, which starts with a header. It returns the offset after the last
+// complete record, where a torn record would begin, or the error from fn.
+func scanRecords(f io.ReaderAt, fn func(off int64, data []byte) error) (int64, error) {
+	// Reading goes through a buffer, so the offsets are tracked by hand.
+	r := &countingReader{
+		r: bufio.NewReaderSize(io.NewSectionReader(f, headerSize, 1<<62), 64*1024),
+		n: headerSize,
+	}
+	var frame [frameSize]byte
+	for {
+		off := r.n
+		if _, err := io.ReadFull(r, frame[:]); err != nil {
+			return off, nil
+		}
+		sz := binary.BigEndian.Uint32(frame[:4])
+		if sz > maxRecordSz {
+			return off, nil
+		}
+		data := make([]byte, sz)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return off, nil
+		}
+		if crc32.Checksum(data, castagnoli) != binary.BigEndian.Uint32(frame[4:]) {
+			return off, nil
+		}
+		if err := fn(off, data); err != nil {
+			return off, err
+		}
+	}
+}
+
+// ReadFile calls fn for each complete record of the namespace file at
+// path, in index order, without opening a Storage. Unlike opening the
+// namespace, it does not change the file: it stops at a torn record and
+// returns the number of bytes from there to the end of the file, which a
+// writer may still be working on. A file with only the start of a header
+// counts as empty, as when the namespace is opened. If fn returns an error, ReadFile stops and returns it. fn must
+// not retain rec after it returns.
+func ReadFile(path string, fn func(idx uint64, rec []byte) error) (torn int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var hdr [headerSize]byte
+	n, err := io.ReadFull(f, hdr[:])
+	switch {
+	case tornHeader(hdr[:n], err):
+		return int64(n), nil
+	case err == io.ErrUnexpectedEOF:
+		return 0, ErrCorrupt
+	case err != nil:
+		return 0, err
+	case string(hdr[:8]) != fileMagic:
+		return 0, ErrCorrupt
+	}
+	idx := binary.BigEndian.Uint64(hdr[8:])
+	end, err := scanRecords(f, func(_ int64, data []byte) error {
+		idx++
+		return fn(idx-1, data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size() - end, nil
+}
+
+// cut truncates the file at off, discarding anything after the last good
+// record, and positions the write offset there.
+func (l *fileLog) cut(off int64) error {
+	if err := l.f.Truncate(off); err != nil {
+		return err
+	}
+	l.size = off
+	return nil
+}
+
+// writeHeader initializes an empty file.
+func (l *fileLog) writeHeader(first uint64) error {
+	var hdr [headerSize]byte
+	copy(hdr[:8], fileMagic)
+	binary.BigEndian.PutUint64(hdr[8:], first)
+	if _, err := l.f.WriteAt(hdr[:], 0); err != nil {
+		return err
+	}
+	l.first = first
+	l.size = headerSize
+	return nil
+}
+
+func (s *file) Append(ns string, rec []byte) (uint64, error) {
+	l, err := s.log(ns)
+	if err != nil {
+		return 0, err
+	}
+	if len(rec) > maxRecordSz {
+		return 0, ErrCorrupt
+	}
+
+	// Frame and data go out in a single write to keep the window for a
+	// torn record as small as possible.
+	buf := make([]byte, frameSize+len(rec))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(rec)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(rec, castagnoli))
+	copy(buf[frameSize:], rec)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, ErrClosed
+	}
+	if _, err := l.f.WriteAt(buf, l.size); err != nil {
+		// Whatever made it to disk will be cut off as a torn record
+		// when the file is opened next time.
+		return 0, err
+	}
+	l.offsets = append(l.offsets, l.size)
+	l.size += int64(len(buf))
+	return l.first + uint64(len(l.offsets)) - 1, nil
+}
+
+func (s *file) ReadRange(ns string, from, to uint64, fn func(uint64, []byte) error) error {
+	l, err := s.log(ns)
+	if err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	f, first, offsets, size := l.f, l.first, l.offsets, l.size
+	l.mu.RUnlock()
+	if f == nil {
+		return ErrClosed
+	}
+
+	next := first + uint64(len(offsets))
+	if from < first {
+		from = first
+	}
+	if to == 0 || to > next {
+		to = next
+	}
+	for idx := from; idx < to; idx++ {
+		i := idx - first
+		end := size
+		if i+1 < uint64(len(offsets)) {
+			end = offsets[i+1]
+		}
+		buf := make([]byte, end-offsets[i])
+		if _, err := f.ReadAt(buf, offsets[i]); err != nil {
+			// The file may have been replaced by TruncateBefore
+			// in the meantime.
+			return err
+		}
+		if err := fn(idx, buf[frameSize:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *file) Bounds(ns string) (uint64, uint64, error) {
+	l, err := s.log(ns)
+	if err != nil {
+		return 0, 0, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.first, l.first + uint64(len(l.offsets)), nil
+}
+
+func (s *file) Sync(ns string) error {
+	l, err := s.log(ns)
+	if err != nil {
+		return err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	return l.f.Sync()
+}
+
+func (s *file) TruncateBefore(ns string, idx uint64) error {
+	l, err := s.log(ns)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	if idx <= l.first {
+		return nil
+	}
+
+	// Everything from offset start on is retained.
+	n := idx - l.first
+	start := l.size
+	if n < uint64(len(l.offsets)) {
+		start = l.offsets[n]
+	} else {
+		n = uint64(len(l.offsets))
+	}
+
+	tmp := l.path[:len(l.path)-len(logSuffix)] + tmpSuffix
+	t, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	nl := &fileLog{path: l.path, f: t}
+	err = nl.writeHeader(l.first + n)
+	if err == nil {
+		_, err = io.Copy(&offsetWriter{f: t, off: headerSize}, io.NewSectionReader(l.f, start, l.size-start))
+	}
+	if err == nil {
+		err = t.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+	if err != nil {
+		t.Close()
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(l.path))
+
+	l.f.Close()
+	l.f = t
+	l.first += n
+	shifted := make([]int64, 0, uint64(len(l.offsets))-n)
+	for _, off := range l.offsets[n:] {
+		shifted = append(shifted, off-start+headerSize)
+	}
+	l.offsets = shifted
+	l.size = l.size - start + headerSize
+	return nil
+}
+
+func (s *file) Namespaces() ([]string, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasSuffix(name, logSuffix) {
+			names = append(names, strings.TrimSuffix(name, logSuffix))
+		}
+	}
+	return names, nil
+}
+
+// Close syncs and closes all namespace files. It returns the first error
+// encountered but always attempts to close every file.
+func (s *file) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.closed = true
+
+	var first error
+	for _, l := range s.logs {
+		l.mu.Lock()
+		if err := l.f.Sync(); err != nil && first == nil {
+			first = err
+		}
+		if err := l.f.Close(); err != nil && first == nil {
+			first = err
+		}
+		l.f = nil
+		l.mu.Unlock()
+	}
+	s.logs = nil
+	return first
+}
+
+// syncDir makes a rename in dir durable. Not all platforms support
+// syncing a directory, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// countingReader tracks how many bytes have been read, starting at n.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// offsetWriter writes sequentially to f, starting at off.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
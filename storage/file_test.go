@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// crashRecord is the record with index idx that the crash tests write.
+// Records differ in length and content, so that a mix-up shows.
+func crashRecord(idx uint64) []byte {
+	return bytes.Repeat([]byte(strconv.FormatUint(idx, 10)+";"), int(idx%17))
+}
+
+// checkRecords checks that ns in dir holds the records 1 to n, at least
+// want of them, and that it takes a new one after them. It returns n.
+func checkRecords(t *testing.T, dir, ns string, want uint64) uint64 {
+	t.Helper()
+	s, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	first, next, err := s.Bounds(ns)
+	if err != nil {
+		t.Fatalf("opening %s: %v", ns, err)
+	}
+	if first != 1 || next-1 < want {
+		t.Fatalf("%s holds the records %d to %d, want 1 to at least %d", ns, first, next-1, want)
+	}
+	err = s.ReadRange(ns, 0, 0, func(idx uint64, rec []byte) error {
+		if !bytes.Equal(rec, crashRecord(idx)) {
+			return fmt.Errorf("record %d is %q", idx, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx, err := s.Append(ns, crashRecord(next)); err != nil || idx != next {
+		t.Fatalf("Append after opening = %d, %v; want %d", idx, err, next)
+	}
+	return next - 1
+}
+
+// writeRecords writes a file with the records 1 to n to dir and returns
+// its contents.
+func writeRecords(t *testing.T, dir string, n uint64) []byte {
+	t.Helper()
+	s, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := uint64(1); idx <= n; idx++ {
+		if _, err := s.Append("ns", crashRecord(idx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "ns.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// A crash can stop a write after any byte. Whatever is left, opening the
+// namespace keeps the complete records, and ReadFile reads them without
+// changing the file.
+func TestTornFile(t *testing.T) {
+	full := writeRecords(t, t.TempDir(), 5)
+	var ends []int // where each record ends
+	for off := headerSize; off < len(full); {
+		off += frameSize + len(crashRecord(uint64(len(ends)+1)))
+		ends = append(ends, off)
+	}
+	for size := 0; size <= len(full); size++ {
+		complete := uint64(0)
+		for _, end := range ends {
+			if end <= size {
+				complete++
+			}
+		}
+		dir := t.TempDir()
+		path := filepath.Join(dir, "ns.log")
+		if err := os.WriteFile(path, full[:size], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var read uint64
+		torn, err := ReadFile(path, func(idx uint64, rec []byte) error {
+			read++
+			if idx != read || !bytes.Equal(rec, crashRecord(idx)) {
+				return fmt.Errorf("record %d is %d: %q", read, idx, rec)
+			}
+			return nil
+		})
+		if err != nil || read != complete {
+			t.Fatalf("size %d: ReadFile read %d records, %v; want %d", size, read, err, complete)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() != int64(size) {
+			t.Fatalf("size %d: ReadFile changed the file", size)
+		}
+		wantTorn := size - headerSize
+		if complete > 0 {
+			wantTorn = size - ends[complete-1]
+		}
+		if size < headerSize {
+			wantTorn = size
+		}
+		if torn != int64(wantTorn) {
+			t.Errorf("size %d: ReadFile reports %d torn bytes, want %d", size, torn, wantTorn)
+		}
+
+		if n := checkRecords(t, dir, "ns", complete); n != complete {
+			t.Errorf("size %d: %d records after opening, want %d", size, n, complete)
+		}
+	}
+}
+
+func TestCorruptHeader(t *testing.T) {
+	for _, content := range []string{"XYZ", "PSSTORE2", "PSSTORE2 and more than a header", "not a storage file at all"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "ns.log")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewFile(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Bounds("ns"); err != ErrCorrupt {
+			t.Errorf("opening %q: %v, want ErrCorrupt", content, err)
+		}
+		s.Close()
+		if _, err := ReadFile(path, nil); err != ErrCorrupt {
+			t.Errorf("ReadFile of %q: %v, want ErrCorrupt", content, err)
+		}
+	}
+}
+
+// The crash tests run this test in a child process, which appends records
+// until it is killed. After each Sync, it writes the index of the last
+// record to the file "synced". (A pipe would fill up and block the child
+// at the same point every time.)
+func TestCrashChild(t *testing.T) {
+	dir := os.Getenv("STORAGE_CRASH_DIR")
+	if dir == "" {
+		t.Skip("only runs as the child of TestKillDuringWrite")
+	}
+	s, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, next, err := s.Bounds("ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := next; ; idx++ {
+		if _, err := s.Append("ns", crashRecord(idx)); err != nil {
+			t.Fatal(err)
+		}
+		if idx%10 == 0 {
+			if err := s.Sync("ns"); err != nil {
+				t.Fatal(err)
+			}
+			tmp := filepath.Join(dir, "synced.tmp")
+			err := os.WriteFile(tmp, []byte(strconv.FormatUint(idx, 10)), 0644)
+			if err == nil {
+				err = os.Rename(tmp, filepath.Join(dir, "synced"))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// A process that is killed while it appends leaves a namespace that
+// opens with all synced records and possibly some more, but never a
+// broken one.
+func TestKillDuringWrite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts processes")
+	}
+	dir := t.TempDir()
+	for run := 0; run < 5; run++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCrashChild$")
+		cmd.Env = append(os.Environ(), "STORAGE_CRASH_DIR="+dir)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// Kill the child at a different point in each run.
+		time.Sleep(time.Duration(20+run*13) * time.Millisecond)
+		cmd.Process.Kill()
+		cmd.Wait()
+		// The child may have been killed between a Sync and writing
+		// the index, so more records than this may be synced.
+		b, err := os.ReadFile(filepath.Join(dir, "synced"))
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		synced, _ := strconv.ParseUint(string(b), 10, 64)
+		if synced == 0 {
+			t.Fatalf("run %d: the child synced nothing", run)
+		}
+		// checkRecords appends one record, as the next child would.
+		checkRecords(t, dir, "ns", synced)
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import "sync"
+
+// memory is the in-memory Storage implementation.
+type memory struct {
+	mu     sync.RWMutex
+	logs   map[string]*memLog
+	closed bool
+}
+
+// memLog holds the records of one namespace. recs[0] has index first.
+type memLog struct {
+	first uint64
+	recs  [][]byte
+}
+
+// NewMemory returns a Storage that keeps all records in memory.
+// Sync is a no-op, and everything is lost on Close.
+func NewMemory() Storage {
+	return &memory{logs: make(map[string]*memLog)}
+}
+
+func (m *memory) Append(ns string, rec []byte) (uint64, error) {
+	if err := checkNamespace(ns); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	l := m.logs[ns]
+	if l == nil {
+		l = &memLog{first: 1}
+		m.logs[ns] = l
+	}
+	l.recs = append(l.recs, append([]byte(nil), rec...))
+	return l.first + uint64(len(l.recs)) - 1, nil
+}
+
+func (m *memory) ReadRange(ns string, from, to uint64, fn func(uint64, []byte) error) error {
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return ErrClosed
+	}
+	l := m.logs[ns]
+	if l == nil {
+		m.mu.RUnlock()
+		return nil
+	}
+	// Take a snapshot so that fn may call back into the storage.
+	first, recs := l.first, l.recs
+	m.mu.RUnlock()
+
+	next := first + uint64(len(recs))
+	if from < first {
+		from = first
+	}
+	if to == 0 || to > next {
+		to = next
+	}
+	for idx := from; idx < to; idx++ {
+		if err := fn(idx, recs[idx-first]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memory) Bounds(ns string) (uint64, uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return 0, 0, ErrClosed
+	}
+	l := m.logs[ns]
+	if l == nil {
+		return 1, 1, nil
+	}
+	return l.first, l.first + uint64(len(l.recs)), nil
+}
+
+func (m *memory) Sync(ns string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (m *memory) TruncateBefore(ns string, idx uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	l := m.logs[ns]
+	if l == nil || idx <= l.first {
+		return nil
+	}
+	n := idx - l.first
+	if n > uint64(len(l.recs)) {
+		n = uint64(len(l.recs))
+	}
+	// Copy rather than reslice so that the dropped records can be
+	// garbage collected.
+	l.recs = append([][]byte(nil), l.recs[n:]...)
+	l.first += n
+	return nil
+}
+
+func (m *memory) Namespaces() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	names := make([]string, 0, len(m.logs))
+	for ns := range m.logs {
+		names = append(names, ns)
+	}
+	return names, nil
+}
+
+func (m *memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.closed = true
+	m.logs = nil
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package storage defines the persistence backend used by the disk-backed
+// features of pubsub (message journal, outbox spool, durable subscriber
+// offsets, idempotency store), so that none of them has to invent a file
+// format of its own.
+//
+// A Storage holds any number of namespaces. Each namespace is an
+// append-only log of opaque records, addressed by a monotonically
+// increasing index that starts at 1. Records can be read back by index
+// range, and old records can be discarded from the front of the log.
+//
+// Two implementations come with the package: NewFile stores every
+// namespace in its own file, and NewMemory keeps everything in memory,
+// which is handy for tests. Users who prefer bolt, badger, sqlite, or
+// anything else only need to implement the Storage interface.
+package storage
+
+import (
+	"errors"
+	"regexp"
+)
+
+// Errors returned by Storage implementations.
+var (
+	ErrClosed       = errors.New("storage: closed")
+	ErrBadNamespace = errors.New("storage: invalid namespace name")
+	ErrCorrupt      = errors.New("storage: corrupt data")
+)
+
+// Storage is a set of namespaced, append-only record logs.
+//
+// Implementations must be safe for concurrent use. The crash-consistency
+// guarantees stated below are the minimum every implementation must give;
+// the in-memory implementation trivially loses everything on a crash.
+type Storage interface {
+	// Append adds rec to the end of namespace ns, creating the namespace
+	// if it does not exist yet, and returns the index of the new record.
+	// The implementation must not retain rec.
+	//
+	// Crash consistency: after Append returns, the record is visible to
+	// readers but not necessarily durable. A crash may lose any suffix of
+	// records appended since the last Sync, but never a record that was
+	// synced, and never leaves a partially written record visible after
+	// restart.
+	Append(ns string, rec []byte) (uint64, error)
+
+	// ReadRange calls fn for each record in ns with an index in the
+	// half-open range [from, to), in index order. A to of zero means "up
+	// to the last record". Records discarded by TruncateBefore are
+	// silently skipped. If fn returns an error, ReadRange stops and
+	// returns that error. fn must not retain rec after it returns.
+	//
+	// Crash consistency: ReadRange does not modify storage.
+	ReadRange(ns string, from, to uint64, fn func(idx uint64, rec []byte) error) error
+
+	// Bounds returns the index of the oldest retained record of ns and the
+	// index the next appended record will get. first == next means the
+	// namespace is empty. An unknown namespace returns 1, 1.
+	Bounds(ns string) (first, next uint64, err error)
+
+	// Sync makes all records appended to ns so far durable.
+	//
+	// Crash consistency: once Sync returns nil, all records appended to ns
+	// before the call survive a crash.
+	Sync(ns string) error
+
+	// TruncateBefore discards all records of ns with an index lower than
+	// idx. Indexes of the remaining records do not change.
+	//
+	// Crash consistency: truncation is atomic. After a crash, the
+	// namespace is either fully truncated or not truncated at all.
+	// Unsynced records appended before the call are synced as a side
+	// effect.
+	TruncateBefore(ns string, idx uint64) error
+
+	// Namespaces lists the names of all namespaces, in no particular order.
+	Namespaces() ([]string, error)
+
+	// Close releases all resources. Unsynced records may be lost unless
+	// the implementation says otherwise; the file implementation syncs
+	// every namespace before closing.
+	Close() error
+}
+
+// Namespace names end up as file names or keys in other databases, so
+// they are restricted to a conservative character set.
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+// checkNamespace returns ErrBadNamespace unless ns is a valid name.
+func checkNamespace(ns string) error {
+	if !validNamespace.MatchString(ns) || ns == "." || ns == ".." {
+		return ErrBadNamespace
+	}
+	return nil
+}
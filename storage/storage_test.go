@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// backends returns a fresh storage of each implementation.
+func backends(t *testing.T) map[string]Storage {
+	t.Helper()
+	f, err := NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Storage{"file": f, "memory": NewMemory()}
+}
+
+// readAll returns the indexes and records of ns in [from, to).
+func readAll(t *testing.T, s Storage, ns string, from, to uint64) ([]uint64, []string) {
+	t.Helper()
+	var idxs []uint64
+	var recs []string
+	err := s.ReadRange(ns, from, to, func(idx uint64, rec []byte) error {
+		idxs = append(idxs, idx)
+		recs = append(recs, string(rec))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadRange(%s, %d, %d): %v", ns, from, to, err)
+	}
+	return idxs, recs
+}
+
+func TestStorage(t *testing.T) {
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if first, next, err := s.Bounds("empty"); err != nil || first != 1 || next != 1 {
+				t.Errorf("Bounds of an unknown namespace = %d, %d, %v; want 1, 1", first, next, err)
+			}
+			for i, rec := range []string{"a", "", "ccc", "d"} {
+				idx, err := s.Append("ns", []byte(rec))
+				if err != nil || idx != uint64(i+1) {
+					t.Fatalf("Append(%q) = %d, %v; want %d", rec, idx, err, i+1)
+				}
+			}
+			if _, err := s.Append("other", []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+
+			idxs, recs := readAll(t, s, "ns", 0, 0)
+			if !reflect.DeepEqual(idxs, []uint64{1, 2, 3, 4}) || !reflect.DeepEqual(recs, []string{"a", "", "ccc", "d"}) {
+				t.Errorf("ReadRange = %v %q", idxs, recs)
+			}
+			if idxs, _ := readAll(t, s, "ns", 2, 4); !reflect.DeepEqual(idxs, []uint64{2, 3}) {
+				t.Errorf("ReadRange(2, 4) = %v", idxs)
+			}
+			stop := errors.New("stop")
+			err := s.ReadRange("ns", 1, 0, func(idx uint64, _ []byte) error {
+				if idx == 2 {
+					return stop
+				}
+				return nil
+			})
+			if err != stop {
+				t.Errorf("ReadRange returned %v, want the error of fn", err)
+			}
+
+			if err := s.TruncateBefore("ns", 3); err != nil {
+				t.Fatal(err)
+			}
+			if first, next, _ := s.Bounds("ns"); first != 3 || next != 5 {
+				t.Errorf("Bounds after TruncateBefore(3) = %d, %d; want 3, 5", first, next)
+			}
+			if idxs, recs := readAll(t, s, "ns", 1, 0); !reflect.DeepEqual(idxs, []uint64{3, 4}) || !reflect.DeepEqual(recs, []string{"ccc", "d"}) {
+				t.Errorf("ReadRange after TruncateBefore = %v %q", idxs, recs)
+			}
+			if idx, err := s.Append("ns", []byte("e")); err != nil || idx != 5 {
+				t.Errorf("Append after TruncateBefore = %d, %v; want 5", idx, err)
+			}
+			if err := s.TruncateBefore("ns", 100); err != nil {
+				t.Fatal(err)
+			}
+			if first, next, _ := s.Bounds("ns"); first != 6 || next != 6 {
+				t.Errorf("Bounds after truncating everything = %d, %d; want 6, 6", first, next)
+			}
+			if err := s.Sync("ns"); err != nil {
+				t.Error(err)
+			}
+
+			names, err := s.Namespaces()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(names)
+			for _, want := range []string{"ns", "other"} {
+				if i := sort.SearchStrings(names, want); i == len(names) || names[i] != want {
+					t.Errorf("Namespaces() = %v, missing %s", names, want)
+				}
+			}
+
+			for _, ns := range []string{"", "a/b", "..", "x y", string(bytes.Repeat([]byte("n"), 129))} {
+				if _, err := s.Append(ns, nil); err != ErrBadNamespace {
+					t.Errorf("Append to %q: %v, want ErrBadNamespace", ns, err)
+				}
+			}
+
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Append("ns", nil); err != ErrClosed {
+				t.Errorf("Append after Close: %v, want ErrClosed", err)
+			}
+			if err := s.Close(); err != ErrClosed {
+				t.Errorf("second Close: %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+// The file storage behaves like the memory storage under random
+// operations, including reopening, which the memory storage does not
+// notice.
+func TestFileMatchesMemory(t *testing.T) {
+	dir := t.TempDir()
+	file, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { file.Close() }()
+	model := NewMemory()
+	rnd := rand.New(rand.NewSource(1))
+	namespaces := []string{"a", "b", "c"}
+	for op := 0; op < 2000; op++ {
+		ns := namespaces[rnd.Intn(len(namespaces))]
+		switch r := rnd.Intn(100); {
+		case r < 60:
+			rec := bytes.Repeat([]byte{byte(op)}, rnd.Intn(300))
+			fi, ferr := file.Append(ns, rec)
+			mi, merr := model.Append(ns, rec)
+			if fi != mi || ferr != merr {
+				t.Fatalf("op %d: Append = %d, %v; model %d, %v", op, fi, ferr, mi, merr)
+			}
+		case r < 70:
+			_, next, _ := model.Bounds(ns)
+			idx := uint64(rnd.Int63n(int64(next) + 2))
+			if ferr, merr := file.TruncateBefore(ns, idx), model.TruncateBefore(ns, idx); ferr != merr {
+				t.Fatalf("op %d: TruncateBefore = %v; model %v", op, ferr, merr)
+			}
+		case r < 75:
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if file, err = NewFile(dir); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			_, next, _ := model.Bounds(ns)
+			from, to := uint64(rnd.Int63n(int64(next)+1)), uint64(rnd.Int63n(int64(next)+2))
+			fi, fr := readAll(t, file, ns, from, to)
+			mi, mr := readAll(t, model, ns, from, to)
+			if !reflect.DeepEqual(fi, mi) || !reflect.DeepEqual(fr, mr) {
+				t.Fatalf("op %d: ReadRange(%s, %d, %d) = %v; model %v", op, ns, from, to, fi, mi)
+			}
+		}
+		ff, fn, ferr := file.Bounds(ns)
+		mf, mn, merr := model.Bounds(ns)
+		if ff != mf || fn != mn || ferr != merr {
+			t.Fatalf("op %d: Bounds(%s) = %d, %d, %v; model %d, %d, %v", op, ns, ff, fn, ferr, mf, mn, merr)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stressSeed makes the subscription changes of TestSubscribeStress
+// repeatable. With this one, a pattern goes and comes back early on
+// while no other subscription matches its topic.
+const stressSeed = 11
+
+// While a publisher sends on many topics, a subscriber changes its topics
+// and patterns at random, in bursts. After each burst, once the messages
+// underway have passed, it receives exactly the topics that it is
+// subscribed to.
+func TestSubscribeStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	var published, subs []string
+	for i := 0; i < 8; i++ {
+		topic := fmt.Sprintf("t%d", i)
+		published = append(published, topic, topic+".x")
+		// Topics and patterns share socket subscriptions: "t1" and
+		// "t1.*" both need the prefix "t1.".
+		subs = append(subs, topic, topic+".x", topic+".*")
+	}
+
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "ping")
+	s.SetRecvDeadline(100 * time.Millisecond)
+	waitFlow(t, p, s)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+			}
+			for _, topic := range published {
+				p.Publish(topic, "")
+			}
+		}
+	}()
+	var mu sync.Mutex
+	last := make(map[string]time.Time)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			topic, _, err := s.Receive()
+			if err != nil {
+				continue
+			}
+			mu.Lock()
+			last[topic] = time.Now()
+			mu.Unlock()
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	rng := rand.New(rand.NewSource(stressSeed))
+	active := make(map[string]bool)
+	for round := 0; round < 20; round++ {
+		for i := rng.Intn(8); i >= 0; i-- {
+			sub := subs[rng.Intn(len(subs))]
+			if err := changeSubscription(s, sub, !active[sub]); err != nil {
+				t.Fatalf("round %d: %v", round, err)
+			}
+			active[sub] = !active[sub]
+		}
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		time.Sleep(150 * time.Millisecond)
+
+		var want, got []string
+		for _, topic := range published {
+			if wantedBy(topic, active) {
+				want = append(want, topic)
+			}
+		}
+		mu.Lock()
+		for topic, at := range last {
+			if topic != "ping" && at.After(start) {
+				got = append(got, topic)
+			}
+		}
+		mu.Unlock()
+		sort.Strings(want)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round %d (seed %d): received %v, want %v", round, stressSeed, got, want)
+		}
+		// Nothing is lost at this pace, so a gap spans the time in which
+		// no subscription matched a topic.
+		if n := s.Missed(); n != 0 {
+			t.Fatalf("round %d (seed %d): Missed() = %d, want 0", round, stressSeed, n)
+		}
+	}
+}
+
+// changeSubscription subscribes to or unsubscribes from sub, a pattern if
+// it contains "*".
+func changeSubscription(s *Subscriber, sub string, subscribe bool) error {
+	pattern := sub[len(sub)-1] == '*'
+	switch {
+	case subscribe && pattern:
+		return s.SubscribePattern(sub)
+	case subscribe:
+		return s.Subscribe(sub)
+	case pattern:
+		return s.UnsubscribePattern(sub)
+	default:
+		return s.Unsubscribe(sub)
+	}
+}
+
+// wantedBy reports whether one of the active subscriptions matches topic.
+func wantedBy(topic string, active map[string]bool) bool {
+	for sub, on := range active {
+		if !on {
+			continue
+		}
+		if sub[len(sub)-1] == '*' {
+			_, re := compilePattern(sub)
+			if re.MatchString(topic) {
+				return true
+			}
+		} else if countsFor(topic, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// By default, a subscriber takes a message whose sequence number is not
+// above the last one of its topic and publisher for the first message
+// after a restart of the publisher (see seq.go), or for a late one (see
+// reorder.go), and passes it on. Consumers that must never see the
+// messages of a topic out of order can switch to strict ordering instead:
+// then such a message is an order violation, and the subscriber skips it.
+//
+// Retransmissions are not violations. A repeat of the last-value cache
+// with a number that the subscriber has reached is skipped as before, and
+// so is a message that arrives after AutoReplay has replayed it. Replayed
+// messages themselves come in order.
+//
+// A restarted publisher starts its numbers over, and a subscriber in
+// strict mode cannot tell that from a violation. But a restart breaks the
+// connection, so the subscriber forgets the numbers of a publisher
+// whenever it connects to it.
+
+// ErrOrderViolated is returned by the receiving methods of a subscriber
+// that halted because of an order violation, until ResetOrder is called.
+var ErrOrderViolated = errors.New("message order violated")
+
+// OrderAction decides what a subscriber in strict-ordering mode does
+// about an order violation.
+type OrderAction int
+
+const (
+	// DropViolations skips the message and counts it (see
+	// Subscriber.Violations).
+	DropViolations OrderAction = iota
+
+	// ReportViolations skips and counts the message, and passes it to
+	// the function set with OnOrderViolation.
+	ReportViolations
+
+	// HaltOnViolation skips and counts the message and halts the
+	// subscriber: receiving returns ErrOrderViolated until ResetOrder is
+	// called. Messages closes its channel, and Run returns.
+	HaltOnViolation
+)
+
+// SetStrictOrder switches strict ordering on or off and sets the action
+// to take about a violation. It is off by default.
+func (s *Subscriber) SetStrictOrder(strict bool, action OrderAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strictOrder = strict
+	s.orderAction = action
+}
+
+// OnOrderViolation sets a function that the ReportViolations action calls
+// with a message that violates the order and the number of the last
+// message of its topic and publisher. Like the function set with OnGap, it
+// is called from a receiving goroutine and must return quickly.
+func (s *Subscriber) OnOrderViolation(fn func(msg Message, last uint64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onViolation = fn
+}
+
+// Violations returns the number of messages that the subscriber skipped
+// as order violations.
+func (s *Subscriber) Violations() uint64 {
+	return atomic.LoadUint64(&s.violations)
+}
+
+// ResetOrder ends the halt after an order violation. The subscriber
+// forgets all sequence numbers and starts over with the next message of
+// each topic.
+func (s *Subscriber) ResetOrder() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.halted = false
+	s.seqs = make(map[seqKey]*seqState)
+}
+
+// violated skips msg, whose number is not above last, and takes the
+// action about it.
+func (s *Subscriber) violated(msg Message, last uint64) {
+	atomic.AddUint64(&s.violations, 1)
+	logger().Warn("Order violated", "topic", msg.Topic, "seq", msg.Seq, "last", last)
+	s.mu.Lock()
+	action, fn := s.orderAction, s.onViolation
+	if action == HaltOnViolation {
+		s.halted = true
+	}
+	s.mu.Unlock()
+	if action == ReportViolations && fn != nil {
+		fn(msg, last)
+	}
+}
+
+// checkHalted returns ErrOrderViolated while the subscriber is halted.
+func (s *Subscriber) checkHalted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.halted {
+		return ErrOrderViolated
+	}
+	return nil
+}
+
+// forgetPublisher forgets the sequence numbers of the publisher at url in
+// strict mode, see above. s.mu must be held.
+func (s *Subscriber) forgetPublisher(url string) {
+	if !s.strictOrder {
+		return
+	}
+	for key := range s.seqs {
+		if key.url == url {
+			delete(s.seqs, key)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// newStrictSubscriber returns a subscriber in strict mode of a relay that
+// delivers a2 after a3.
+func newStrictSubscriber(t *testing.T, opts Options, action OrderAction) (*Publisher, *Subscriber) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{ReplayURL: opts.ReplayURL})
+	startRelay(t, up, down, delaySeqs("a", map[uint64]uint64{2: 3}))
+	s := newTestSubscriber(t, down, opts, "a", "ping")
+	s.SetStrictOrder(true, action)
+	waitFlow(t, p, s)
+	return p, s
+}
+
+func TestStrictOrderDrop(t *testing.T) {
+	p, s := newStrictSubscriber(t, Options{}, DropViolations)
+	publishAll(t, p, "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 3))
+	if want := []string{"a1", "a3", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if s.Violations() != 1 || s.Late() != 0 {
+		t.Errorf("Violations() = %d, Late() = %d; want 1, 0", s.Violations(), s.Late())
+	}
+}
+
+func TestStrictOrderReport(t *testing.T) {
+	p, s := newStrictSubscriber(t, Options{}, ReportViolations)
+	var reported [][2]uint64
+	s.OnOrderViolation(func(msg Message, last uint64) {
+		reported = append(reported, [2]uint64{msg.Seq, last})
+	})
+	publishAll(t, p, "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 3))
+	if want := []string{"a1", "a3", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if want := [][2]uint64{{2, 3}}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("reported seq and last %v, want %v", reported, want)
+	}
+	if s.Violations() != 1 {
+		t.Errorf("Violations() = %d, want 1", s.Violations())
+	}
+}
+
+func TestStrictOrderHalt(t *testing.T) {
+	p, s := newStrictSubscriber(t, Options{}, HaltOnViolation)
+	publishAll(t, p, "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 2))
+	if want := []string{"a1", "a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.ReceiveMessage(); !errors.Is(err, ErrOrderViolated) {
+			t.Fatalf("receive after the violation: %v, want ErrOrderViolated", err)
+		}
+	}
+
+	// After the reset, the subscriber starts over.
+	s.ResetOrder()
+	publishAll(t, p, "a")
+	got = seqsOf(receiveTopics(t, s, 2))
+	if want := []string{"a4", "a5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v after ResetOrder, want %v", got, want)
+	}
+	if s.Violations() != 1 {
+		t.Errorf("Violations() = %d, want 1", s.Violations())
+	}
+}
+
+// Repeats of the last-value cache are skipped as before, but an unmarked
+// message with the same number is a violation.
+func TestStrictOrderRepeats(t *testing.T) {
+	up, down := testURL(t)+"-up", testURL(t)+"-down"
+	p := newTestPublisher(t, up, Options{})
+	startRelay(t, up, down, func(f wire.Frame) []wire.Frame {
+		if f.Topic != "a" {
+			return []wire.Frame{f}
+		}
+		repeat := f
+		repeat.Header.Repeat = true
+		again := f
+		again.Payload = []byte("again")
+		return []wire.Frame{f, repeat, again}
+	})
+	s := newTestSubscriber(t, down, Options{}, "a", "ping")
+	s.SetStrictOrder(true, DropViolations)
+	waitFlow(t, p, s)
+	filtered := s.Filtered()
+
+	publishAll(t, p, "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 3))
+	if want := []string{"a1", "a2", "a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if n := s.Filtered() - filtered; n < 2 {
+		t.Errorf("%d messages filtered, want at least 2 repeats", n)
+	}
+	if s.Violations() < 2 {
+		t.Errorf("Violations() = %d, want at least 2", s.Violations())
+	}
+}
+
+// A message that arrives after AutoReplay fetched it again is a duplicate,
+// not a violation.
+func TestStrictOrderReplayed(t *testing.T) {
+	opts := Options{ReplayURL: testURL(t) + "-replay", AutoReplay: true}
+	p, s := newStrictSubscriber(t, opts, HaltOnViolation)
+	publishAll(t, p, "a", "a", "a", "a")
+	got := seqsOf(receiveTopics(t, s, 4))
+	if want := []string{"a1", "a2", "a3", "a4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if s.Violations() != 0 || s.Missed() != 1 {
+		t.Errorf("Violations() = %d, Missed() = %d; want 0, 1", s.Violations(), s.Missed())
+	}
+}
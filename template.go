@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Topics often embed runtime identifiers, like "sensors/{region}/{device}/temp".
+// A TopicTemplate is parsed once and then expands such placeholders
+// consistently, or extracts them again from a concrete topic.
+
+// topicSeparators may not appear in placeholder values: "/" and "." separate
+// topic segments, and "|" or NUL separates the topic from the message.
+const topicSeparators = "/.|\x00"
+
+// ErrTemplate is returned (wrapped) for all template parsing and expansion errors.
+var ErrTemplate = errors.New("topic template")
+
+var placeholderName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TopicTemplate is a topic with named placeholders in curly braces.
+type TopicTemplate struct {
+	text  string
+	parts []templatePart
+	vars  []string
+	match *regexp.Regexp
+}
+
+// A templatePart is either a literal or a placeholder.
+type templatePart struct {
+	literal string
+	name    string
+}
+
+// ParseTemplate parses a template like "sensors/{region}/{device}/temp".
+// Placeholder names must be valid identifiers and may appear only once.
+// Two placeholders must not be adjacent, as Match could not tell where
+// one ends and the next begins.
+func ParseTemplate(text string) (*TopicTemplate, error) {
+	t := &TopicTemplate{text: text}
+	var re strings.Builder
+	re.WriteString("^")
+	seen := map[string]bool{}
+
+	rest := text
+	for len(rest) > 0 {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			re.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("%w %q: unexpected '}' at offset %d", ErrTemplate, text, len(text)-len(rest)+open)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+			re.WriteString(regexp.QuoteMeta(rest[:open]))
+		} else if n := len(t.parts); n > 0 && t.parts[n-1].name != "" {
+			return nil, fmt.Errorf("%w %q: placeholders {%s} and the next one are adjacent", ErrTemplate, text, t.parts[n-1].name)
+		}
+
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return nil, fmt.Errorf("%w %q: unterminated placeholder at offset %d", ErrTemplate, text, len(text)-len(rest)+open)
+		}
+		name := rest[open+1 : open+1+end]
+		if !placeholderName.MatchString(name) {
+			return nil, fmt.Errorf("%w %q: invalid placeholder name %q", ErrTemplate, text, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w %q: duplicate placeholder {%s}", ErrTemplate, text, name)
+		}
+		seen[name] = true
+		t.parts = append(t.parts, templatePart{name: name})
+		t.vars = append(t.vars, name)
+		re.WriteString("([^" + regexp.QuoteMeta(topicSeparators) + "]+)")
+		rest = rest[open+1+end+1:]
+	}
+
+	re.WriteString("$")
+	t.match = regexp.MustCompile(re.String())
+	return t, nil
+}
+
+// MustTemplate is like ParseTemplate but panics if the template is invalid.
+// It is meant for package-level variables.
+func MustTemplate(text string) *TopicTemplate {
+	t, err := ParseTemplate(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// literal returns the literal part of the template before the first
+// placeholder.
+func (t *TopicTemplate) literal() string {
+	if len(t.parts) == 0 || t.parts[0].name != "" {
+		return ""
+	}
+	return t.parts[0].literal
+}
+
+// String returns the template text.
+func (t *TopicTemplate) String() string {
+	return t.text
+}
+
+// Vars returns the placeholder names in the order they appear.
+func (t *TopicTemplate) Vars() []string {
+	return append([]string(nil), t.vars...)
+}
+
+// Expand replaces each placeholder with its value from vars.
+// Every placeholder must be supplied, and values must be non-empty and
+// must not contain any of the separator characters "/", ".", "|", or NUL.
+// Extra entries in vars are ignored.
+func (t *TopicTemplate) Expand(vars map[string]string) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.name == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		v, ok := vars[p.name]
+		if !ok {
+			return "", fmt.Errorf("%w %q: no value for {%s}", ErrTemplate, t.text, p.name)
+		}
+		if v == "" {
+			return "", fmt.Errorf("%w %q: empty value for {%s}", ErrTemplate, t.text, p.name)
+		}
+		if strings.ContainsAny(v, topicSeparators) {
+			return "", fmt.Errorf("%w %q: value %q for {%s} contains one of %q", ErrTemplate, t.text, v, p.name, topicSeparators)
+		}
+		b.WriteString(v)
+	}
+	return b.String(), nil
+}
+
+// Match is the reverse of Expand. If topic matches the template as a
+// whole, Match returns the placeholder values and true. A topic that only
+// matches in part (for example, a prefix of it) does not match.
+// Subscriber.HandleTemplate uses Match to fill in Message.Vars.
+func (t *TopicTemplate) Match(topic string) (map[string]string, bool) {
+	m := t.match.FindStringSubmatch(topic)
+	if m == nil {
+		return nil, false
+	}
+	vars := make(map[string]string, len(t.vars))
+	for i, name := range t.vars {
+		vars[name] = m[i+1]
+	}
+	return vars, true
+}
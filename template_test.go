@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, text := range []string{
+		"a/{b",
+		"a/b}",
+		"a/{}",
+		"a/{1x}",
+		"a/{x-y}",
+		"{x}/{x}",
+		"{x}{y}",
+		"a/{x}}",
+		"a/{{x}}",
+	} {
+		if _, err := ParseTemplate(text); !errors.Is(err, ErrTemplate) {
+			t.Errorf("ParseTemplate(%q): %v, want ErrTemplate", text, err)
+		}
+	}
+}
+
+func TestTemplateExpandAndMatch(t *testing.T) {
+	tmpl := MustTemplate("sensors/{region}/{device}/temp")
+	if got := tmpl.Vars(); !reflect.DeepEqual(got, []string{"region", "device"}) {
+		t.Errorf("Vars() = %v", got)
+	}
+	vars := map[string]string{"region": "eu", "device": "d1", "extra": "ignored"}
+	topic, err := tmpl.Expand(vars)
+	if err != nil || topic != "sensors/eu/d1/temp" {
+		t.Fatalf("Expand = %q, %v", topic, err)
+	}
+	if got, ok := tmpl.Match(topic); !ok || !reflect.DeepEqual(got, map[string]string{"region": "eu", "device": "d1"}) {
+		t.Errorf("Match(%q) = %v, %v", topic, got, ok)
+	}
+
+	for _, v := range []string{"", "a/b", "a.b", "a|b", "a\x00b"} {
+		if _, err := tmpl.Expand(map[string]string{"region": v, "device": "d1"}); !errors.Is(err, ErrTemplate) {
+			t.Errorf("Expand with region %q: %v, want ErrTemplate", v, err)
+		}
+	}
+	if _, err := tmpl.Expand(map[string]string{"region": "eu"}); !errors.Is(err, ErrTemplate) {
+		t.Errorf("Expand without device: %v, want ErrTemplate", err)
+	}
+
+	for _, topic := range []string{
+		"sensors/eu/d1/temp/x", // longer
+		"sensors/eu/d1",        // shorter
+		"xsensors/eu/d1/temp",
+		"sensors/eu/d1/tempx",
+		"sensors//d1/temp",    // empty value
+		"sensors/e.u/d1/temp", // separator in a value
+		"sensors/e\x00u/d1/temp",
+	} {
+		if vars, ok := tmpl.Match(topic); ok {
+			t.Errorf("Match(%q) = %v, want no match", topic, vars)
+		}
+	}
+}
+
+// Run hands messages of a template's topics to its handler, with the
+// placeholder values, unless a topic handler takes them.
+func TestHandleTemplate(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{})
+	got := make(chan Message, 10)
+	handle := func(msg Message) error {
+		got <- msg
+		return nil
+	}
+	if err := s.HandleTemplate(MustTemplate("sensors/{region}/{device}/temp"), handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleTemplate(MustTemplate("sensors/{region}/{device}/{reading}"), handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Handle("sensors/eu/special", handle); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	for _, topic := range []string{"sensors/eu/d1/temp", "sensors/us", "sensors/eu/special/temp", "sensors/us/d2/hum"} {
+		if err := p.Publish(topic, "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []struct {
+		topic string
+		vars  map[string]string
+	}{
+		{"sensors/eu/d1/temp", map[string]string{"region": "eu", "device": "d1"}},
+		{"sensors/eu/special/temp", nil},
+		{"sensors/us/d2/hum", map[string]string{"region": "us", "device": "d2", "reading": "hum"}},
+	}
+	for _, w := range want {
+		select {
+		case msg := <-got:
+			if msg.Topic != w.topic || !reflect.DeepEqual(msg.Vars, w.vars) {
+				t.Errorf("handled %s with %v, want %s with %v", msg.Topic, msg.Vars, w.topic, w.vars)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s not handled", w.topic)
+		}
+	}
+	if s.Filtered() != 1 {
+		t.Errorf("Filtered() = %d, want 1 for sensors/us", s.Filtered())
+	}
+}
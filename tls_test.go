@@ -0,0 +1,126 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+// newTestCA creates a self-signed CA.
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for name, signed by ca, for a server at
+// 127.0.0.1 or for a client.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// With mutual TLS, a subscriber with a certificate of the client CA gets
+// the messages, and subscribers without one do not get connected.
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	p := newTestPublisher(t, "tls+tcp://127.0.0.1:0", Options{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "publisher", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}})
+
+	s := newTestSubscriber(t, p.Addr(), Options{TLSConfig: &tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{ca.issue(t, "subscriber", x509.ExtKeyUsageClientAuth)},
+	}}, "weather", "ping")
+	waitFlow(t, p, s)
+	if err := p.Publish("weather", "sunny"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receiveTopics(t, s, 1)[0]; string(msg.Payload) != "sunny" {
+		t.Errorf("received %q, want sunny", msg.Payload)
+	}
+
+	other := newTestCA(t, "other CA")
+	for name, config := range map[string]*tls.Config{
+		"no certificate":      {RootCAs: ca.pool},
+		"unknown certificate": {RootCAs: ca.pool, Certificates: []tls.Certificate{other.issue(t, "intruder", x509.ExtKeyUsageClientAuth)}},
+		"unknown publisher":   {RootCAs: other.pool, Certificates: []tls.Certificate{ca.issue(t, "subscriber", x509.ExtKeyUsageClientAuth)}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rejected, err := NewSubscriberWithOptions(p.Addr(), Options{TLSConfig: config}, "weather")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rejected.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			if err := rejected.WaitConnected(ctx); err == nil {
+				t.Error("subscriber connected")
+			}
+			if err := p.Publish("weather", "secret"); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if _, payload, err := rejected.ReceiveContext(ctx); err == nil {
+				t.Errorf("subscriber received %q", payload)
+			}
+		})
+	}
+}
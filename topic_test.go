@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/appliedgo/pubsub/internal/wire"
+)
+
+// Subscribers match topics in any normalization form, and the ASCII-safe
+// encoding is a setting of each publisher and subscriber.
+func TestTopicRoundTrip(t *testing.T) {
+	const (
+		composed   = "caf\u00e9.cr\u00e8me"
+		decomposed = "cafe\u0301.cre\u0300me"
+	)
+	for _, ascii := range []bool{false, true} {
+		url := testURL(t) + map[bool]string{false: "-nfc", true: "-ascii"}[ascii]
+		opts := Options{ASCIITopics: ascii}
+		p := newTestPublisher(t, url, opts)
+		s := newTestSubscriber(t, url, opts, decomposed)
+		if err := p.Publish(composed, "hot"); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Publish(decomposed+".lait", "warm"); err != nil {
+			t.Fatal(err)
+		}
+		msgs := receiveN(t, s, 2)
+		if msgs[0].Topic != composed || string(msgs[0].Payload) != "hot" {
+			t.Errorf("ascii=%v: got %q %q, want %q hot", ascii, msgs[0].Topic, msgs[0].Payload, composed)
+		}
+		if msgs[1].Topic != composed+".lait" {
+			t.Errorf("ascii=%v: got %q, want %q", ascii, msgs[1].Topic, composed+".lait")
+		}
+	}
+}
+
+func TestASCIITopicsPerInstance(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{ASCIITopics: true})
+	plain := newTestSubscriber(t, url, Options{})
+	plain.SubscribeAll()
+	decoding := newTestSubscriber(t, url, Options{ASCIITopics: true}, "café")
+	if err := p.Publish("café", "noir"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receiveN(t, plain, 1)[0].Topic; got != "caf%C3%A9" {
+		t.Errorf("subscriber without ASCIITopics got %q, want the encoded topic", got)
+	}
+	if got := receiveN(t, decoding, 1)[0].Topic; got != "café" {
+		t.Errorf("subscriber with ASCIITopics got %q, want %q", got, "café")
+	}
+}
+
+// In binary frames, "|" is an ordinary topic character: a subscriber of
+// "a" does not get "a|b", and payloads may contain anything.
+func TestPipeInTopic(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	a := newTestSubscriber(t, url, Options{}, "a")
+	ab := newTestSubscriber(t, url, Options{}, "a|b")
+	payload := "x|y\x00\x01\x05\nz"
+	if err := p.Publish("a|b", payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish("a", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receiveN(t, ab, 1)[0]; msg.Topic != "a|b" || string(msg.Payload) != payload {
+		t.Errorf("subscriber of a|b got %q %q", msg.Topic, msg.Payload)
+	}
+	if msg := receiveN(t, a, 1)[0]; msg.Topic != "a" {
+		t.Errorf("subscriber of a got %q %q, want only a", msg.Topic, msg.Payload)
+	}
+}
+
+func TestTextFramingRejectsPipe(t *testing.T) {
+	p := newTestPublisher(t, testURL(t), Options{})
+	p.SetFraming(TextFraming)
+	if err := p.Publish("a|b", "x"); err == nil {
+		t.Error("publishing a|b with text framing succeeded")
+	}
+}
+
+// randomTopic returns a short topic of letters, separators, and, unless
+// text is true, pipes.
+func randomTopic(rnd *rand.Rand, text bool) string {
+	chars := "ab./|"
+	if text {
+		chars = "ab./"
+	}
+	b := make([]byte, rnd.Intn(5))
+	for i := range b {
+		b[i] = chars[rnd.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// The index in wanted finds the same topics as checking each subscribed
+// topic with matchesTopic, while topics come and go.
+func TestWantedMatchesLinear(t *testing.T) {
+	s, err := NewSubscriber(testURL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rnd := rand.New(rand.NewSource(1))
+	subscribed := make(map[string]bool)
+	for op := 0; op < 5000; op++ {
+		if rnd.Intn(4) == 0 {
+			topic := randomTopic(rnd, false)
+			if subscribed[topic] {
+				delete(subscribed, topic)
+				s.Unsubscribe(topic)
+			} else {
+				subscribed[topic] = true
+				s.Subscribe(topic)
+			}
+			continue
+		}
+
+		f := wire.Frame{Format: wire.Binary, Payload: []byte("x|y.z")}
+		if rnd.Intn(2) == 0 {
+			f.Format = wire.Text
+		}
+		f.Topic = randomTopic(rnd, f.Format == wire.Text)
+		raw, err := wire.Encode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rnd.Intn(10) == 0 {
+			// A text message without a topic. (With a "|" in it,
+			// matchesTopic would take the part after the "|" for
+			// part of the topic.)
+			raw = []byte(randomTopic(rnd, true))
+		}
+		linear := false
+		for topic := range subscribed {
+			if matchesTopic(raw, topic) {
+				linear = true
+			}
+		}
+		if got := s.wanted(raw, Message{}); got != linear {
+			t.Fatalf("op %d: wanted(%q) = %v with topics %v, want %v", op, raw, got, subscribed, linear)
+		}
+	}
+}
+
+// benchmarkWanted looks up a frame among n subscribed topics, one of which
+// it belongs to.
+func benchmarkWanted(b *testing.B, n int, linear bool) {
+	s, err := NewSubscriber("inproc://" + strings.ReplaceAll(b.Name(), "/", "-"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	var topics []string
+	for i := 0; i < n; i++ {
+		topic := "sensors.room" + strconv.Itoa(i)
+		topics = append(topics, topic)
+		if err := s.Subscribe(topic); err != nil {
+			b.Fatal(err)
+		}
+	}
+	raw, err := wire.Encode(wire.Frame{Topic: topics[n/2] + ".temp", Payload: []byte("21.5")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if linear {
+			found := false
+			for _, topic := range topics {
+				if matchesTopic(raw, topic) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				b.Fatal("not found")
+			}
+		} else if !s.wanted(raw, Message{}) {
+			b.Fatal("not wanted")
+		}
+	}
+}
+
+func BenchmarkWanted(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("index/%d", n), func(b *testing.B) { benchmarkWanted(b, n, false) })
+		b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) { benchmarkWanted(b, n, true) })
+	}
+}
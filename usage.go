@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"sort"
+	"time"
+)
+
+// Subscriptions pile up: topics that nobody publishes anymore still cost
+// a prefix comparison for every message, and confuse whoever reads the
+// configuration. To find them, a subscriber counts for each subscription
+// the messages that it matched, and notes when it matched the last one.
+//
+// A message counts for every subscription that matches it: for its topic
+// and the topics above it, for a pattern (see SubscribePattern), and for a
+// template (see HandleTemplate). A message that only a pattern matches
+// counts for the pattern, not for its concrete topic. Messages count when
+// they are received, like for Stats, which includes the counts, too.
+//
+// A topic and a pattern with the same text, which both match that topic,
+// share their counts.
+
+// subUsage counts the matches of a subscription.
+type subUsage struct {
+	name      string
+	refs      int // number of subscriptions with the name
+	matched   uint64
+	lastMatch time.Time
+}
+
+// use returns the usage of the subscription name, for a new subscription.
+// s.mu must be held.
+func (s *Subscriber) use(name string) *subUsage {
+	u := s.usage[name]
+	if u == nil {
+		u = &subUsage{name: name}
+		s.usage[name] = u
+	}
+	u.refs++
+	return u
+}
+
+// unuse forgets the usage of the subscription name when it is gone. s.mu
+// must be held.
+func (s *Subscriber) unuse(name string) {
+	if u := s.usage[name]; u != nil {
+		if u.refs--; u.refs == 0 {
+			delete(s.usage, name)
+		}
+	}
+}
+
+// countMatches counts msg for the subscriptions that match it. s.mu must
+// be held.
+func (s *Subscriber) countMatches(msg Message, now time.Time) {
+	matches := s.topicIndex.Match(s.wireTopic(msg.Topic) + "\x00")
+	matches = append(matches, s.index.Match(msg.Topic)...)
+	counted := make(map[*subUsage]bool, len(matches))
+	for _, m := range matches {
+		u := m.(*subUsage)
+		if counted[u] {
+			continue
+		}
+		counted[u] = true
+		u.matched++
+		u.lastMatch = now
+	}
+}
+
+// UnusedSince returns the subscriptions, sorted, that matched no message
+// within the last d, including those that never matched any.
+func (s *Subscriber) UnusedSince(d time.Duration) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := time.Now().Add(-d)
+	var unused []string
+	for name, u := range s.usage {
+		if u.lastMatch.Before(since) {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Matches count for the subscription that matched, a pattern rather than
+// the concrete topic, and subscriptions that matched nothing are unused.
+func TestUsagePatternAttribution(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "finance.eu", "weather", "ping")
+	for _, pattern := range []string{"sports.*.scores", "news.*"} {
+		if err := s.SubscribePattern(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFlow(t, p, s)
+
+	start := time.Now()
+	publishAll(t, p, "finance.eu.bonds", "sports.tennis.scores", "sports.golf.scores")
+	receiveTopics(t, s, 3)
+	stats := s.Stats()
+	for name, want := range map[string]uint64{
+		"finance.eu":           1,
+		"sports.*.scores":      2,
+		"sports.tennis.scores": 0,
+		"weather":              0,
+		"news.*":               0,
+	} {
+		if got := stats[name].Matched; got != want {
+			t.Errorf("Stats()[%q].Matched = %d, want %d", name, got, want)
+		}
+	}
+	if st := stats["sports.*.scores"]; st.LastMatch.Before(start) {
+		t.Errorf("LastMatch of the pattern is %v, before the messages", st.LastMatch)
+	}
+	if st, ok := stats["news.*"]; !ok || !st.LastMatch.IsZero() {
+		t.Errorf("Stats() has %v, %v for the unused pattern, want an entry without a match", st, ok)
+	}
+
+	if got, want := s.UnusedSince(time.Hour), []string{"news.*", "weather"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnusedSince(1h) = %v, want %v", got, want)
+	}
+	if err := s.Unsubscribe("weather"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnsubscribePattern("news.*"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	want := []string{"finance.eu", "ping", "sports.*.scores"}
+	if got := s.UnusedSince(5 * time.Millisecond); !reflect.DeepEqual(got, want) {
+		t.Errorf("UnusedSince(5ms) = %v, want %v", got, want)
+	}
+}
+
+// A message counts once for each subscription that matches it.
+func TestUsageOverlapping(t *testing.T) {
+	url := testURL(t)
+	p := newTestPublisher(t, url, Options{})
+	s := newTestSubscriber(t, url, Options{}, "a", "a.b", "ping")
+	if err := s.SubscribePattern("a.*"); err != nil {
+		t.Fatal(err)
+	}
+	waitFlow(t, p, s)
+
+	publishAll(t, p, "a.b", "a.c.d")
+	receiveTopics(t, s, 2)
+	stats := s.Stats()
+	for name, want := range map[string]uint64{"a": 2, "a.b": 1, "a.*": 1} {
+		if got := stats[name].Matched; got != want {
+			t.Errorf("Stats()[%q].Matched = %d, want %d", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package pubsub
+
+// Version is the version of this package, following semantic versioning.
+//
+// The exported API is recorded in api.txt. While the major version is 0,
+// the API may still change between minor versions, but never by accident:
+// go test fails when the exported API no longer matches api.txt. To
+// release a new version,
+//
+//  1. run go test ./...; if the API changed on purpose, run
+//     go test -run TestAPI -update and bump the minor version (or the
+//     patch version if the API did not change),
+//  2. update Version,
+//  3. commit and tag the commit with the same version, e.g. git tag v0.2.0.
+const Version = "v0.2.0"
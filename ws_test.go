@@ -0,0 +1,40 @@
+package pubsub
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+// Messages go through WebSocket connections, with and without TLS, and a
+// publisher at port 0 reports the port it got.
+func TestWebSocket(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	pubTLS := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "publisher", x509.ExtKeyUsageServerAuth)}}
+	subTLS := &tls.Config{RootCAs: ca.pool}
+	tests := []struct {
+		url              string
+		pubOpts, subOpts Options
+	}{
+		{"ws://127.0.0.1:0/pubsub", Options{}, Options{}},
+		{"wss://127.0.0.1:0/pubsub", Options{TLSConfig: pubTLS}, Options{TLSConfig: subTLS}},
+	}
+	for _, tt := range tests {
+		t.Run(scheme(tt.url), func(t *testing.T) {
+			p := newTestPublisher(t, tt.url, tt.pubOpts)
+			if strings.Contains(p.Addr(), ":0/") || !strings.HasSuffix(p.Addr(), "/pubsub") {
+				t.Fatalf("Addr() = %s, want the port that was picked and the path", p.Addr())
+			}
+			s := newTestSubscriber(t, p.Addr(), tt.subOpts, "weather", "ping")
+			waitFlow(t, p, s)
+			if err := p.Publish("weather", "sunny"); err != nil {
+				t.Fatal(err)
+			}
+			msg := receiveTopics(t, s, 1)[0]
+			if string(msg.Payload) != "sunny" || msg.Peer == nil || msg.Peer.Transport != scheme(tt.url) {
+				t.Errorf("received %q over %+v, want sunny over %s", msg.Payload, msg.Peer, scheme(tt.url))
+			}
+		})
+	}
+}